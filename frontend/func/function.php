@@ -1,7 +1,14 @@
 <html>
 
 <?php
-function sendrequest(string $request, string $type)
+// The REST API of the node the frontend talks to, set with MOCKCHAIN_API
+function apiurl(string $path)
+{
+  $base = getenv('MOCKCHAIN_API') ?: 'http://localhost:58080/api/v1/';
+  return rtrim($base, '/') . '/' . $path;
+}
+
+function sendrequest(string $request, string $type, $body = null)
 {
   $curl = curl_init();
   curl_setopt_array($curl, array(
@@ -14,6 +21,14 @@ function sendrequest(string $request, string $type)
     CURLOPT_HTTP_VERSION => CURL_HTTP_VERSION_1_1,
     CURLOPT_CUSTOMREQUEST => $type,
   ));
+  if ($body !== null) {
+    // Sending coins needs the token the node was started with
+    curl_setopt($curl, CURLOPT_POSTFIELDS, json_encode($body));
+    curl_setopt($curl, CURLOPT_HTTPHEADER, array(
+      'Content-Type: application/json',
+      'Authorization: Bearer ' . getenv('MOCKCHAIN_API_TOKEN'),
+    ));
+  }
 
   $response = curl_exec($curl);
   $responseinfo = curl_getinfo($curl);
@@ -11,11 +11,10 @@
 
   <?php include "func/navbar.php";
 
-  $port = "83.212.80.139:58080/";
-  $response = sendrequest('http://' . $port . 'balance', 'GET');
+  $response = sendrequest(apiurl('wallet'), 'GET');
 
   if ($response["code"] == 200) {
-    $balance = $response["response"];
+    $balance = json_decode($response["response"])->balance;
   } else {
     $balance = 55;
   }
@@ -63,12 +62,9 @@
         </div>
         <div class="overflow-auto" style="max-width: 100%; max-height: 200px; background-color: #222222">
           <?php
-          $port = "83.212.80.139:58080/";
-          $response = sendrequest('http://' . $port . 'history', 'GET');
+          $response = sendrequest(apiurl('wallet/history'), 'GET');
 
           if ($response["code"] == 200) {
-            $balance = $response["response"];
-
             $json = json_decode($response["response"]);
 
             echo '<table class="table table-hover caption-top">
@@ -83,12 +79,12 @@
       </thead>
       <tbody>';
             $i = 0;
-            foreach ($json->transactionList as $key => $transaction) {
+            foreach ($json as $key => $transaction) {
 
               echo     '<tr class = "text-white">
         <th scope="row">' . $i . '</th>
-        <td>' . $transaction->fromTo . '</td>
-        <td>' . $transaction->node . '</td>
+        <td>' . $transaction->direction . '</td>
+        <td>' . htmlspecialchars($transaction->counterparty) . '</td>
         <td>' . $transaction->amount . '</td>
         </tr>';
               $i += 1;
@@ -101,7 +97,6 @@
 
       </div>
       <?php
-      $port = "83.212.80.139:58080/";
       if ($_SERVER["REQUEST_METHOD"] == 'POST') {
         $flag = 0;
         if (empty($_POST["receiver"])) {
@@ -128,8 +123,9 @@
           <?php
         } else {
 
-          $response = sendrequest('http://' . $port . 'transaction/id0/' . $receiver . '/' . $amount, 'POST');
-          if ($response["code"] == 200) {
+          $payment = array("receiver" => $receiver, "amount" => (int)$amount);
+          $response = sendrequest(apiurl('transactions'), 'POST', array("payments" => array($payment)));
+          if ($response["code"] == 201) {
           ?>
             <br><br>
             <div class="container  card d-flex justify-content-center" style="background-color: #222222;">
@@ -142,14 +138,15 @@
             </div>
           <?php
           } else {
+            $error = json_decode($response["response"]);
           ?>
             <br><br>
             <div class="container  card d-flex justify-content-center" style="background-color: #222222;">
               <div class="card-header d-flex justify-content-center">
                 Response
               </div>
-              <div class="card-body d-flex justify-content-center bg-success text-white">
-                Success!
+              <div class="card-body d-flex justify-content-center bg-danger text-white">
+                <?php echo htmlspecialchars($error->error->message ?? "The node could not be reached"); ?>
               </div>
             </div>
       <?php
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"noobcash/base58"
+	"noobcash/signature"
+)
+
+/*
+Coins are paid to addresses rather than to public keys. An address is the type of what can spend
+the coins and the first 20 bytes of the SHA-256 of it in its canonical encoding (see hashing.go):
+
+  - a key address is spent by a signature of the key, and starts with 'N' when shown
+  - a multisig address is spent by enough signatures of the keys of a MultisigPolicy (see
+    multisig.go), and starts with 'M'
+  - a script address is spent by whoever can unlock the locking script the output carries (see
+    the script package), and starts with 'S'. The address is the hash of the script itself
+
+Addresses are shown as Base58Check with a version byte for each type. The checksum catches
+mistyped addresses before any coins are sent to them.
+
+Transactions still carry the full public key of the sender, since it is needed to check the
+signature, and the outputs it spends must be paid to the address of that key.
+*/
+type AddressType uint8
+
+const (
+	KeyAddress AddressType = iota
+	MultisigAddress
+	ScriptAddress
+)
+
+type Address struct {
+	Type AddressType
+	Hash [20]byte
+}
+
+// The Base58Check version byte of each address type
+var addressVersions = map[AddressType]byte{
+	KeyAddress:      0x35,
+	MultisigAddress: 0x32,
+	ScriptAddress:   0x3f,
+}
+
+var errWrongAddressVersion = errors.New("not a noobcash address")
+
+func hashAddress(addressType AddressType, encoded []byte) Address {
+	address := Address{Type: addressType}
+	hash := sha256.Sum256(encoded)
+	copy(address.Hash[:], hash[:])
+	return address
+}
+
+func addressOf(publicKey signature.PublicKey) Address {
+	return hashAddress(KeyAddress, appendPublicKey(nil, publicKey))
+}
+
+func scriptAddress(lockingScript []byte) Address {
+	return hashAddress(ScriptAddress, lockingScript)
+}
+
+func parseAddress(encoded string) (Address, error) {
+	var address Address
+
+	version, payload, err := base58.CheckDecode(encoded)
+	if err != nil {
+		return address, err
+	}
+	if len(payload) != len(address.Hash) {
+		return address, errWrongAddressVersion
+	}
+
+	for addressType, addressVersion := range addressVersions {
+		if version == addressVersion {
+			address.Type = addressType
+			copy(address.Hash[:], payload)
+			return address, nil
+		}
+	}
+	return address, errWrongAddressVersion
+}
+
+func (address Address) String() string {
+	return base58.CheckEncode(addressVersions[address.Type], address.Hash[:])
+}
+
+// Addresses are written as their Base58Check string in JSON
+func (address Address) MarshalText() ([]byte, error) {
+	return []byte(address.String()), nil
+}
+
+func (address *Address) UnmarshalText(text []byte) error {
+	parsed, err := parseAddress(string(text))
+	if err != nil {
+		return err
+	}
+	*address = parsed
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+/*
+A transaction is checked as soon as it reaches us, before it goes into the mempool or on to the
+other nodes. Its inputs must be unspent, either in the committed UTXOs or as outputs of
+transactions in the mempool. The peer that sent a transaction we turn away gets a RejectMessage
+saying why, except when we already had it: with every node passing transactions on, copies of
+the same transaction arriving from several peers are normal.
+*/
+type RejectCode int
+
+const (
+	RejectMalformed RejectCode = iota + 1
+	RejectDuplicate
+	RejectInvalidSignature
+	RejectUnknownNode
+	RejectMissingInputs
+	RejectInvalidAmounts
+	RejectInvalidOutputs
+	RejectDoubleSpend
+	RejectMempoolFull
+	RejectScriptFailed
+)
+
+func (code RejectCode) String() string {
+	switch code {
+	case RejectMalformed:
+		return "malformed"
+	case RejectDuplicate:
+		return "duplicate"
+	case RejectInvalidSignature:
+		return "invalid signature"
+	case RejectUnknownNode:
+		return "unknown node"
+	case RejectMissingInputs:
+		return "missing inputs"
+	case RejectInvalidAmounts:
+		return "invalid amounts"
+	case RejectInvalidOutputs:
+		return "invalid outputs"
+	case RejectDoubleSpend:
+		return "double spend"
+	case RejectMempoolFull:
+		return "mempool full"
+	case RejectScriptFailed:
+		return "script failed"
+	default:
+		return fmt.Sprintf("reject code %d", int(code))
+	}
+}
+
+type RejectError struct {
+	Code   RejectCode
+	Reason string
+}
+
+func (rejectError *RejectError) Error() string {
+	return rejectError.Code.String() + ": " + rejectError.Reason
+}
+
+// Check a new transaction and add it to the mempool
+func (node *Node) admitTransaction(signedTransaction SignedTransaction) *RejectError {
+	node.blockchainLock.Lock()
+	defer node.blockchainLock.Unlock()
+
+	return node.admit(signedTransaction)
+}
+
+// admitTransaction for a caller that already holds blockchainLock
+func (node *Node) admit(signedTransaction SignedTransaction) *RejectError {
+	if _, onChain := node.chainTransactions[signedTransaction.TransactionID]; onChain || node.mempool.Contains(signedTransaction.TransactionID) {
+		return errAlreadyKnown
+	}
+
+	// Until it is mined, the transaction is checked as if it went in the next block
+	if rejectError := node.checkTransaction(signedTransaction, node.spendableUTXO, node.minedAt, uint(len(node.blockchain))); rejectError != nil {
+		return rejectError
+	}
+
+	if rejectError := node.mempool.Add(signedTransaction); rejectError != nil {
+		return rejectError
+	}
+	if node.publicKey.Equal(signedTransaction.SenderAddress) {
+		node.walletApply(signedTransaction)
+	}
+	node.events.Publish(Event{Type: TransactionEvent, Data: signedTransaction})
+	return nil
+}
+
+// An output a new transaction can spend: a committed one or one a transaction of the mempool creates
+func (node *Node) spendableUTXO(id [32]byte) (TransactionOutput, bool) {
+	if transactionOutput, ok := node.UTXOsCommitted.Get(id); ok {
+		return transactionOutput, true
+	}
+	return node.mempool.Creates(id)
+}
+
+/*
+A transaction of ours was admitted, or any transaction was mined: the coins it spends leave the
+wallet and its outputs to us join it. Our own outputs can be spent right away, while the ones we
+receive from others only once they are mined. Applying a transaction twice changes nothing.
+*/
+func (node *Node) walletApply(signedTransaction SignedTransaction) {
+	for _, transactionInput := range signedTransaction.TransactionInputs {
+		node.myUTXOs.Remove(transactionInput.PreviousOutputID)
+	}
+	for _, transactionOutput := range signedTransaction.TransactionOutputs {
+		if transactionOutput.RecipientAddress == node.walletAddress && !node.myUTXOs.Contains(transactionOutput.ID) {
+			node.myUTXOs.Push(transactionOutput)
+		}
+	}
+}
+
+func (node *Node) getTransactionMessage(message Message, peer *Peer) error {
+	signedTransaction, err := node.receiveTransactionMessage(message)
+	if err != nil {
+		return err
+	}
+
+	if rejectError := node.admitTransaction(signedTransaction); rejectError != nil {
+		if rejectError.Code != RejectDuplicate {
+			log.Printf("getTransactionMessage: transaction %x from %v rejected - %v", signedTransaction.TransactionID, peer.getID(), rejectError)
+			node.sendRejectMessage(peer, signedTransaction.TransactionID, rejectError)
+		}
+		return nil
+	}
+
+	node.relayTransaction(signedTransaction, peer)
+	return nil
+}
+
+// Pass a transaction we accepted on to every other peer
+func (node *Node) relayTransaction(signedTransaction SignedTransaction, from *Peer) {
+	for _, peer := range node.peers() {
+		if peer != from {
+			node.sendTransactionMessage(peer, signedTransaction)
+		}
+	}
+}
+
+func (node *Node) sendRejectMessage(peer *Peer, transactionID [32]byte, rejectError *RejectError) {
+	rejectMessage := RejectMessage{
+		TransactionID: transactionID,
+		Code:          rejectError.Code,
+		Reason:        rejectError.Reason,
+	}
+
+	sendMessage(peer, RejectMessageType, rejectMessage)
+}
+
+func (node *Node) receiveRejectMessage(message Message, peer *Peer) error {
+	var rejectMessage RejectMessage
+	err := message.decode(&rejectMessage)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("receiveRejectMessage: %v rejected transaction %x - %v: %v", peer.getID(), rejectMessage.TransactionID, rejectMessage.Code, rejectMessage.Reason)
+	return nil
+}
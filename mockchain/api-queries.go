@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"noobcash/script"
+)
+
+/*
+What the REST API (api.go) and the JSON-RPC server (rpc.go) answer with, and what some commands of
+the console (cli.go) print. The queries fail with an apiError, whose HTTP status the RPC server
+turns into one of its error codes.
+*/
+
+// An error with the HTTP status it is answered with
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (err *apiError) Error() string {
+	return err.Message
+}
+
+func apiErrorf(status int, format string, args ...any) *apiError {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// The Index of the tip of the main chain
+func (node *Node) tipIndex() uint {
+	node.blockchainLock.Lock()
+	defer node.blockchainLock.Unlock()
+
+	return uint(len(node.blockchain) - 1)
+}
+
+func (node *Node) queryStatus() APIStatus {
+	node.blockchainLock.Lock()
+	tip := node.blockchain[len(node.blockchain)-1]
+	node.blockchainLock.Unlock()
+
+	return APIStatus{
+		ID:          node.id,
+		Address:     node.walletAddress,
+		Height:      tip.Index,
+		TipHash:     hex.EncodeToString(tip.Hash[:]),
+		MempoolSize: node.mempool.Size(),
+		Peers:       len(node.peers()),
+		Params:      node.params,
+	}
+}
+
+// The headers of up to limit blocks, from the block with Index from down to the genesis block
+func (node *Node) queryBlocks(from uint, limit int) []APIBlockSummary {
+	node.blockchainLock.Lock()
+	if from >= uint(len(node.blockchain)) {
+		from = uint(len(node.blockchain) - 1)
+	}
+	blocks := make([]HashedBlock, 0, limit)
+	for index := int(from); index >= 0 && len(blocks) < limit; index-- {
+		blocks = append(blocks, node.blockchain[index])
+	}
+	node.blockchainLock.Unlock()
+
+	summaries := make([]APIBlockSummary, 0, len(blocks))
+	for _, block := range blocks {
+		summaries = append(summaries, node.apiBlockSummary(block))
+	}
+	return summaries
+}
+
+func (node *Node) queryBlockByIndex(index uint) (APIBlock, error) {
+	node.blockchainLock.Lock()
+	if index >= uint(len(node.blockchain)) {
+		node.blockchainLock.Unlock()
+		return APIBlock{}, apiErrorf(http.StatusNotFound, "block %v is not on the main chain", index)
+	}
+	block := node.blockchain[index]
+	tipIndex := uint(len(node.blockchain) - 1)
+	node.blockchainLock.Unlock()
+
+	return node.apiBlock(block, tipIndex), nil
+}
+
+func (node *Node) queryBlockByHash(hash [32]byte) (APIBlock, error) {
+	node.blockchainLock.Lock()
+	tipIndex := uint(len(node.blockchain) - 1)
+	for _, block := range node.blockchain {
+		if block.Hash == hash {
+			node.blockchainLock.Unlock()
+			return node.apiBlock(block, tipIndex), nil
+		}
+	}
+	node.blockchainLock.Unlock()
+	return APIBlock{}, apiErrorf(http.StatusNotFound, "block %x is not on the main chain", hash)
+}
+
+// A transaction on the main chain or in the mempool
+func (node *Node) queryTransaction(transactionID [32]byte) (APITransaction, error) {
+	node.blockchainLock.Lock()
+	tipIndex := uint(len(node.blockchain) - 1)
+	if index, onChain := node.chainTransactions[transactionID]; onChain {
+		for _, transaction := range node.blockchain[index].Transactions {
+			if transaction.TransactionID == transactionID {
+				node.blockchainLock.Unlock()
+				return node.apiTransaction(transaction, &index, tipIndex), nil
+			}
+		}
+	}
+	node.blockchainLock.Unlock()
+
+	for _, transaction := range node.mempool.Transactions() {
+		if transaction.TransactionID == transactionID {
+			return node.apiTransaction(transaction, nil, tipIndex), nil
+		}
+	}
+	return APITransaction{}, apiErrorf(http.StatusNotFound, "transaction %x is neither on the main chain nor in the mempool", transactionID)
+}
+
+// Send coins from the wallet of the node, with the transaction once it is in the mempool
+func (node *Node) sendPayments(request APITransactionRequest) (APITransaction, error) {
+	if len(request.Payments) == 0 {
+		return APITransaction{}, apiErrorf(http.StatusBadRequest, "no payments given")
+	}
+
+	payments := make([]Payment, 0, len(request.Payments))
+	for _, payment := range request.Payments {
+		address, err := node.parseReceiver(payment.Receiver)
+		if err != nil {
+			return APITransaction{}, apiErrorf(http.StatusBadRequest, "%v", err)
+		}
+		if payment.Amount == 0 {
+			return APITransaction{}, apiErrorf(http.StatusBadRequest, "the amount paid to %v must be positive", payment.Receiver)
+		}
+		payments = append(payments, Payment{Address: address, Amount: payment.Amount})
+	}
+
+	signedTransaction, err := node.makeTransaction(payments, request.Fee, nil)
+	if err != nil {
+		return APITransaction{}, apiErrorf(http.StatusUnprocessableEntity, "the transaction could not be made: %v", err)
+	}
+	return node.apiTransaction(signedTransaction, nil, node.tipIndex()), nil
+}
+
+// An address is given by itself or by the id of its node
+func (node *Node) queryAddress(param string) (Address, error) {
+	address, err := node.parseReceiver(param)
+	if err != nil {
+		return Address{}, apiErrorf(http.StatusNotFound, "%v", err)
+	}
+	return address, nil
+}
+
+// The confirmed coins of an address
+func (node *Node) queryBalance(address Address) APIBalance {
+	node.blockchainLock.Lock()
+	transactionOutputs := node.UTXOsCommitted.OutputsOf(address)
+	node.blockchainLock.Unlock()
+
+	balance := APIBalance{Address: address, ID: node.idOfAddress(address), Outputs: len(transactionOutputs)}
+	for _, transactionOutput := range transactionOutputs {
+		balance.Balance += transactionOutput.Amount
+	}
+	return balance
+}
+
+// The confirmed unspent outputs of an address, by id
+func (node *Node) queryUTXOs(address Address) []APIOutput {
+	node.blockchainLock.Lock()
+	transactionOutputs := node.UTXOsCommitted.OutputsOf(address)
+	node.blockchainLock.Unlock()
+
+	sort.Slice(transactionOutputs, func(i, j int) bool {
+		return bytes.Compare(transactionOutputs[i].ID[:], transactionOutputs[j].ID[:]) < 0
+	})
+
+	response := make([]APIOutput, 0, len(transactionOutputs))
+	for _, transactionOutput := range transactionOutputs {
+		response = append(response, node.apiOutput(transactionOutput))
+	}
+	return response
+}
+
+// What the node paid and was paid, in the order of transactionHistory
+func (node *Node) queryHistory() []APIHistoryEntry {
+	response := make([]APIHistoryEntry, 0)
+
+	for _, transaction := range node.transactionHistory() {
+		entry := APIHistoryEntry{
+			TransactionID: hex.EncodeToString(transaction.TransactionID[:]),
+			Status:        "confirmed",
+		}
+		if node.mempool.Contains(transaction.TransactionID) {
+			entry.Status = "pending"
+		}
+
+		if transaction.senderAddress() == node.walletAddress {
+			entry.Direction = "to"
+			entry.Counterparty = node.recipientIDs(transaction)
+			entry.Amount = transaction.amountPaid()
+		} else {
+			for _, transactionOutput := range transaction.payments() {
+				if transactionOutput.RecipientAddress == node.walletAddress {
+					entry.Amount += transactionOutput.Amount
+				}
+			}
+			if entry.Amount == 0 {
+				continue
+			}
+			entry.Direction = "from"
+			entry.Counterparty = node.addressName(transaction.senderAddress())
+		}
+
+		response = append(response, entry)
+	}
+	return response
+}
+
+// Every other node we know of, with the state of our connection to it
+func (node *Node) queryPeers() []APIPeer {
+	connected := make(map[string]*Peer)
+	for _, peer := range node.peers() {
+		connected[peer.getID()] = peer
+	}
+
+	node.connectionLock.Lock()
+	dialing := make(map[string]bool)
+	for id := range node.dialing {
+		dialing[id] = true
+	}
+	node.connectionLock.Unlock()
+
+	response := make([]APIPeer, 0)
+	for _, id := range node.nodeIDs() {
+		if id == node.id {
+			continue
+		}
+		nodeData, _ := node.getNodeData(id)
+		apiPeer := APIPeer{ID: id, Address: nodeData.Address}
+		if !nodeData.PublicKey.IsZero() {
+			walletAddress := addressOf(nodeData.PublicKey)
+			apiPeer.WalletAddress = &walletAddress
+		}
+
+		if peer, ok := connected[id]; ok {
+			apiPeer.State = "connected"
+			apiPeer.RTT = float64(peer.getRTT().Microseconds()) / 1000
+			lastSeen := peer.getLastSeen()
+			apiPeer.LastSeen = &lastSeen
+		} else if nodeData.Departed {
+			apiPeer.State = "left"
+		} else if dialing[id] {
+			apiPeer.State = "connecting"
+		} else {
+			apiPeer.State = "disconnected"
+		}
+		response = append(response, apiPeer)
+	}
+	return response
+}
+
+func (node *Node) queryMempool() APIMempool {
+	transactions := node.mempool.Transactions()
+	tipIndex := node.tipIndex()
+
+	response := APIMempool{Size: len(transactions), Transactions: make([]APITransaction, 0, len(transactions))}
+	for _, transaction := range transactions {
+		response.Transactions = append(response.Transactions, node.apiTransaction(transaction, nil, tipIndex))
+	}
+	return response
+}
+
+/*
+The conversions below call addressName, which takes nodeDataLock, so they must be made after
+blockchainLock is released.
+*/
+
+func (node *Node) apiBlockSummary(block HashedBlock) APIBlockSummary {
+	summary := APIBlockSummary{
+		Index:            block.Index,
+		Hash:             hex.EncodeToString(block.Hash[:]),
+		PreviousHash:     hex.EncodeToString(block.PreviousHash[:]),
+		MerkleRoot:       hex.EncodeToString(block.MerkleRoot[:]),
+		WitnessRoot:      hex.EncodeToString(block.WitnessRoot[:]),
+		Nonce:            hex.EncodeToString(block.Nonce[:]),
+		Target:           hex.EncodeToString(block.Target[:]),
+		Timestamp:        block.Timestamp,
+		TransactionCount: len(block.Transactions),
+	}
+	if block.Index > 0 && len(block.Transactions) > 0 && isCoinbase(block.Transactions[0]) && len(block.Transactions[0].TransactionOutputs) > 0 {
+		summary.Miner = node.addressName(block.Transactions[0].TransactionOutputs[0].RecipientAddress)
+	}
+	return summary
+}
+
+func (node *Node) apiBlock(block HashedBlock, tipIndex uint) APIBlock {
+	response := APIBlock{APIBlockSummary: node.apiBlockSummary(block), Transactions: make([]APITransaction, 0, len(block.Transactions))}
+	for _, transaction := range block.Transactions {
+		response.Transactions = append(response.Transactions, node.apiTransaction(transaction, &block.Index, tipIndex))
+	}
+	return response
+}
+
+// The transaction, confirmed in the block with Index blockIndex, or still pending if that is nil
+func (node *Node) apiTransaction(transaction SignedTransaction, blockIndex *uint, tipIndex uint) APITransaction {
+	response := APITransaction{
+		ID:       hex.EncodeToString(transaction.TransactionID[:]),
+		Coinbase: isCoinbase(transaction),
+		Fee:      transaction.Fee,
+		Inputs:   make([]APIInput, 0, len(transaction.TransactionInputs)),
+		Outputs:  make([]APIOutput, 0, len(transaction.TransactionOutputs)),
+		Status:   "pending",
+	}
+	if blockIndex != nil {
+		index := *blockIndex
+		response.Status = "confirmed"
+		response.BlockIndex = &index
+		response.Confirmations = tipIndex - index + 1
+	}
+
+	if transaction.Multisig != nil || !isZeroPublicKey(transaction.SenderAddress) {
+		senderAddress := transaction.senderAddress()
+		response.Sender = &senderAddress
+		response.SenderID = node.idOfAddress(senderAddress)
+	}
+
+	for _, transactionInput := range transaction.TransactionInputs {
+		response.Inputs = append(response.Inputs, APIInput{
+			PreviousOutputID: hex.EncodeToString(transactionInput.PreviousOutputID[:]),
+			UnlockingScript:  disassembleScript(transactionInput.UnlockingScript),
+		})
+	}
+	for _, transactionOutput := range transaction.TransactionOutputs {
+		response.Outputs = append(response.Outputs, node.apiOutput(transactionOutput))
+	}
+	return response
+}
+
+func (node *Node) apiOutput(transactionOutput TransactionOutput) APIOutput {
+	return APIOutput{
+		ID:            hex.EncodeToString(transactionOutput.ID[:]),
+		TransactionID: hex.EncodeToString(transactionOutput.TransactionID[:]),
+		Address:       transactionOutput.RecipientAddress,
+		Owner:         node.idOfAddress(transactionOutput.RecipientAddress),
+		Amount:        transactionOutput.Amount,
+		LockingScript: disassembleScript(transactionOutput.LockingScript),
+	}
+}
+
+// Empty for no script, so that it is left out of the JSON
+func disassembleScript(scriptBytes []byte) string {
+	if len(scriptBytes) == 0 {
+		return ""
+	}
+	return script.Disassemble(scriptBytes)
+}
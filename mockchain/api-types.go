@@ -0,0 +1,118 @@
+package main
+
+import "time"
+
+/*
+The JSON the REST API answers with (see api.go). The fields are named the way openapi.json
+describes them, so renaming one changes the API.
+*/
+
+type APIStatus struct {
+	ID          string      `json:"id"`
+	Address     Address     `json:"address"`
+	Height      uint        `json:"height"` // the Index of the tip of the main chain
+	TipHash     string      `json:"tipHash"`
+	MempoolSize int         `json:"mempoolSize"`
+	Peers       int         `json:"peers"` // the peers we are connected to
+	Params      ChainParams `json:"params"`
+}
+
+type APIBlockSummary struct {
+	Index            uint   `json:"index"`
+	Hash             string `json:"hash"`
+	PreviousHash     string `json:"previousHash"`
+	MerkleRoot       string `json:"merkleRoot"`
+	WitnessRoot      string `json:"witnessRoot"`
+	Nonce            string `json:"nonce"`
+	Target           string `json:"target"`
+	Timestamp        int64  `json:"timestamp"`       // milliseconds since the epoch
+	Miner            string `json:"miner,omitempty"` // who the coinbase pays, empty for the genesis block
+	TransactionCount int    `json:"transactionCount"`
+}
+
+type APIBlock struct {
+	APIBlockSummary
+	Transactions []APITransaction `json:"transactions"`
+}
+
+type APITransaction struct {
+	ID            string      `json:"id"`
+	Sender        *Address    `json:"sender,omitempty"`   // nil for a coinbase
+	SenderID      string      `json:"senderId,omitempty"` // the id of the sender's node, if it is one
+	Coinbase      bool        `json:"coinbase"`
+	Fee           uint        `json:"fee"`
+	Inputs        []APIInput  `json:"inputs"`
+	Outputs       []APIOutput `json:"outputs"`
+	Status        string      `json:"status"`               // "confirmed" or "pending"
+	BlockIndex    *uint       `json:"blockIndex,omitempty"` // the block the transaction is confirmed in
+	Confirmations uint        `json:"confirmations"`        // that block and the ones on top of it
+}
+
+type APIInput struct {
+	PreviousOutputID string `json:"previousOutputId"`
+	UnlockingScript  string `json:"unlockingScript,omitempty"`
+}
+
+type APIOutput struct {
+	ID            string  `json:"id"`
+	TransactionID string  `json:"transactionId"`
+	Address       Address `json:"address"`
+	Owner         string  `json:"owner,omitempty"` // the id of the node the address belongs to
+	Amount        uint    `json:"amount"`
+	LockingScript string  `json:"lockingScript,omitempty"`
+}
+
+// The confirmed coins of an address
+type APIBalance struct {
+	Address Address `json:"address"`
+	ID      string  `json:"id,omitempty"`
+	Balance uint    `json:"balance"`
+	Outputs int     `json:"outputs"` // the number of unspent outputs the balance is made of
+}
+
+// A balance event of the event stream
+type APIBalanceChange struct {
+	APIBalance
+	Previous uint `json:"previous"` // the balance before, the same as Balance in the first event of the stream
+}
+
+type APIHistoryEntry struct {
+	TransactionID string `json:"transactionId"`
+	Direction     string `json:"direction"`    // "to" for what the node paid, "from" for what it was paid
+	Counterparty  string `json:"counterparty"` // the ids or addresses on the other side, separated by commas
+	Amount        uint   `json:"amount"`
+	Status        string `json:"status"`
+}
+
+type APIPeer struct {
+	ID            string     `json:"id"`
+	Address       string     `json:"address"` // ip:port
+	WalletAddress *Address   `json:"walletAddress,omitempty"`
+	State         string     `json:"state"`         // "connected", "connecting", "disconnected" or "left"
+	RTT           float64    `json:"rtt,omitempty"` // milliseconds
+	LastSeen      *time.Time `json:"lastSeen,omitempty"`
+}
+
+// A reorg event of the event stream
+type APIReorg struct {
+	ForkIndex    uint   `json:"forkIndex"` // the Index of the last block both branches share
+	OldTip       string `json:"oldTip"`
+	NewTip       string `json:"newTip"`
+	Disconnected int    `json:"disconnected"` // the blocks of the old branch taken off the main chain
+	Connected    int    `json:"connected"`    // the blocks of the new branch put on it
+}
+
+type APIMempool struct {
+	Size         int              `json:"size"`
+	Transactions []APITransaction `json:"transactions"` // in the order they arrived
+}
+
+type APIPaymentRequest struct {
+	Receiver string `json:"receiver"` // the id of a node or an address
+	Amount   uint   `json:"amount"`
+}
+
+type APITransactionRequest struct {
+	Payments []APIPaymentRequest `json:"payments"`
+	Fee      uint                `json:"fee"`
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Every node can serve a REST API on the address given with -httpAddress. The routes live under
+/api/v1/ and are described in openapi.json, which is served at /api/v1/openapi.json (everything
+else on the address is the block explorer, see explorer.go):
+
+	GET  status                     the node, the tip of its chain and its peer and mempool counts
+	GET  blocks?from=&limit=        block headers, newest first
+	GET  blocks/<index|hash>        a block of the main chain with its transactions
+	GET  transactions/<id>          a transaction on the main chain or in the mempool
+	POST transactions               send coins from the node's wallet
+	GET  addresses/<address|id>     the confirmed balance of an address
+	GET  addresses/<address|id>/utxos
+	GET  wallet                     the node's own balance
+	GET  wallet/history             the transactions that paid the node or were paid by it
+	GET  peers
+	GET  mempool
+	GET  events?type=&address=      a stream of server-sent events (see event-stream.go)
+
+Hashes and ids are hex, scripts are in the words of script.Disassemble and amounts are coins.
+The routes that spend the node's coins are turned off unless the node has an -apiToken, which
+requests must then send as "Authorization: Bearer <token>". Since any web page can make a browser
+send a request, they must also be JSON and can't come from a page of another origin.
+Errors come back with the HTTP status of the failure and a JSON body {"error": {"status", "message"}}.
+*/
+
+const apiPrefix = "/api/v1/"
+
+const defaultBlockLimit = 20
+const maxBlockLimit = 100
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+type apiRoute struct {
+	method  string
+	pattern string // the segments of the path after apiPrefix, with * matching any one segment
+	status  int    // the status of a successful response
+	spends  bool   // the route spends the node's coins, so it needs the token
+	handler func(node *Node, request *http.Request, params []string) (any, error)
+}
+
+var apiRoutes = []apiRoute{
+	{http.MethodGet, "status", http.StatusOK, false, (*Node).apiGetStatus},
+	{http.MethodGet, "blocks", http.StatusOK, false, (*Node).apiGetBlocks},
+	{http.MethodGet, "blocks/*", http.StatusOK, false, (*Node).apiGetBlock},
+	{http.MethodGet, "transactions/*", http.StatusOK, false, (*Node).apiGetTransaction},
+	{http.MethodPost, "transactions", http.StatusCreated, true, (*Node).apiPostTransaction},
+	{http.MethodGet, "addresses/*", http.StatusOK, false, (*Node).apiGetAddress},
+	{http.MethodGet, "addresses/*/utxos", http.StatusOK, false, (*Node).apiGetUTXOs},
+	{http.MethodGet, "wallet", http.StatusOK, false, (*Node).apiGetWallet},
+	{http.MethodGet, "wallet/history", http.StatusOK, false, (*Node).apiGetHistory},
+	{http.MethodGet, "peers", http.StatusOK, false, (*Node).apiGetPeers},
+	{http.MethodGet, "mempool", http.StatusOK, false, (*Node).apiGetMempool},
+}
+
+func (node *Node) serveAPI(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiPrefix, node.handleAPI)
+	mux.HandleFunc(apiPrefix+"events", node.handleEvents)
+	mux.HandleFunc(apiPrefix+"openapi.json", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(openAPIDocument)
+	})
+	mux.Handle("/", explorerHandler())
+
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Println("serveAPI: listening on", address)
+	if err := server.ListenAndServe(); err != nil {
+		log.Println("serveAPI:", err)
+	}
+}
+
+// Find the route of the request and answer with what its handler returns
+func (node *Node) handleAPI(writer http.ResponseWriter, request *http.Request) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, apiPrefix), "/"), "/")
+
+	node.blockchainLock.Lock()
+	chainLength := len(node.blockchain)
+	node.blockchainLock.Unlock()
+	if chainLength == 0 {
+		writeAPIError(writer, apiErrorf(http.StatusServiceUnavailable, "the node hasn't received the blockchain yet"))
+		return
+	}
+
+	allowed := make([]string, 0)
+	for _, route := range apiRoutes {
+		params, ok := matchRoute(route.pattern, segments)
+		if !ok {
+			continue
+		}
+		if route.method != request.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+
+		if route.spends {
+			if err := node.authorizeSpending(request); err != nil {
+				writeAPIError(writer, err)
+				return
+			}
+		}
+
+		response, err := route.handler(node, request, params)
+		if err != nil {
+			writeAPIError(writer, err)
+			return
+		}
+		writeJSON(writer, route.status, response)
+		return
+	}
+
+	if len(allowed) > 0 {
+		writer.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeAPIError(writer, apiErrorf(http.StatusMethodNotAllowed, "%v is not allowed on %v", request.Method, request.URL.Path))
+		return
+	}
+	writeAPIError(writer, apiErrorf(http.StatusNotFound, "no route %v", request.URL.Path))
+}
+
+/*
+Check a request that spends our coins: it must come with our token, be JSON and not come from a
+page of another origin. Browsers send a form or text/plain POST to any origin without asking
+first, so a page the operator opens could otherwise spend the coins with the operator's access.
+*/
+func (node *Node) authorizeSpending(request *http.Request) error {
+	if err := checkSameOrigin(request); err != nil {
+		return err
+	}
+	if err := node.checkToken(request); err != nil {
+		return err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return apiErrorf(http.StatusUnsupportedMediaType, "the request body must be application/json")
+	}
+	return nil
+}
+
+// Browsers say which page a request comes from in its Origin, which must then be one of ours
+func checkSameOrigin(request *http.Request) error {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if parsed, err := url.Parse(origin); err == nil && parsed.Host == request.Host {
+		return nil
+	}
+	return apiErrorf(http.StatusForbidden, "requests from %v are not allowed", origin)
+}
+
+func (node *Node) checkToken(request *http.Request) error {
+	if node.apiToken == "" {
+		return apiErrorf(http.StatusForbidden, "spending is turned off, the node must be started with -apiToken")
+	}
+
+	token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(node.apiToken)) != 1 {
+		return apiErrorf(http.StatusUnauthorized, "the request must send the token as Authorization: Bearer")
+	}
+	return nil
+}
+
+// The segments the wildcards of the pattern matched, if the path matches it
+func matchRoute(pattern string, segments []string) ([]string, bool) {
+	patternSegments := strings.Split(pattern, "/")
+	if len(patternSegments) != len(segments) {
+		return nil, false
+	}
+
+	params := make([]string, 0)
+	for i, patternSegment := range patternSegments {
+		if patternSegment == "*" {
+			params = append(params, segments[i])
+		} else if patternSegment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func writeJSON(writer http.ResponseWriter, status int, response any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(response); err != nil {
+		log.Println("writeJSON:", err)
+	}
+}
+
+func writeAPIError(writer http.ResponseWriter, err error) {
+	var responseError *apiError
+	if !errors.As(err, &responseError) {
+		log.Println("handleAPI:", err)
+		responseError = &apiError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	if responseError.Status == http.StatusUnauthorized {
+		writer.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	writeJSON(writer, responseError.Status, map[string]*apiError{"error": responseError})
+}
+
+func (node *Node) apiGetStatus(request *http.Request, params []string) (any, error) {
+	return node.queryStatus(), nil
+}
+
+func (node *Node) apiGetBlocks(request *http.Request, params []string) (any, error) {
+	query := request.URL.Query()
+
+	limit := defaultBlockLimit
+	if query.Has("limit") {
+		parsed, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || parsed < 1 || parsed > maxBlockLimit {
+			return nil, apiErrorf(http.StatusBadRequest, "limit must be a number from 1 to %v", maxBlockLimit)
+		}
+		limit = parsed
+	}
+
+	from := node.tipIndex()
+	if query.Has("from") {
+		parsed, err := strconv.ParseUint(query.Get("from"), 10, 32)
+		if err != nil {
+			return nil, apiErrorf(http.StatusBadRequest, "from must be a block index")
+		}
+		from = uint(parsed)
+	}
+
+	return node.queryBlocks(from, limit), nil
+}
+
+// A block of the main chain, by its Index or its hash
+func (node *Node) apiGetBlock(request *http.Request, params []string) (any, error) {
+	if index, err := strconv.ParseUint(params[0], 10, 32); err == nil {
+		return node.queryBlockByIndex(uint(index))
+	}
+	if hash, err := parseHexHash(params[0]); err == nil {
+		return node.queryBlockByHash(hash)
+	}
+	return nil, apiErrorf(http.StatusBadRequest, "%v is neither a block index nor a block hash", params[0])
+}
+
+func (node *Node) apiGetTransaction(request *http.Request, params []string) (any, error) {
+	transactionID, err := parseHexHash(params[0])
+	if err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, "the transaction id %v", err)
+	}
+	return node.queryTransaction(transactionID)
+}
+
+// Send coins from the wallet of the node, answering with the transaction once it is in the mempool
+func (node *Node) apiPostTransaction(request *http.Request, params []string) (any, error) {
+	var body APITransactionRequest
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	return node.sendPayments(body)
+}
+
+func (node *Node) apiGetAddress(request *http.Request, params []string) (any, error) {
+	address, err := node.queryAddress(params[0])
+	if err != nil {
+		return nil, err
+	}
+	return node.queryBalance(address), nil
+}
+
+func (node *Node) apiGetUTXOs(request *http.Request, params []string) (any, error) {
+	address, err := node.queryAddress(params[0])
+	if err != nil {
+		return nil, err
+	}
+	return node.queryUTXOs(address), nil
+}
+
+func (node *Node) apiGetWallet(request *http.Request, params []string) (any, error) {
+	return node.queryBalance(node.walletAddress), nil
+}
+
+func (node *Node) apiGetHistory(request *http.Request, params []string) (any, error) {
+	return node.queryHistory(), nil
+}
+
+func (node *Node) apiGetPeers(request *http.Request, params []string) (any, error) {
+	return node.queryPeers(), nil
+}
+
+func (node *Node) apiGetMempool(request *http.Request, params []string) (any, error) {
+	return node.queryMempool(), nil
+}
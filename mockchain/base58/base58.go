@@ -0,0 +1,116 @@
+/*
+Package base58 encodes bytes with the Base58 alphabet of Bitcoin, which leaves out 0, O, I and l
+so that encoded strings can be read out and typed in without mixing up characters. Leading zero
+bytes are written as leading '1's.
+
+Base58Check adds a version byte in front of the payload and the first 4 bytes of its double
+SHA-256 at the end, so that a mistyped string is caught instead of being read as some other
+payload:
+
+	CheckEncode(version, payload) = Base58(version || payload || SHA-256(SHA-256(version || payload))[:4])
+*/
+package base58
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+const checksumLength = 4
+
+var ErrInvalidCharacter = errors.New("base58: invalid character")
+var ErrInvalidLength = errors.New("base58: too short for a version and a checksum")
+var ErrChecksum = errors.New("base58: checksum mismatch")
+
+// decoded[c] is the value of character c, or -1 if it isn't part of the alphabet
+var decoded [256]int
+
+func init() {
+	for i := range decoded {
+		decoded[i] = -1
+	}
+	for i := 0; i < len(alphabet); i++ {
+		decoded[alphabet[i]] = i
+	}
+}
+
+func Encode(input []byte) string {
+	zeros := 0
+	for zeros < len(input) && input[zeros] == 0 {
+		zeros++
+	}
+
+	radix := big.NewInt(int64(len(alphabet)))
+	number := new(big.Int).SetBytes(input)
+	digit := new(big.Int)
+
+	// The digits come out least significant first
+	encoded := make([]byte, 0, len(input)*138/100+1)
+	for number.Sign() > 0 {
+		number.DivMod(number, radix, digit)
+		encoded = append(encoded, alphabet[digit.Int64()])
+	}
+	for i := 0; i < zeros; i++ {
+		encoded = append(encoded, alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+func Decode(input string) ([]byte, error) {
+	zeros := 0
+	for zeros < len(input) && input[zeros] == alphabet[0] {
+		zeros++
+	}
+
+	radix := big.NewInt(int64(len(alphabet)))
+	number := new(big.Int)
+	for i := zeros; i < len(input); i++ {
+		value := decoded[input[i]]
+		if value < 0 {
+			return nil, ErrInvalidCharacter
+		}
+		number.Mul(number, radix)
+		number.Add(number, big.NewInt(int64(value)))
+	}
+
+	return append(make([]byte, zeros), number.Bytes()...), nil
+}
+
+func checksum(input []byte) []byte {
+	first := sha256.Sum256(input)
+	second := sha256.Sum256(first[:])
+	return second[:checksumLength]
+}
+
+func CheckEncode(version byte, payload []byte) string {
+	input := make([]byte, 0, 1+len(payload)+checksumLength)
+	input = append(input, version)
+	input = append(input, payload...)
+	input = append(input, checksum(input)...)
+	return Encode(input)
+}
+
+// The version byte and the payload of a Base58Check string
+func CheckDecode(input string) (byte, []byte, error) {
+	decoded, err := Decode(input)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(decoded) < 1+checksumLength {
+		return 0, nil, ErrInvalidLength
+	}
+
+	body := decoded[:len(decoded)-checksumLength]
+	if !bytes.Equal(checksum(body), decoded[len(decoded)-checksumLength:]) {
+		return 0, nil, ErrChecksum
+	}
+	return body[0], body[1:], nil
+}
@@ -0,0 +1,89 @@
+package base58
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Known encodings, from the test vectors of Bitcoin Core
+var encodings = []struct {
+	decoded []byte
+	encoded string
+}{
+	{[]byte{}, ""},
+	{[]byte{0x61}, "2g"},
+	{[]byte{0x62, 0x62, 0x62}, "a3gV"},
+	{[]byte{0x63, 0x63, 0x63}, "aPEr"},
+	{[]byte("simply a long string"), "2cFupjhnEsSn59qHXstmK2ffpLv2"},
+	{[]byte{0x00, 0xeb, 0x15, 0x23, 0x1d, 0xfc, 0xeb, 0x60, 0x92, 0x58, 0x86, 0xb6, 0x7d, 0x06, 0x52, 0x99, 0x92, 0x59, 0x15, 0xae, 0xb1, 0x72, 0xc0, 0x66, 0x47}, "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+	{[]byte{0x51, 0x6b, 0x6f, 0xcd, 0x0f}, "ABnLTmg"},
+	{[]byte{0x00, 0x00, 0x00, 0x28, 0x7f, 0xb4, 0xcd}, "111233QC4"},
+	{[]byte{0x00}, "1"},
+	{[]byte{0x00, 0x00, 0x00}, "111"},
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, encoding := range encodings {
+		if encoded := Encode(encoding.decoded); encoded != encoding.encoded {
+			t.Errorf("Encode(%x) = %q, want %q", encoding.decoded, encoded, encoding.encoded)
+		}
+
+		decoded, err := Decode(encoding.encoded)
+		if err != nil {
+			t.Errorf("Decode(%q): %v", encoding.encoded, err)
+			continue
+		}
+		if !bytes.Equal(decoded, encoding.decoded) {
+			t.Errorf("Decode(%q) = %x, want %x", encoding.encoded, decoded, encoding.decoded)
+		}
+	}
+}
+
+func TestDecodeInvalidCharacter(t *testing.T) {
+	for _, input := range []string{"0", "O", "I", "l", "2g ", "a3g+V"} {
+		if _, err := Decode(input); !errors.Is(err, ErrInvalidCharacter) {
+			t.Errorf("Decode(%q): %v", input, err)
+		}
+	}
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	payloads := [][]byte{{}, {0}, {0, 0, 1}, bytes.Repeat([]byte{0xff}, 20)}
+	for _, version := range []byte{0, 0x35, 0xff} {
+		for _, payload := range payloads {
+			gotVersion, gotPayload, err := CheckDecode(CheckEncode(version, payload))
+			if err != nil {
+				t.Errorf("version %v payload %x: %v", version, payload, err)
+				continue
+			}
+			if gotVersion != version || !bytes.Equal(gotPayload, payload) {
+				t.Errorf("version %v payload %x came back as version %v payload %x", version, payload, gotVersion, gotPayload)
+			}
+		}
+	}
+}
+
+// Any one mistyped character is caught by the checksum
+func TestCheckDecodeChecksum(t *testing.T) {
+	encoded := CheckEncode(0x35, bytes.Repeat([]byte{0x42}, 20))
+
+	for i := 0; i < len(encoded); i++ {
+		for _, replacement := range []byte(alphabet) {
+			if replacement == encoded[i] {
+				continue
+			}
+			mistyped := encoded[:i] + string(replacement) + encoded[i+1:]
+			if _, _, err := CheckDecode(mistyped); err == nil {
+				t.Fatalf("%q decoded with character %v changed to %q", mistyped, i, replacement)
+			}
+		}
+	}
+
+	if _, _, err := CheckDecode(encoded[:len(encoded)-1]); !errors.Is(err, ErrChecksum) {
+		t.Errorf("a truncated string: %v", err)
+	}
+	if _, _, err := CheckDecode(Encode([]byte{1, 2, 3, 4})); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("a string too short for a checksum: %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*
+The block store keeps the blockchain on disk so that a node survives restarts. Blocks are
+appended to blocks.dat as length-prefixed JSON records. blocks.idx holds one fixed size entry
+per block (the offset of its record and its hash), so that we can find any block and compare
+hashes without reading the whole data file.
+*/
+type BlockStore struct {
+	storeLock sync.Mutex
+	dataFile  *os.File
+	indexFile *os.File
+	offsets   []int64
+	hashes    [][32]byte
+}
+
+const blockIndexEntrySize = 8 + 32
+
+func openBlockStore(dataDir string) (*BlockStore, error) {
+	dataFile, err := os.OpenFile(filepath.Join(dataDir, "blocks.dat"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := os.OpenFile(filepath.Join(dataDir, "blocks.idx"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		dataFile.Close()
+		return nil, err
+	}
+
+	store := &BlockStore{dataFile: dataFile, indexFile: indexFile}
+	if err := store.readIndex(); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+/*
+Read the index into memory. A node may have been killed in the middle of an append, so we
+only keep the entries whose record is fully written in the data file and drop the rest.
+*/
+func (store *BlockStore) readIndex() error {
+	indexBytes, err := io.ReadAll(io.NewSectionReader(store.indexFile, 0, 1<<62))
+	if err != nil {
+		return err
+	}
+
+	dataInfo, err := store.dataFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	store.offsets = make([]int64, 0)
+	store.hashes = make([][32]byte, 0)
+
+	for start := 0; start+blockIndexEntrySize <= len(indexBytes); start += blockIndexEntrySize {
+		entry := indexBytes[start : start+blockIndexEntrySize]
+
+		offset := int64(binary.BigEndian.Uint64(entry[:8]))
+
+		var length [4]byte
+		if _, err := store.dataFile.ReadAt(length[:], offset); err != nil {
+			break
+		}
+		if offset+4+int64(binary.BigEndian.Uint32(length[:])) > dataInfo.Size() {
+			break
+		}
+
+		var hash [32]byte
+		copy(hash[:], entry[8:])
+
+		store.offsets = append(store.offsets, offset)
+		store.hashes = append(store.hashes, hash)
+	}
+
+	return store.truncate(len(store.offsets))
+}
+
+func (store *BlockStore) Count() int {
+	store.storeLock.Lock()
+	defer store.storeLock.Unlock()
+
+	return len(store.offsets)
+}
+
+// Hash of the stored block with the given index
+func (store *BlockStore) Hash(index int) ([32]byte, bool) {
+	store.storeLock.Lock()
+	defer store.storeLock.Unlock()
+
+	if index < 0 || index >= len(store.hashes) {
+		return [32]byte{}, false
+	}
+	return store.hashes[index], true
+}
+
+func (store *BlockStore) Get(index int) (HashedBlock, error) {
+	store.storeLock.Lock()
+	defer store.storeLock.Unlock()
+
+	if index < 0 || index >= len(store.offsets) {
+		return HashedBlock{}, errors.New("block not in store")
+	}
+
+	return store.readBlock(store.offsets[index])
+}
+
+func (store *BlockStore) readBlock(offset int64) (HashedBlock, error) {
+	var length [4]byte
+	if _, err := store.dataFile.ReadAt(length[:], offset); err != nil {
+		return HashedBlock{}, err
+	}
+
+	blockJSON := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := store.dataFile.ReadAt(blockJSON, offset+4); err != nil {
+		return HashedBlock{}, err
+	}
+
+	var block HashedBlock
+	if err := json.Unmarshal(blockJSON, &block); err != nil {
+		return HashedBlock{}, err
+	}
+	return block, nil
+}
+
+// Read every stored block, in order
+func (store *BlockStore) Load() ([]HashedBlock, error) {
+	store.storeLock.Lock()
+	defer store.storeLock.Unlock()
+
+	blocks := make([]HashedBlock, 0, len(store.offsets))
+	for _, offset := range store.offsets {
+		block, err := store.readBlock(offset)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+/*
+Append a block to the end of the store. The data record is written and synced before its
+index entry, so a crash can never leave an index entry pointing at a missing record.
+*/
+func (store *BlockStore) Append(block HashedBlock) error {
+	store.storeLock.Lock()
+	defer store.storeLock.Unlock()
+
+	if int(block.Index) != len(store.offsets) {
+		return errors.New("block index does not follow the stored chain")
+	}
+
+	blockJSON, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+
+	offset, err := store.dataFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, 4+len(blockJSON))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(blockJSON)))
+	copy(record[4:], blockJSON)
+
+	if _, err := store.dataFile.WriteAt(record, offset); err != nil {
+		return err
+	}
+	if err := store.dataFile.Sync(); err != nil {
+		return err
+	}
+
+	var entry [blockIndexEntrySize]byte
+	binary.BigEndian.PutUint64(entry[:8], uint64(offset))
+	copy(entry[8:], block.Hash[:])
+
+	if _, err := store.indexFile.WriteAt(entry[:], int64(len(store.offsets))*blockIndexEntrySize); err != nil {
+		return err
+	}
+	if err := store.indexFile.Sync(); err != nil {
+		return err
+	}
+
+	store.offsets = append(store.offsets, offset)
+	store.hashes = append(store.hashes, block.Hash)
+	return nil
+}
+
+// Drop every block from the given index onwards, used when our chain gets replaced
+func (store *BlockStore) Truncate(count int) error {
+	store.storeLock.Lock()
+	defer store.storeLock.Unlock()
+
+	return store.truncate(count)
+}
+
+func (store *BlockStore) truncate(count int) error {
+	if count > len(store.offsets) {
+		return nil
+	}
+
+	dataSize := int64(0)
+	if count < len(store.offsets) {
+		dataSize = store.offsets[count]
+	} else if count > 0 {
+		var length [4]byte
+		if _, err := store.dataFile.ReadAt(length[:], store.offsets[count-1]); err != nil {
+			return err
+		}
+		dataSize = store.offsets[count-1] + 4 + int64(binary.BigEndian.Uint32(length[:]))
+	}
+
+	if err := store.dataFile.Truncate(dataSize); err != nil {
+		return err
+	}
+	if err := store.indexFile.Truncate(int64(count) * blockIndexEntrySize); err != nil {
+		return err
+	}
+
+	store.offsets = store.offsets[:count]
+	store.hashes = store.hashes[:count]
+	return nil
+}
+
+func (store *BlockStore) Close() {
+	store.dataFile.Close()
+	store.indexFile.Close()
+}
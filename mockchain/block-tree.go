@@ -0,0 +1,370 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+)
+
+/*
+All the valid blocks we know of form a tree rooted at the genesis block. The main chain
+(node.blockchain) is the path from the genesis block to the block with the most cumulative
+work, and ties go to the block we saw first. Blocks on side branches are kept, so that when a
+branch overtakes the main chain we can switch to it right away: the blocks of the main chain
+after the fork point are disconnected, undoing their UTXO changes, and the blocks of the branch
+are connected in order. The transactions of the disconnected blocks that didn't make it into
+the new main chain go back to the mempool to be mined again.
+
+Blocks on side branches only have their transactions checked once their branch is about to
+overtake the main chain, against the UTXOs the branch would have (see branchView). A block with
+an invalid transaction is dropped from the tree along with the blocks built on it, and the main
+chain stays as it was.
+
+Blocks whose parent we don't have yet wait in the orphan pool until it arrives.
+*/
+const maxOrphanBlocks = 64
+
+type BlockTree struct {
+	blocks      map[[32]byte]*treeBlock
+	orphans     map[[32]byte][]HashedBlock // by the hash of the missing parent
+	orphanCount int
+	tip         *treeBlock
+}
+
+type treeBlock struct {
+	block  HashedBlock
+	parent *treeBlock
+	work   *big.Int // cumulative work of the chain up to and including this block
+
+	undo []appliedTransaction // what connecting the block changed, kept while it is on the main chain
+}
+
+// A transaction that was applied when its block was connected, along with the outputs it spent
+type appliedTransaction struct {
+	transaction SignedTransaction
+	spent       []TransactionOutput
+}
+
+func newBlockTree() *BlockTree {
+	return &BlockTree{
+		blocks:  make(map[[32]byte]*treeBlock),
+		orphans: make(map[[32]byte][]HashedBlock),
+	}
+}
+
+func (tree *BlockTree) add(block HashedBlock, parent *treeBlock) *treeBlock {
+	work := blockWork(block.BlockHeader)
+	if parent != nil {
+		work.Add(work, parent.work)
+	}
+
+	added := &treeBlock{block: block, parent: parent, work: work}
+	tree.blocks[block.Hash] = added
+	return added
+}
+
+// Forget a block that turned out to be invalid, along with every block built on it
+func (tree *BlockTree) remove(invalid *treeBlock) {
+	for hash, block := range tree.blocks {
+		for ancestor := block; ancestor != nil && ancestor.block.Index >= invalid.block.Index; ancestor = ancestor.parent {
+			if ancestor == invalid {
+				delete(tree.blocks, hash)
+				break
+			}
+		}
+	}
+}
+
+func (tree *BlockTree) addOrphan(block HashedBlock) {
+	for _, orphan := range tree.orphans[block.PreviousHash] {
+		if orphan.Hash == block.Hash {
+			return
+		}
+	}
+
+	// The pool is only a waiting room, so when it is full we make room by dropping a whole group
+	if tree.orphanCount >= maxOrphanBlocks {
+		for parentHash, orphans := range tree.orphans {
+			tree.orphanCount -= len(orphans)
+			delete(tree.orphans, parentHash)
+			break
+		}
+	}
+
+	tree.orphans[block.PreviousHash] = append(tree.orphans[block.PreviousHash], block)
+	tree.orphanCount++
+}
+
+func (tree *BlockTree) takeOrphans(parentHash [32]byte) []HashedBlock {
+	orphans := tree.orphans[parentHash]
+	delete(tree.orphans, parentHash)
+	tree.orphanCount -= len(orphans)
+	return orphans
+}
+
+func (node *Node) onMainChain(block *treeBlock) bool {
+	index := block.block.Index
+	return index < uint(len(node.blockchain)) && node.blockchain[index].Hash == block.block.Hash
+}
+
+/*
+Make newTip the tip of the main chain. The caller must hold blockchainLock and newTip must
+have more work than the current tip. If a block of the branch is invalid, it is removed from the
+tree and the main chain doesn't change.
+*/
+func (node *Node) reorganize(newTip *treeBlock) error {
+	// Walk back from the new tip to the block where it branches off the main chain
+	branch := make([]*treeBlock, 0)
+	fork := newTip
+	for !node.onMainChain(fork) {
+		branch = append(branch, fork)
+		fork = fork.parent
+	}
+
+	if invalid, err := node.checkBranch(fork, branch); err != nil {
+		node.blockTree.remove(invalid)
+		return fmt.Errorf("block %x: %w", invalid.block.Hash, err)
+	}
+
+	if tip := node.blockchain[len(node.blockchain)-1]; tip.Index > fork.block.Index {
+		node.events.Publish(Event{Type: ReorgEvent, Data: Reorg{
+			ForkIndex:    fork.block.Index,
+			OldTip:       tip.Hash,
+			NewTip:       newTip.block.Hash,
+			Disconnected: int(tip.Index - fork.block.Index),
+			Connected:    len(branch),
+		}})
+	}
+
+	// Oldest block first, so that a transaction goes back to the mempool after the ones it spends
+	disconnected := make([]SignedTransaction, 0)
+	for uint(len(node.blockchain)) > fork.block.Index+1 {
+		tip := node.blockTree.blocks[node.blockchain[len(node.blockchain)-1].Hash]
+		disconnected = append(append([]SignedTransaction{}, tip.block.Transactions...), disconnected...)
+		node.disconnectBlock(tip)
+	}
+
+	if len(disconnected) > 0 {
+		if node.blockStore != nil {
+			if err := node.blockStore.Truncate(len(node.blockchain)); err != nil {
+				log.Println("reorganize: Truncate->", err)
+			}
+		}
+		log.Println("reorganize: switching to a branch with more work, forking off after block", fork.block.Index)
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		node.connectBlock(branch[i])
+	}
+	node.blockTree.tip = newTip
+
+	/*
+		The transactions of the old branch that the new one doesn't have are admitted again like new
+		ones, against the UTXOs of the new branch: the ones that spend coins the new branch spent
+		otherwise, or coins that only the old branch created, are dropped. So are the transactions
+		that were waiting in the mempool and spent such coins.
+	*/
+	for _, transaction := range disconnected {
+		if isCoinbase(transaction) {
+			continue
+		}
+		if rejectError := node.admit(transaction); rejectError != nil && rejectError.Code != RejectDuplicate {
+			log.Printf("reorganize: dropping transaction %x - %v", transaction.TransactionID, rejectError)
+		}
+	}
+	for _, transaction := range node.mempool.Transactions() {
+		if !node.mempool.Contains(transaction.TransactionID) {
+			continue
+		}
+		if rejectError := node.checkTransaction(transaction, node.spendableUTXO, node.minedAt, uint(len(node.blockchain))); rejectError != nil {
+			log.Printf("reorganize: dropping transaction %x from the mempool - %v", transaction.TransactionID, rejectError)
+			node.mempool.Remove(transaction.TransactionID)
+		}
+	}
+	node.rebuildWallet()
+	return nil
+}
+
+/*
+Check the transactions of the branch, oldest block first, against the UTXOs as they would be
+after the main chain is rolled back to fork. Returns the first invalid block and why.
+*/
+func (node *Node) checkBranch(fork *treeBlock, branch []*treeBlock) (*treeBlock, error) {
+	view := newBranchView(node)
+	for index := len(node.blockchain) - 1; index > int(fork.block.Index); index-- {
+		view.disconnect(node.blockTree.blocks[node.blockchain[index].Hash])
+	}
+
+	for i := len(branch) - 1; i >= 0; i-- {
+		if err := node.checkBlockTransactions(view, branch[i].block); err != nil {
+			return branch[i], err
+		}
+	}
+	return nil, nil
+}
+
+// Check the transactions of the block in order and connect them to the view
+func (node *Node) checkBlockTransactions(view *branchView, block HashedBlock) error {
+	var fees uint = 0
+	for _, transaction := range block.Transactions[1:] {
+		if rejectError := node.checkTransaction(transaction, view.Get, view.minedAt, block.Index); rejectError != nil {
+			return fmt.Errorf("transaction %x - %w", transaction.TransactionID, rejectError)
+		}
+		view.connect(transaction, block.Index)
+		fees += transaction.Fee
+	}
+
+	// The coinbase may only claim the fees of the transactions that were valid
+	coinbase := block.Transactions[0]
+	if coinbase.TransactionOutputs[0].Amount > node.params.blockSubsidy(block.Index)+fees {
+		return fmt.Errorf("the coinbase claims %v coins, more than the subsidy and the fees", coinbase.TransactionOutputs[0].Amount)
+	}
+	view.connect(coinbase, block.Index)
+	return nil
+}
+
+/*
+Append the block to the main chain and apply its transactions, remembering how to undo them.
+The block was checked by checkBranch, so its transactions only have to be applied. The
+transactions of the block, and the ones that conflict with them, leave the mempool.
+*/
+func (node *Node) connectBlock(block *treeBlock) {
+	block.undo = make([]appliedTransaction, 0, len(block.block.Transactions))
+	for _, transaction := range block.block.Transactions[1:] {
+
+		spent := make([]TransactionOutput, 0, len(transaction.TransactionInputs))
+		for _, transactionInput := range transaction.TransactionInputs {
+			if transactionOutput, ok := node.UTXOsCommitted.Get(transactionInput.PreviousOutputID); ok {
+				spent = append(spent, transactionOutput)
+			}
+		}
+
+		node.applyTransaction(transaction)
+		block.undo = append(block.undo, appliedTransaction{transaction: transaction, spent: spent})
+	}
+
+	// The coinbase goes last, the way checkBlockTransactions checked it
+	coinbase := block.block.Transactions[0]
+	node.applyCoinbase(coinbase)
+	block.undo = append(block.undo, appliedTransaction{transaction: coinbase})
+
+	node.blockchain = append(node.blockchain, block.block)
+	for _, transaction := range block.block.Transactions {
+		node.chainTransactions[transaction.TransactionID] = block.block.Index
+	}
+	node.persistBlock(block.block)
+	node.chainGrown.Broadcast()
+
+	node.mempool.RemoveConfirmed(block.block.Transactions)
+	node.events.Publish(Event{Type: BlockEvent, Data: block.block})
+}
+
+/*
+Take the tip of the main chain off and undo its transactions, newest first, in the UTXOs and in
+our wallet alike: coins we received or mined in the block leave the wallet, and the coins our own
+transactions spent come back. Our transactions take them again if they go back to the mempool.
+*/
+func (node *Node) disconnectBlock(block *treeBlock) {
+	for i := len(block.undo) - 1; i >= 0; i-- {
+		transaction := block.undo[i].transaction
+
+		for _, transactionOutput := range transaction.TransactionOutputs {
+			node.UTXOsCommitted.Remove(transactionOutput.ID)
+			if transactionOutput.RecipientAddress == node.walletAddress {
+				node.myUTXOs.Remove(transactionOutput.ID)
+			}
+		}
+		for _, transactionOutput := range block.undo[i].spent {
+			node.UTXOsCommitted.Add(transactionOutput)
+			if transactionOutput.RecipientAddress == node.walletAddress && !node.myUTXOs.Contains(transactionOutput.ID) {
+				node.myUTXOs.Push(transactionOutput)
+			}
+		}
+	}
+
+	for _, transaction := range block.block.Transactions {
+		delete(node.chainTransactions, transaction.TransactionID)
+	}
+
+	block.undo = nil
+	node.blockchain = node.blockchain[:len(node.blockchain)-1]
+}
+
+/*
+The UTXOs and the mined transactions as they would be on a branch, kept as changes on top of
+the main chain so that nothing has to be copied or undone to look at another branch.
+*/
+type branchView struct {
+	node    *Node
+	added   map[[32]byte]TransactionOutput
+	removed map[[32]byte]bool
+	mined   map[[32]byte]uint // the Index of the block of the transactions connected to the view
+	unmined map[[32]byte]bool // the transactions of the main chain disconnected from the view
+}
+
+func newBranchView(node *Node) *branchView {
+	return &branchView{
+		node:    node,
+		added:   make(map[[32]byte]TransactionOutput),
+		removed: make(map[[32]byte]bool),
+		mined:   make(map[[32]byte]uint),
+		unmined: make(map[[32]byte]bool),
+	}
+}
+
+func (view *branchView) Get(id [32]byte) (TransactionOutput, bool) {
+	if view.removed[id] {
+		return TransactionOutput{}, false
+	}
+	if transactionOutput, ok := view.added[id]; ok {
+		return transactionOutput, true
+	}
+	return view.node.UTXOsCommitted.Get(id)
+}
+
+func (view *branchView) minedAt(transactionID [32]byte) (uint, bool) {
+	if index, ok := view.mined[transactionID]; ok {
+		return index, true
+	}
+	if view.unmined[transactionID] {
+		return 0, false
+	}
+	return view.node.minedAt(transactionID)
+}
+
+func (view *branchView) addOutput(transactionOutput TransactionOutput) {
+	delete(view.removed, transactionOutput.ID)
+	view.added[transactionOutput.ID] = transactionOutput
+}
+
+func (view *branchView) removeOutput(id [32]byte) {
+	delete(view.added, id)
+	view.removed[id] = true
+}
+
+func (view *branchView) connect(transaction SignedTransaction, index uint) {
+	for _, transactionInput := range transaction.TransactionInputs {
+		view.removeOutput(transactionInput.PreviousOutputID)
+	}
+	for _, transactionOutput := range transaction.TransactionOutputs {
+		view.addOutput(transactionOutput)
+	}
+	delete(view.unmined, transaction.TransactionID)
+	view.mined[transaction.TransactionID] = index
+}
+
+// Undo the transactions of a block of the main chain, newest first, the way disconnectBlock does
+func (view *branchView) disconnect(block *treeBlock) {
+	for i := len(block.undo) - 1; i >= 0; i-- {
+		for _, transactionOutput := range block.undo[i].transaction.TransactionOutputs {
+			view.removeOutput(transactionOutput.ID)
+		}
+		for _, transactionOutput := range block.undo[i].spent {
+			view.addOutput(transactionOutput)
+		}
+	}
+	for _, transaction := range block.block.Transactions {
+		delete(view.mined, transaction.TransactionID)
+		view.unmined[transaction.TransactionID] = true
+	}
+}
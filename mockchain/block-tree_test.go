@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+// A second node, id1, that joined the bootstrap node and has the same genesis block
+func testJoinedNode(t *testing.T, bootstrap *Node) *Node {
+	config := defaultConfig()
+	config.DataDir = t.TempDir()
+
+	node := &Node{}
+	node.createNode(config, false)
+	node.id = "id1"
+	node.params = bootstrap.params
+	node.paramsReceived = true
+	node.setNodeData(bootstrap.id, NodeData{PublicKey: bootstrap.publicKey, Address: bootstrap.address})
+	node.setNodeData(node.id, NodeData{PublicKey: node.publicKey, Address: node.address})
+	bootstrap.setNodeData(node.id, NodeData{PublicKey: node.publicKey, Address: node.address})
+
+	acceptBlock(t, node, bootstrap.blockchain[0])
+	return node
+}
+
+func testPayment(t *testing.T, node *Node, address Address, amount uint) SignedTransaction {
+	transaction, err := node.createTransaction([]Payment{{Address: address, Amount: amount}}, 1, largestFirst{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedTransaction, err := node.signTransaction(transaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signedTransaction
+}
+
+/*
+The bootstrap node mines a payment and then sees a longer branch where the same coins went
+elsewhere. The payment, and the one spending its change, can't go back to the mempool, and the
+wallet is left with the change of the payment the new branch has.
+*/
+func TestReorgDropsConflictingTransactions(t *testing.T) {
+	_, node := testBootstrapNode(t)
+	other := testJoinedNode(t, node)
+
+	payment := testPayment(t, node, other.walletAddress, 50)
+	conflicting := testPayment(t, node, other.walletAddress, 60)
+
+	acceptBlock(t, node, node.mineBlock([]SignedTransaction{payment}, 1, node.blockchain[0].Hash))
+	child := testPayment(t, node, other.walletAddress, 10)
+	if err := node.admitTransaction(child); err != nil {
+		t.Fatal(err)
+	}
+
+	acceptBlock(t, other, other.mineBlock([]SignedTransaction{conflicting}, 1, other.blockchain[0].Hash))
+	acceptBlock(t, other, other.mineBlock(nil, 2, other.blockchain[1].Hash))
+	for _, block := range other.blockchain[1:] {
+		acceptBlock(t, node, block)
+	}
+
+	if node.blockchain[len(node.blockchain)-1].Hash != other.blockchain[2].Hash {
+		t.Fatal("the node didn't switch to the branch with more work")
+	}
+	if node.mempool.Contains(payment.TransactionID) || node.mempool.Contains(child.TransactionID) {
+		t.Error("a transaction that conflicts with the new branch went back to the mempool")
+	}
+	if balance := walletTotal(node); balance != 200-60-1 {
+		t.Errorf("the wallet has %v coins after the reorg", balance)
+	}
+}
+
+// A payment the new branch doesn't have goes back to the mempool, and its coins stay spent
+func TestReorgReadmitsTransactions(t *testing.T) {
+	_, node := testBootstrapNode(t)
+	other := testJoinedNode(t, node)
+
+	payment := testPayment(t, node, other.walletAddress, 50)
+	acceptBlock(t, node, node.mineBlock([]SignedTransaction{payment}, 1, node.blockchain[0].Hash))
+
+	acceptBlock(t, other, other.mineBlock(nil, 1, other.blockchain[0].Hash))
+	acceptBlock(t, other, other.mineBlock(nil, 2, other.blockchain[1].Hash))
+	for _, block := range other.blockchain[1:] {
+		acceptBlock(t, node, block)
+	}
+
+	if !node.mempool.Contains(payment.TransactionID) {
+		t.Error("the payment didn't go back to the mempool")
+	}
+	if balance := walletTotal(node); balance != 200-50-1 {
+		t.Errorf("the wallet has %v coins after the reorg", balance)
+	}
+}
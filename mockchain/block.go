@@ -1,18 +1,27 @@
 package main
 
-type Block struct {
+/*
+The header is everything that gets hashed when mining. The transactions are only committed to
+through the Merkle root, so a transaction can be proven to be in a block with the header and a
+Merkle proof (see the merkle package), without the rest of the block.
+*/
+type BlockHeader struct {
 	Index        uint
-	Timestamp    int64
+	Timestamp    int64 // Unix time in milliseconds
 	PreviousHash [32]byte
-	Transactions [CAPACITY]SignedTransaction
+	MerkleRoot   [32]byte
+	WitnessRoot  [32]byte // commits to the signatures and unlocking scripts, which MerkleRoot leaves out
 	Nonce        [32]byte
+	Target       [32]byte // the hash of the block must not be greater than this (see difficulty.go)
+}
+
+type Block struct {
+	BlockHeader
+	Transactions []SignedTransaction
 }
 
 type HashedBlock struct {
-	Index        uint
-	Timestamp    int64
-	PreviousHash [32]byte
-	Transactions [CAPACITY]SignedTransaction
-	Nonce        [32]byte
+	BlockHeader
+	Transactions []SignedTransaction
 	Hash         [32]byte
 }
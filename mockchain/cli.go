@@ -1,34 +1,70 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"os"
 	"strconv"
+	"time"
 )
 
 func (node *Node) cli(fields []string) {
-	if len(fields) == 3 && fields[0] == "t" {
-		if _, ok := node.nodeDataMap[fields[1]]; !ok {
-			fmt.Println("No client with id", fields[1])
+	if len(fields) >= 3 && fields[0] == "t" {
+		payments, fee, err := node.parsePayments(fields[1:])
+		if err != nil {
+			fmt.Println(err)
 			node.help()
 			return
 		}
 
-		parsed, err := strconv.ParseUint(fields[2], 10, 32)
-		if err != nil {
-			log.Println("cli: ParseUint", err)
+		node.transaction(payments, fee)
+
+	} else if len(fields) == 2 && fields[0] == "proof" {
+		var transactionID [32]byte
+		decoded, err := hex.DecodeString(fields[1])
+		if err != nil || len(decoded) != len(transactionID) {
+			fmt.Println("Transaction ID must be 64 hex digits")
 			node.help()
 			return
 		}
-		amount := uint(parsed)
+		copy(transactionID[:], decoded)
+
+		node.proof(transactionID)
+
+	} else if (len(fields) == 1 || len(fields) == 2) && fields[0] == "coinselection" {
+		node.setCoinSelection(fields[1:])
+
+	} else if (len(fields) == 1 || len(fields) == 2) && fields[0] == "consolidate" {
+		var fee uint = 0
+		if len(fields) == 2 {
+			parsed, err := strconv.ParseUint(fields[1], 10, 32)
+			if err != nil {
+				fmt.Println("Fee must be a number")
+				node.help()
+				return
+			}
+			fee = uint(parsed)
+		}
+
+		node.sendFundsWith(nil, fee, consolidate{})
+
+	} else if len(fields) >= 2 && (fields[0] == "multisig" || fields[0] == "psbt") {
+		node.psbtCommand(fields)
 
-		node.transaction(fields[1], amount)
+	} else if len(fields) >= 3 && fields[0] == "script" {
+		node.scriptCommand(fields)
+
+	} else if len(fields) >= 2 && fields[0] == "htlc" {
+		node.htlcCommand(fields)
 
 	} else if len(fields) == 1 {
 		if fields[0] == "view" {
 			node.view()
 		} else if fields[0] == "balance" {
 			node.balance()
+		} else if fields[0] == "address" {
+			fmt.Println("\nWallet address is:", node.walletAddress)
 		} else if fields[0] == "help" {
 			node.help()
 		} else if fields[0] == "txs" {
@@ -37,6 +73,11 @@ func (node *Node) cli(fields []string) {
 			node.allBalances()
 		} else if fields[0] == "hashes" {
 			node.hashes()
+		} else if fields[0] == "peers" {
+			node.peersStatus()
+		} else if fields[0] == "leave" {
+			node.leave()
+			os.Exit(0)
 		} else {
 			node.help()
 		}
@@ -45,8 +86,73 @@ func (node *Node) cli(fields []string) {
 	}
 }
 
-func (node *Node) transaction(id string, amount uint) {
-	node.sendFunds(id, amount)
+func (node *Node) transaction(payments []Payment, fee uint) {
+	node.sendFunds(payments, fee)
+}
+
+func (node *Node) setCoinSelection(fields []string) {
+	node.mineLock.Lock()
+	defer node.mineLock.Unlock()
+
+	if len(fields) == 1 {
+		coinSelector, err := parseCoinSelector(fields[0])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		node.coinSelector = coinSelector
+	}
+	fmt.Println("\nCoin selection:", node.coinSelector.Name())
+}
+
+// A receiver is given either by the id of a node or by an address
+func (node *Node) parseReceiver(receiver string) (Address, error) {
+	if idNumber(receiver) >= 0 {
+		address, ok := node.addressOfID(receiver)
+		if !ok {
+			return Address{}, fmt.Errorf("No client with id %v", receiver)
+		}
+		return address, nil
+	}
+
+	address, err := parseAddress(receiver)
+	if err != nil {
+		return Address{}, fmt.Errorf("Invalid address %v: %w", receiver, err)
+	}
+	return address, nil
+}
+
+// Read "<receiver> <amount>" pairs, followed by an optional fee
+func (node *Node) parsePayments(fields []string) ([]Payment, uint, error) {
+	payments := make([]Payment, 0, len(fields)/2)
+
+	for i := 0; i+1 < len(fields); i += 2 {
+		address, err := node.parseReceiver(fields[i])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		parsed, err := strconv.ParseUint(fields[i+1], 10, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("parsePayments: ParseUint %w", err)
+		}
+		payments = append(payments, Payment{Address: address, Amount: uint(parsed)})
+	}
+
+	// The fee is optional
+	var fee uint = 0
+	if len(fields)%2 == 1 {
+		parsed, err := strconv.ParseUint(fields[len(fields)-1], 10, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("parsePayments: ParseUint %w", err)
+		}
+		fee = uint(parsed)
+	}
+
+	if len(payments) == 0 {
+		return nil, 0, errors.New("No payments given")
+	}
+	return payments, fee, nil
 }
 
 func (node *Node) view() {
@@ -59,9 +165,81 @@ func (node *Node) help() {
 	fmt.Println("\nsupported commands:")
 	fmt.Println("")
 
-	fmt.Println("t <receiverID> <amount>")
-	fmt.Println("\tsends <amount> coins to client with <receiverID>")
-	fmt.Println("\tExample: t id1 100")
+	fmt.Println("t <receiver> <amount> [<receiver> <amount> ...] [fee]")
+	fmt.Println("\tsends <amount> coins to each <receiver> in one transaction, paying [fee] coins (default 0) to the miner")
+	fmt.Println("\ta <receiver> is the id of a client or an address")
+	fmt.Println("\tExample: t id1 100 2")
+	fmt.Println("\tExample: t id1 10 id2 20 NKuyBkoGdZZSLyPbJEetheRhMjeznFZszf 30")
+	fmt.Println("")
+
+	fmt.Println("coinselection [strategy]")
+	fmt.Println("\tshows or sets how the wallet picks the coins to spend:")
+	fmt.Println("\tlargest-first, smallest-sufficient, branch-and-bound (exact amount, no change) or consolidate")
+	fmt.Println("")
+
+	fmt.Println("consolidate [fee]")
+	fmt.Println("\tmerges all the client's coins into one, paying [fee] coins (default 0) to the miner")
+	fmt.Println("")
+
+	fmt.Println("multisig add <m> <receiverID> [<receiverID> ...]")
+	fmt.Println("\tkeeps track of the address whose coins can be spent with the signatures of <m> of the given clients")
+	fmt.Println("\tExample: multisig add 2 id0 id1 id2")
+	fmt.Println("")
+
+	fmt.Println("multisig list")
+	fmt.Println("\tshows the multisig addresses this client keeps track of, with their balances")
+	fmt.Println("")
+
+	fmt.Println("psbt create <file> <multisigAddress> <receiver> <amount> [<receiver> <amount> ...] [fee]")
+	fmt.Println("\twrites to <file> a transaction paying from the multisig address, to be signed by its cosigners")
+	fmt.Println("")
+
+	fmt.Println("psbt sign <file>")
+	fmt.Println("\tadds this client's signature to the transaction in <file>")
+	fmt.Println("")
+
+	fmt.Println("psbt combine <file> <otherFile> [<otherFile> ...]")
+	fmt.Println("\tadds the signatures of the other copies of the transaction to <file>")
+	fmt.Println("")
+
+	fmt.Println("psbt send <file>")
+	fmt.Println("\tsends the transaction in <file> to the network, once enough cosigners have signed it")
+	fmt.Println("")
+
+	fmt.Println("script pay <amount> <fee> <word> [<word> ...]")
+	fmt.Println("\tlocks <amount> coins with the script made of the words, paying <fee> coins to the miner")
+	fmt.Println("\tExample: script pay 20 1 OP_SHA256 0xbc07c56cbf4f262b6fbb48bc72cc58040e96ff9127132dc305cb223803f55667 OP_EQUAL")
+	fmt.Println("")
+
+	fmt.Println("script list <scriptAddress>")
+	fmt.Println("\tshows the unspent outputs locked at the script address, with their scripts")
+	fmt.Println("")
+
+	fmt.Println("script spend <outputID> <fee> [<word> ...]")
+	fmt.Println("\tspends the output to this client with the unlocking script made of the words, paying <fee> coins to the miner")
+	fmt.Println("\t<sig> and <pubkey> stand for this client's signature and public key")
+	fmt.Println("")
+
+	fmt.Println("htlc open <receiverID> <amount> <timeout> <hash|new> [fee]")
+	fmt.Println("\tlocks <amount> coins that <receiverID> can claim with a preimage of <hash>, and this client can take back from block <timeout> on")
+	fmt.Println("\t<timeout> is a block index, or +<blocks> after the tip; new makes a new secret preimage")
+	fmt.Println("\tExample: htlc open id1 20 +10 new")
+	fmt.Println("")
+
+	fmt.Println("htlc list")
+	fmt.Println("\tshows the open contracts that pay this client or that it can take back")
+	fmt.Println("")
+
+	fmt.Println("htlc claim <outputID> <preimage> [fee]")
+	fmt.Println("\tclaims the coins of a contract by revealing the preimage of its hash")
+	fmt.Println("")
+
+	fmt.Println("htlc refund <outputID> [fee]")
+	fmt.Println("\ttakes back the coins of a contract this client opened, once its timeout has passed")
+	fmt.Println("")
+
+	fmt.Println("htlc preimage <hash>")
+	fmt.Println("\tshows the preimage of <hash>, once a claim has revealed it")
 	fmt.Println("")
 
 	fmt.Println("view")
@@ -72,26 +250,47 @@ func (node *Node) help() {
 	fmt.Println("\treturns the client's remaining NBC coins")
 	fmt.Println("")
 
+	fmt.Println("address")
+	fmt.Println("\treturns the address the client's coins are paid to")
+	fmt.Println("")
+
 	fmt.Println("txs")
 	fmt.Println("\treturns all transactions received by this node in chronological order")
 	fmt.Println("")
 
 	fmt.Println("all")
-	fmt.Println("\treturns the amount of coins in each of the system's nodes")
+	fmt.Println("\treturns the amount of coins and the address of each of the system's nodes")
 	fmt.Println("")
 
 	fmt.Println("hashes")
 	fmt.Println("\treturns the hashes of all blocks in the blockchain")
 	fmt.Println("")
 
+	fmt.Println("proof <transactionID>")
+	fmt.Println("\treturns the block header and Merkle proof that the transaction is in the blockchain")
+	fmt.Println("")
+
+	fmt.Println("peers")
+	fmt.Println("\tshows the state, round trip time and last message time of every other node")
+	fmt.Println("")
+
+	fmt.Println("leave")
+	fmt.Println("\ttells the other nodes that this node is leaving the network and exits")
+	fmt.Println("")
+
 	fmt.Println("help")
 	fmt.Println("\thelp about cli commands")
 }
 
 func (node *Node) allBalances() {
 	fmt.Println("\nThe balances of all wallets are:")
-	for id := range node.nodeDataMap {
-		fmt.Println(id, node.walletBalance(id))
+	for _, id := range node.nodeIDs() {
+		address, _ := node.addressOfID(id)
+		if nodeData, _ := node.getNodeData(id); nodeData.Departed {
+			fmt.Println(id, node.walletBalance(id), address, "(left)")
+		} else {
+			fmt.Println(id, node.walletBalance(id), address)
+		}
 	}
 }
 
@@ -103,3 +302,46 @@ func (node *Node) hashes() {
 	}
 	node.blockchainLock.Unlock()
 }
+
+func (node *Node) proof(transactionID [32]byte) {
+	header, blockHash, proof, err := node.transactionProof(transactionID)
+	if err != nil {
+		fmt.Println("\nNo proof:", err)
+		return
+	}
+
+	fmt.Printf("\nTransaction %x is transaction %v of block %v\n", transactionID, proof.Index, header.Index)
+	fmt.Printf("Block hash:    %x\n", blockHash)
+	fmt.Printf("Previous hash: %x\n", header.PreviousHash)
+	fmt.Printf("Merkle root:   %x\n", header.MerkleRoot)
+	fmt.Printf("Witness root:  %x\n", header.WitnessRoot)
+	fmt.Printf("Nonce:         %x\n", header.Nonce)
+	fmt.Println("Timestamp:    ", header.Timestamp)
+	fmt.Printf("Target:        %x\n", header.Target)
+	fmt.Println("Proof:")
+	for _, step := range proof.Steps {
+		side := "right"
+		if step.Left {
+			side = "left"
+		}
+		fmt.Printf("\t%-5v %x\n", side, step.Sibling)
+	}
+	fmt.Println("Verified:     ", verifyInclusion(header, blockHash, transactionID, proof))
+}
+
+func (node *Node) peersStatus() {
+	fmt.Println("\nThe peers of this node are:")
+	for _, peer := range node.queryPeers() {
+		if peer.State != "connected" {
+			fmt.Printf("%-6v %-13v %v\n", peer.ID, peer.State, peer.Address)
+			continue
+		}
+
+		rtt := "-"
+		if peer.RTT > 0 {
+			rtt = time.Duration(peer.RTT * float64(time.Millisecond)).Round(time.Microsecond).String()
+		}
+		lastSeen := time.Since(*peer.LastSeen).Round(time.Millisecond)
+		fmt.Printf("%-6v connected     %-22v rtt %-10v last seen %v ago\n", peer.ID, peer.Address, rtt, lastSeen)
+	}
+}
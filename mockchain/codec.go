@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+/*
+Message data can be encoded in two ways. JSON is what every node understands. The binary codec
+is a lot smaller and faster, and is used with every peer that told us it supports it in the
+handshake (WelcomeMessage, MyInfoMessage or NewConnectionMessage).
+*/
+type Codec byte
+
+const (
+	JSONCodec Codec = iota
+	BinaryCodec
+)
+
+func (codec Codec) String() string {
+	switch codec {
+	case JSONCodec:
+		return "json"
+	case BinaryCodec:
+		return "binary"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(codec))
+	}
+}
+
+func parseCodec(name string) (Codec, error) {
+	switch name {
+	case "json":
+		return JSONCodec, nil
+	case "binary":
+		return BinaryCodec, nil
+	default:
+		return JSONCodec, errors.New("unknown codec " + name)
+	}
+}
+
+// The codecs a node advertises, most preferred first. JSON is always supported.
+func supportedCodecs(preferred Codec) []Codec {
+	if preferred == JSONCodec {
+		return []Codec{JSONCodec}
+	}
+	return []Codec{BinaryCodec, JSONCodec}
+}
+
+// The first of our codecs that the remote node supports too
+func chooseCodec(ours []Codec, theirs []Codec) Codec {
+	for _, codec := range ours {
+		for _, other := range theirs {
+			if codec == other {
+				return codec
+			}
+		}
+	}
+	return JSONCodec
+}
+
+func marshalWithCodec(codec Codec, value interface{}) ([]byte, error) {
+	switch codec {
+	case JSONCodec:
+		return json.Marshal(value)
+	case BinaryCodec:
+		return marshalBinary(value)
+	default:
+		return nil, errors.New("unknown codec")
+	}
+}
+
+func unmarshalWithCodec(codec Codec, data []byte, value interface{}) error {
+	switch codec {
+	case JSONCodec:
+		return json.Unmarshal(data, value)
+	case BinaryCodec:
+		return unmarshalBinary(data, value)
+	default:
+		return errors.New("unknown codec")
+	}
+}
+
+/*
+The binary codec walks a value with reflection and writes its fields in declaration order,
+with no field names:
+  - unsigned integers as uvarints and signed integers as zig-zag varints
+  - strings and byte slices as a uvarint length followed by the bytes
+  - byte arrays (hashes, ids) as their raw bytes
+  - other slices as a uvarint length followed by the elements, arrays as their elements. Empty
+    slices decode as nil, which is what most of them are when they are sent
+  - maps as a uvarint length followed by key/value pairs, sorted by encoded key
+  - pointers as a presence byte followed by the value
+  - *big.Int as a sign byte followed by its magnitude bytes
+
+Both ends must use the same struct definitions, which holds for nodes of the same version.
+*/
+type binaryEncoder struct {
+	buffer []byte
+}
+
+type binaryDecoder struct {
+	data   []byte
+	offset int
+}
+
+var errBinaryTruncated = errors.New("binary codec: unexpected end of data")
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+func marshalBinary(value interface{}) ([]byte, error) {
+	encoder := binaryEncoder{buffer: make([]byte, 0, 256)}
+	if err := encoder.encode(reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+	return encoder.buffer, nil
+}
+
+func unmarshalBinary(data []byte, value interface{}) error {
+	pointer := reflect.ValueOf(value)
+	if pointer.Kind() != reflect.Pointer || pointer.IsNil() {
+		return errors.New("binary codec: unmarshal needs a non-nil pointer")
+	}
+
+	decoder := binaryDecoder{data: data}
+	if err := decoder.decode(pointer.Elem()); err != nil {
+		return err
+	}
+	if decoder.offset != len(data) {
+		return errors.New("binary codec: trailing data")
+	}
+	return nil
+}
+
+func (encoder *binaryEncoder) putUvarint(x uint64) {
+	encoder.buffer = binary.AppendUvarint(encoder.buffer, x)
+}
+
+func (encoder *binaryEncoder) putBytes(data []byte) {
+	encoder.putUvarint(uint64(len(data)))
+	encoder.buffer = append(encoder.buffer, data...)
+}
+
+func (encoder *binaryEncoder) encode(value reflect.Value) error {
+	switch value.Kind() {
+
+	case reflect.Bool:
+		if value.Bool() {
+			encoder.buffer = append(encoder.buffer, 1)
+		} else {
+			encoder.buffer = append(encoder.buffer, 0)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		encoder.buffer = binary.AppendVarint(encoder.buffer, value.Int())
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		encoder.putUvarint(value.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		encoder.buffer = binary.BigEndian.AppendUint64(encoder.buffer, math.Float64bits(value.Float()))
+
+	case reflect.String:
+		encoder.putBytes([]byte(value.String()))
+
+	case reflect.Pointer:
+		if value.Type().Elem() == bigIntType {
+			encoder.encodeBigInt(value.Interface().(*big.Int))
+			return nil
+		}
+		if value.IsNil() {
+			encoder.buffer = append(encoder.buffer, 0)
+			return nil
+		}
+		encoder.buffer = append(encoder.buffer, 1)
+		return encoder.encode(value.Elem())
+
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !value.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := encoder.encode(value.Field(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			encoder.putBytes(value.Bytes())
+			return nil
+		}
+		encoder.putUvarint(uint64(value.Len()))
+		for i := 0; i < value.Len(); i++ {
+			if err := encoder.encode(value.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			for i := 0; i < value.Len(); i++ {
+				encoder.buffer = append(encoder.buffer, byte(value.Index(i).Uint()))
+			}
+			return nil
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := encoder.encode(value.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		// Sort the entries by their encoded key, so that equal maps always encode the same way
+		type entry struct {
+			key   []byte
+			value reflect.Value
+		}
+		entries := make([]entry, 0, value.Len())
+
+		iterator := value.MapRange()
+		for iterator.Next() {
+			keyEncoder := binaryEncoder{}
+			if err := keyEncoder.encode(iterator.Key()); err != nil {
+				return err
+			}
+			entries = append(entries, entry{keyEncoder.buffer, iterator.Value()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+
+		encoder.putUvarint(uint64(len(entries)))
+		for _, entry := range entries {
+			encoder.buffer = append(encoder.buffer, entry.key...)
+			if err := encoder.encode(entry.value); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("binary codec: can't encode %v", value.Type())
+	}
+
+	return nil
+}
+
+// 0 for nil, 1 for non-negative and 2 for negative numbers, then the magnitude
+func (encoder *binaryEncoder) encodeBigInt(number *big.Int) {
+	if number == nil {
+		encoder.buffer = append(encoder.buffer, 0)
+		return
+	}
+	if number.Sign() < 0 {
+		encoder.buffer = append(encoder.buffer, 2)
+	} else {
+		encoder.buffer = append(encoder.buffer, 1)
+	}
+	encoder.putBytes(number.Bytes())
+}
+
+func (decoder *binaryDecoder) remaining() int {
+	return len(decoder.data) - decoder.offset
+}
+
+func (decoder *binaryDecoder) readByte() (byte, error) {
+	if decoder.remaining() < 1 {
+		return 0, errBinaryTruncated
+	}
+	b := decoder.data[decoder.offset]
+	decoder.offset++
+	return b, nil
+}
+
+func (decoder *binaryDecoder) readUvarint() (uint64, error) {
+	x, n := binary.Uvarint(decoder.data[decoder.offset:])
+	if n <= 0 {
+		return 0, errBinaryTruncated
+	}
+	decoder.offset += n
+	return x, nil
+}
+
+func (decoder *binaryDecoder) readVarint() (int64, error) {
+	x, n := binary.Varint(decoder.data[decoder.offset:])
+	if n <= 0 {
+		return 0, errBinaryTruncated
+	}
+	decoder.offset += n
+	return x, nil
+}
+
+// A length read from the data can never be more than what is left of it
+func (decoder *binaryDecoder) readLength() (int, error) {
+	length, err := decoder.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if length > uint64(decoder.remaining()) {
+		return 0, errBinaryTruncated
+	}
+	return int(length), nil
+}
+
+func (decoder *binaryDecoder) readBytes() ([]byte, error) {
+	length, err := decoder.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	data := make([]byte, length)
+	copy(data, decoder.data[decoder.offset:decoder.offset+length])
+	decoder.offset += length
+	return data, nil
+}
+
+func (decoder *binaryDecoder) decode(value reflect.Value) error {
+	switch value.Kind() {
+
+	case reflect.Bool:
+		b, err := decoder.readByte()
+		if err != nil {
+			return err
+		}
+		value.SetBool(b != 0)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := decoder.readVarint()
+		if err != nil {
+			return err
+		}
+		if value.OverflowInt(x) {
+			return fmt.Errorf("binary codec: %v overflows %v", x, value.Type())
+		}
+		value.SetInt(x)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := decoder.readUvarint()
+		if err != nil {
+			return err
+		}
+		if value.OverflowUint(x) {
+			return fmt.Errorf("binary codec: %v overflows %v", x, value.Type())
+		}
+		value.SetUint(x)
+
+	case reflect.Float32, reflect.Float64:
+		if decoder.remaining() < 8 {
+			return errBinaryTruncated
+		}
+		value.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(decoder.data[decoder.offset:])))
+		decoder.offset += 8
+
+	case reflect.String:
+		data, err := decoder.readBytes()
+		if err != nil {
+			return err
+		}
+		value.SetString(string(data))
+
+	case reflect.Pointer:
+		if value.Type().Elem() == bigIntType {
+			number, err := decoder.decodeBigInt()
+			if err != nil {
+				return err
+			}
+			value.Set(reflect.ValueOf(number))
+			return nil
+		}
+
+		present, err := decoder.readByte()
+		if err != nil {
+			return err
+		}
+		if present == 0 {
+			value.Set(reflect.Zero(value.Type()))
+			return nil
+		}
+		value.Set(reflect.New(value.Type().Elem()))
+		return decoder.decode(value.Elem())
+
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if !value.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := decoder.decode(value.Field(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			data, err := decoder.readBytes()
+			if err != nil {
+				return err
+			}
+			value.SetBytes(data)
+			return nil
+		}
+
+		length, err := decoder.readLength()
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			value.Set(reflect.Zero(value.Type()))
+			return nil
+		}
+		slice := reflect.MakeSlice(value.Type(), length, length)
+		for i := 0; i < length; i++ {
+			if err := decoder.decode(slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+
+	case reflect.Array:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			if decoder.remaining() < value.Len() {
+				return errBinaryTruncated
+			}
+			for i := 0; i < value.Len(); i++ {
+				value.Index(i).SetUint(uint64(decoder.data[decoder.offset+i]))
+			}
+			decoder.offset += value.Len()
+			return nil
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := decoder.decode(value.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		length, err := decoder.readLength()
+		if err != nil {
+			return err
+		}
+		mapValue := reflect.MakeMapWithSize(value.Type(), length)
+		for i := 0; i < length; i++ {
+			key := reflect.New(value.Type().Key()).Elem()
+			if err := decoder.decode(key); err != nil {
+				return err
+			}
+			element := reflect.New(value.Type().Elem()).Elem()
+			if err := decoder.decode(element); err != nil {
+				return err
+			}
+			mapValue.SetMapIndex(key, element)
+		}
+		value.Set(mapValue)
+
+	default:
+		return fmt.Errorf("binary codec: can't decode %v", value.Type())
+	}
+
+	return nil
+}
+
+func (decoder *binaryDecoder) decodeBigInt() (*big.Int, error) {
+	sign, err := decoder.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if sign == 0 {
+		return nil, nil
+	}
+	if sign > 2 {
+		return nil, errors.New("binary codec: invalid big.Int sign")
+	}
+
+	magnitude, err := decoder.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	number := new(big.Int).SetBytes(magnitude)
+	if sign == 2 {
+		number.Neg(number)
+	}
+	return number, nil
+}
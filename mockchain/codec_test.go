@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"noobcash/script"
+	"noobcash/signature"
+)
+
+var codecs = []Codec{JSONCodec, BinaryCodec}
+
+/*
+A block like the ones nodes mine, with every kind of transaction in it: the coinbase, payments
+with change, a spend of a script output and a multisig spend with its cosignatures.
+*/
+func testBlock(tb testing.TB, transactions int) HashedBlock {
+	var node Node
+	node.params = defaultConfig().Params
+	node.generateWallet(signature.Ed25519)
+
+	cosignerKey, err := signature.GenerateKey(signature.Secp256k1)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	policy, err := newMultisigPolicy(2, []signature.PublicKey{node.publicKey, cosignerKey.Public()})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	block := HashedBlock{
+		BlockHeader: BlockHeader{
+			Index:        7,
+			Timestamp:    1700000000000,
+			PreviousHash: generateRandom32Byte(),
+			Nonce:        generateRandom32Byte(),
+			Target:       generateRandom32Byte(),
+		},
+		Hash: generateRandom32Byte(),
+	}
+	block.Transactions = append(block.Transactions, node.createCoinbase(block.Index, uint(transactions)))
+
+	for i := 0; i < transactions; i++ {
+		transaction := Transaction{
+			SenderAddress:      node.publicKey,
+			Fee:                1,
+			TransactionInputs:  []TransactionInput{{PreviousOutputID: generateRandom32Byte()}, {PreviousOutputID: generateRandom32Byte()}},
+			TransactionOutputs: []TransactionOutput{{RecipientAddress: addressOf(cosignerKey.Public()), Amount: uint(10 + i)}, {RecipientAddress: node.walletAddress, Amount: 5}},
+		}
+
+		switch i % 3 {
+		case 1:
+			transaction.TransactionInputs[0].UnlockingScript = script.ClaimHashTimeLock([]byte{1, 2, 3}, []byte("preimage"))
+			transaction.TransactionOutputs[0].LockingScript = script.PayToMultisig(1, policy.PublicKeys)
+			transaction.TransactionOutputs[0].RecipientAddress = scriptAddress(transaction.TransactionOutputs[0].LockingScript)
+		case 2:
+			transaction.SenderAddress = signature.PublicKey{}
+			transaction.Multisig = &policy
+		}
+		transaction.setIDs()
+
+		signedTransaction, err := node.signTransaction(transaction)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if transaction.Multisig != nil {
+			signedTransaction.Signature = nil
+			for _, signer := range []*signature.PrivateKey{node.privateKey, cosignerKey} {
+				cosignature, err := signer.Sign(transaction.TransactionID[:])
+				if err != nil {
+					tb.Fatal(err)
+				}
+				signedTransaction.Cosignatures = append(signedTransaction.Cosignatures, Cosignature{KeyIndex: uint32(policy.keyIndex(signer.Public())), Signature: cosignature})
+			}
+		}
+		block.Transactions = append(block.Transactions, signedTransaction)
+	}
+
+	return block
+}
+
+// A block message has to come out of a frame as it went in, whatever the codec
+func TestCodecRoundTrip(t *testing.T) {
+	block := testBlock(t, 10)
+
+	for _, codec := range codecs {
+		t.Run(codec.String(), func(t *testing.T) {
+			data, err := marshalWithCodec(codec, BlockMessage{Block: block})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var frames bytes.Buffer
+			if err := writeFrame(&frames, Message{MessageType: BlockMessageType, MessageData: data, codec: codec}); err != nil {
+				t.Fatal(err)
+			}
+			message, err := readFrame(&frames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if message.MessageType != BlockMessageType || message.codec != codec {
+				t.Fatalf("got a message of type %v with the %v codec", message.MessageType, message.codec)
+			}
+
+			var blockMessage BlockMessage
+			if err := message.decode(&blockMessage); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(blockMessage.Block, block) {
+				t.Errorf("the block changed on the way:\n%+v\n%+v", blockMessage.Block, block)
+			}
+
+			// Cut short, the data must not decode to anything
+			var truncated BlockMessage
+			if err := unmarshalWithCodec(codec, data[:len(data)-1], &truncated); err == nil {
+				t.Error("truncated data decoded without an error")
+			}
+		})
+	}
+}
+
+// The size of a block message is reported along with the time it takes to encode and decode it
+func BenchmarkCodec(b *testing.B) {
+	blockMessage := BlockMessage{Block: testBlock(b, 100)}
+
+	for _, codec := range codecs {
+		data, err := marshalWithCodec(codec, blockMessage)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(codec.String()+"/marshal", func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := marshalWithCodec(codec, blockMessage); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "bytes")
+		})
+
+		b.Run(codec.String()+"/unmarshal", func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				var decoded BlockMessage
+				if err := unmarshalWithCodec(codec, data, &decoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "bytes")
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+A coin selector picks which of our unspent outputs pay for a transaction. Whatever the picked
+coins add up to over the payments and the fee comes back to us as change, so the choice decides
+how many outputs the wallet ends up with:
+
+  - largest-first spends the biggest coins, so transactions have few inputs
+  - smallest-sufficient spends the smallest single coin that covers everything, and falls back
+    to largest-first when no coin is big enough on its own
+  - branch-and-bound looks for coins that add up to exactly what is needed, so there is no
+    change output at all, and fails if there are none
+  - consolidate spends every coin, merging the wallet into a single change output
+*/
+type CoinSelector interface {
+	Name() string
+	Select(available []TransactionOutput, target uint) ([]TransactionOutput, error)
+}
+
+// How many branches branch-and-bound explores before giving up
+const maxBranchAndBoundTries = 100000
+
+var errNotEnoughCoins = errors.New("not enough coins")
+var errNoExactMatch = errors.New("no coins add up to exactly the amount needed")
+
+var coinSelectors = []CoinSelector{largestFirst{}, smallestSufficient{}, branchAndBound{}, consolidate{}}
+
+func parseCoinSelector(name string) (CoinSelector, error) {
+	names := make([]string, 0, len(coinSelectors))
+	for _, coinSelector := range coinSelectors {
+		if coinSelector.Name() == name {
+			return coinSelector, nil
+		}
+		names = append(names, coinSelector.Name())
+	}
+	return nil, fmt.Errorf("unknown coin selection %v, must be one of %v", name, strings.Join(names, ", "))
+}
+
+// The coins from the largest to the smallest, and by ID when the amounts are equal
+func sortCoins(available []TransactionOutput, largestFirst bool) []TransactionOutput {
+	coins := make([]TransactionOutput, len(available))
+	copy(coins, available)
+
+	sort.Slice(coins, func(i, j int) bool {
+		if coins[i].Amount != coins[j].Amount {
+			return (coins[i].Amount > coins[j].Amount) == largestFirst
+		}
+		return bytes.Compare(coins[i].ID[:], coins[j].ID[:]) < 0
+	})
+	return coins
+}
+
+type largestFirst struct{}
+
+func (largestFirst) Name() string {
+	return "largest-first"
+}
+
+func (largestFirst) Select(available []TransactionOutput, target uint) ([]TransactionOutput, error) {
+	selected := make([]TransactionOutput, 0)
+	var total uint = 0
+
+	for _, coin := range sortCoins(available, true) {
+		if total >= target {
+			break
+		}
+		selected = append(selected, coin)
+		total += coin.Amount
+	}
+
+	if total < target {
+		return nil, errNotEnoughCoins
+	}
+	return selected, nil
+}
+
+type smallestSufficient struct{}
+
+func (smallestSufficient) Name() string {
+	return "smallest-sufficient"
+}
+
+func (smallestSufficient) Select(available []TransactionOutput, target uint) ([]TransactionOutput, error) {
+	for _, coin := range sortCoins(available, false) {
+		if coin.Amount >= target {
+			return []TransactionOutput{coin}, nil
+		}
+	}
+	return largestFirst{}.Select(available, target)
+}
+
+type branchAndBound struct{}
+
+func (branchAndBound) Name() string {
+	return "branch-and-bound"
+}
+
+/*
+A depth first search over the coins from the largest to the smallest, where every coin is
+either taken or left. A branch stops as soon as it goes over the target or the coins left can't
+reach it. Leaving a coin and then taking one of the same amount gives the same sums as taking
+it, so those branches are skipped.
+*/
+func (branchAndBound) Select(available []TransactionOutput, target uint) ([]TransactionOutput, error) {
+	coins := sortCoins(available, true)
+
+	// remaining[i] is what the coins from i on add up to
+	remaining := make([]uint, len(coins)+1)
+	for i := len(coins) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + coins[i].Amount
+	}
+	if remaining[0] < target {
+		return nil, errNotEnoughCoins
+	}
+
+	tries := 0
+	var found []int
+
+	var search func(i int, total uint, chosen []int) bool
+	search = func(i int, total uint, chosen []int) bool {
+		if total == target {
+			found = append([]int{}, chosen...)
+			return true
+		}
+		if i == len(coins) || total > target || total+remaining[i] < target || tries >= maxBranchAndBoundTries {
+			return false
+		}
+		tries++
+
+		if search(i+1, total+coins[i].Amount, append(chosen, i)) {
+			return true
+		}
+
+		next := i + 1
+		for next < len(coins) && coins[next].Amount == coins[i].Amount {
+			next++
+		}
+		return search(next, total, chosen)
+	}
+
+	if !search(0, 0, make([]int, 0, len(coins))) {
+		return nil, errNoExactMatch
+	}
+
+	selected := make([]TransactionOutput, len(found))
+	for i, index := range found {
+		selected[i] = coins[index]
+	}
+	return selected, nil
+}
+
+type consolidate struct{}
+
+func (consolidate) Name() string {
+	return "consolidate"
+}
+
+func (consolidate) Select(available []TransactionOutput, target uint) ([]TransactionOutput, error) {
+	var total uint = 0
+	for _, coin := range available {
+		total += coin.Amount
+	}
+
+	if total < target || len(available) == 0 {
+		return nil, errNotEnoughCoins
+	}
+	return sortCoins(available, true), nil
+}
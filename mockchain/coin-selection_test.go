@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"noobcash/signature"
+)
+
+func testCoins(amounts ...uint) []TransactionOutput {
+	coins := make([]TransactionOutput, len(amounts))
+	for i, amount := range amounts {
+		coins[i] = TransactionOutput{ID: sha256.Sum256([]byte{byte(i)}), Amount: amount}
+	}
+	return coins
+}
+
+// The amounts of the coins, largest first
+func amountsOf(coins []TransactionOutput) []uint {
+	amounts := make([]uint, len(coins))
+	for i, coin := range coins {
+		amounts[i] = coin.Amount
+	}
+	sort.Slice(amounts, func(i, j int) bool { return amounts[i] > amounts[j] })
+	return amounts
+}
+
+func TestCoinSelectionPicks(t *testing.T) {
+	coins := testCoins(7, 50, 3, 30, 20)
+
+	cases := []struct {
+		coinSelector CoinSelector
+		target       uint
+		want         []uint
+	}{
+		{largestFirst{}, 60, []uint{50, 30}},
+		{largestFirst{}, 50, []uint{50}},
+		{smallestSufficient{}, 25, []uint{30}},
+		{smallestSufficient{}, 30, []uint{30}},
+		{smallestSufficient{}, 60, []uint{50, 30}}, // no coin is enough on its own
+		{branchAndBound{}, 33, []uint{30, 3}},
+		{branchAndBound{}, 10, []uint{7, 3}},
+		{branchAndBound{}, 110, []uint{50, 30, 20, 7, 3}},
+		{consolidate{}, 1, []uint{50, 30, 20, 7, 3}},
+	}
+	for _, c := range cases {
+		selected, err := c.coinSelector.Select(coins, c.target)
+		if err != nil {
+			t.Errorf("%v for %v: %v", c.coinSelector.Name(), c.target, err)
+			continue
+		}
+		if got := amountsOf(selected); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v for %v picked %v, want %v", c.coinSelector.Name(), c.target, got, c.want)
+		}
+	}
+}
+
+// Branch-and-bound only takes coins that add up to exactly the target
+func TestCoinSelectionExactMatch(t *testing.T) {
+	coins := testCoins(50, 30, 20, 7, 3)
+
+	for target := uint(1); target <= 110; target++ {
+		selected, err := branchAndBound{}.Select(coins, target)
+		if err != nil {
+			if !errors.Is(err, errNoExactMatch) {
+				t.Errorf("for %v: %v", target, err)
+			}
+			continue
+		}
+
+		var total uint
+		for _, coin := range selected {
+			total += coin.Amount
+		}
+		if total != target {
+			t.Errorf("for %v picked coins adding up to %v", target, total)
+		}
+	}
+
+	if _, err := (branchAndBound{}).Select(coins, 1); !errors.Is(err, errNoExactMatch) {
+		t.Errorf("found an exact match for 1: %v", err)
+	}
+}
+
+func TestCoinSelectionInsufficientFunds(t *testing.T) {
+	for _, coinSelector := range coinSelectors {
+		if _, err := coinSelector.Select(testCoins(5, 5), 11); !errors.Is(err, errNotEnoughCoins) {
+			t.Errorf("%v with too few coins: %v", coinSelector.Name(), err)
+		}
+		if _, err := coinSelector.Select(nil, 1); !errors.Is(err, errNotEnoughCoins) {
+			t.Errorf("%v with no coins: %v", coinSelector.Name(), err)
+		}
+	}
+}
+
+// A wallet with one coin of each amount, whose transactions can be looked at without signing them
+func testWallet(amounts ...uint) *Node {
+	node := &Node{}
+	node.generateWallet(signature.Ed25519)
+	node.myUTXOs = *NewStack()
+	for _, coin := range testCoins(amounts...) {
+		coin.RecipientAddress = node.walletAddress
+		node.myUTXOs.Push(coin)
+	}
+	return node
+}
+
+// What isn't paid or left as fee comes back as the last output, and only if there is any
+func TestTransactionChange(t *testing.T) {
+	recipient := Address{Type: KeyAddress, Hash: [20]byte{1}}
+	payments := []Payment{{Address: recipient, Amount: 40}}
+
+	node := testWallet(50)
+	transaction, err := node.createTransaction(payments, 2, largestFirst{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := transaction.TransactionOutputs
+	if len(outputs) != 2 || outputs[0].RecipientAddress != recipient || outputs[0].Amount != 40 {
+		t.Fatalf("outputs %+v", outputs)
+	}
+	if outputs[1].RecipientAddress != node.walletAddress || outputs[1].Amount != 8 {
+		t.Errorf("change %+v", outputs[1])
+	}
+
+	// Coins that add up to the payment and the fee leave no change output, not even an empty one
+	node = testWallet(30, 12, 9)
+	transaction, err = node.createTransaction(payments, 2, branchAndBound{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transaction.TransactionOutputs) != 1 || len(transaction.TransactionInputs) != 2 {
+		t.Errorf("%v inputs and outputs %+v", len(transaction.TransactionInputs), transaction.TransactionOutputs)
+	}
+
+	if _, err := node.createTransaction([]Payment{{Address: recipient, Amount: 50}}, 2, largestFirst{}); !errors.Is(err, errNotEnoughCoins) {
+		t.Errorf("paying more than the wallet has: %v", err)
+	}
+}
+
+/*
+The small coins a wallet collects from change are only spent when the big ones don't cover a
+payment, until the wallet is consolidated: then they all go into one change output.
+*/
+func TestTransactionDust(t *testing.T) {
+	recipient := Address{Type: KeyAddress, Hash: [20]byte{1}}
+	node := testWallet(1, 1, 2, 1, 100)
+
+	transaction, err := node.createTransaction([]Payment{{Address: recipient, Amount: 50}}, 1, largestFirst{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transaction.TransactionInputs) != 1 {
+		t.Errorf("spent %v coins where the largest was enough", len(transaction.TransactionInputs))
+	}
+
+	transaction, err = node.createTransaction([]Payment{{Address: recipient, Amount: 102}}, 1, largestFirst{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transaction.TransactionInputs) != 3 || len(transaction.TransactionOutputs) != 1 {
+		t.Errorf("%v inputs and %v outputs to pay with the largest coins and no change", len(transaction.TransactionInputs), len(transaction.TransactionOutputs))
+	}
+
+	transaction, err = node.createTransaction(nil, 1, consolidate{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs := transaction.TransactionOutputs
+	if len(transaction.TransactionInputs) != 5 || len(outputs) != 1 || outputs[0].Amount != 104 || outputs[0].RecipientAddress != node.walletAddress {
+		t.Errorf("consolidating gave %v inputs and outputs %+v", len(transaction.TransactionInputs), outputs)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"noobcash/signature"
+)
+
+/*
+Every block after the genesis block starts with a coinbase transaction, which pays the miner
+the block subsidy plus the fees of the other transactions in the block. Like the transactions
+of the genesis block it comes from the zero key, which has no scheme, and has no signature. Its only input doesn't
+spend anything: it holds the index of the block, so that two coinbases paying the same miner
+the same amount still get different ids.
+
+The subsidy starts at BlockReward and halves every HalvingInterval blocks.
+*/
+
+func zeroPublicKey() signature.PublicKey {
+	return signature.PublicKey{}
+}
+
+func isZeroPublicKey(publicKey signature.PublicKey) bool {
+	return publicKey.IsZero()
+}
+
+func coinbaseInputID(index uint) [32]byte {
+	var id [32]byte
+	binary.BigEndian.PutUint64(id[24:], uint64(index))
+	return id
+}
+
+func isCoinbase(transaction SignedTransaction) bool {
+	return isZeroPublicKey(transaction.SenderAddress) && transaction.Multisig == nil && len(transaction.TransactionInputs) == 1
+}
+
+func (params *ChainParams) blockSubsidy(index uint) uint {
+	if params.HalvingInterval <= 0 {
+		return params.BlockReward
+	}
+
+	halvings := index / uint(params.HalvingInterval)
+	if halvings >= 64 {
+		return 0
+	}
+	return params.BlockReward >> halvings
+}
+
+func blockFees(transactions []SignedTransaction) uint {
+	var fees uint = 0
+	for _, transaction := range transactions {
+		if !isCoinbase(transaction) {
+			fees += transaction.Fee
+		}
+	}
+	return fees
+}
+
+func (node *Node) createCoinbase(index uint, fees uint) SignedTransaction {
+	amount := node.params.blockSubsidy(index) + fees
+
+	transaction := Transaction{
+		SenderAddress:      zeroPublicKey(),
+		TransactionInputs:  []TransactionInput{{PreviousOutputID: coinbaseInputID(index)}},
+		TransactionOutputs: []TransactionOutput{{RecipientAddress: node.walletAddress, Amount: amount}},
+	}
+	transaction.setIDs()
+
+	return SignedTransaction{
+		SenderAddress:      transaction.SenderAddress,
+		TransactionID:      transaction.TransactionID,
+		TransactionInputs:  transaction.TransactionInputs,
+		TransactionOutputs: transaction.TransactionOutputs,
+		Signature:          []byte{0},
+	}
+}
+
+/*
+The first transaction of a block must be a coinbase for the block's index with a single output
+to the miner, and no other transaction may be one. What it may claim depends on the fees of the
+transactions that are valid, so its amount is checked with them in checkBlockTransactions.
+*/
+func (node *Node) validateCoinbase(hashedBlock HashedBlock) bool {
+	if len(hashedBlock.Transactions) == 0 {
+		return false
+	}
+
+	coinbase := hashedBlock.Transactions[0]
+	if !isCoinbase(coinbase) || coinbase.TransactionInputs[0].PreviousOutputID != coinbaseInputID(hashedBlock.Index) {
+		return false
+	}
+
+	unsignedCoinbase := coinbase.unsigned()
+	if !unsignedCoinbase.idsValid() || coinbase.Fee != 0 {
+		return false
+	}
+
+	// A single output, to the miner
+	if len(coinbase.TransactionOutputs) != 1 {
+		return false
+	}
+
+	for _, transaction := range hashedBlock.Transactions[1:] {
+		if isCoinbase(transaction) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The coinbase was checked with the rest of the block, so its outputs only need to be added
+func (node *Node) applyCoinbase(coinbase SignedTransaction) {
+	minerOutput := coinbase.TransactionOutputs[0]
+
+	node.UTXOsCommitted.Add(minerOutput)
+	if minerOutput.RecipientAddress == node.walletAddress {
+		node.myUTXOs.Push(minerOutput)
+	}
+}
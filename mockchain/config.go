@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"noobcash/signature"
+)
+
+/*
+Chain parameters are the values that every node of the network must agree on. The bootstrap
+node sends them to every joining node in the WelcomeMessage, and a joining node refuses to
+continue if they don't match the ones it was started with.
+*/
+type ChainParams struct {
+	NumNodes         int
+	Difficulty       int             // number of hex digits to be zero on the start of the first blocks' hashes
+	Capacity         int             // number of transactions in a block, not counting the coinbase
+	Genesis          map[string]uint // coins given to each node id in the genesis block
+	TargetBlockTime  float64         // seconds between blocks that retargeting aims for, 0 to keep the difficulty fixed
+	RetargetInterval int             // number of blocks between retargets
+	BlockReward      uint            // coins the coinbase of a block creates, on top of the fees
+	HalvingInterval  int             // number of blocks after which the block reward halves, 0 to never halve
+}
+
+/*
+Config holds everything a node needs to start. It can be read from a JSON file given with
+-config, and every value can be overridden from the command line.
+*/
+type Config struct {
+	LocalAddress     string
+	BootstrapAddress string
+	TransactionFile  string
+	IsBootstrap      bool
+	DataDir          string // where the blockchain and the wallet are kept, empty to keep everything in memory
+	Wallet           string // encrypted key file made by "wallet new", instead of the data directory's key
+	Codec            string // preferred codec for messages to other nodes, "binary" or "json"
+	CoinSelection    string // how the wallet picks the coins to spend, see coin-selection.go
+	SignatureScheme  string // scheme of the key a node makes for itself, see the signature package
+	HTTPAddress      string // ip:port the REST API listens on, empty to not serve it (see api.go)
+	APIToken         string // token that lets clients of the API spend the node's coins, empty to not let them
+	RPCAddress       string // ip:port the JSON-RPC server listens on, empty to not serve it (see rpc.go)
+	RPCSocket        string // Unix socket the JSON-RPC server listens on, empty to not serve it
+
+	Params ChainParams
+}
+
+func defaultConfig() Config {
+	return Config{
+		LocalAddress:     "localhost:50000",
+		BootstrapAddress: "localhost:50000",
+		TransactionFile:  "none",
+		IsBootstrap:      false,
+		Codec:            "binary",
+		CoinSelection:    "largest-first",
+		SignatureScheme:  "ed25519",
+		Params: ChainParams{
+			NumNodes:         5,
+			Difficulty:       4,
+			Capacity:         1,
+			RetargetInterval: 10,
+			BlockReward:      10,
+			HalvingInterval:  100,
+		},
+	}
+}
+
+func loadConfig(path string, config *Config) error {
+	configJSON, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(configJSON, config)
+}
+
+/*
+Parse the command line. Values are taken from the defaults first, then from the config file
+(if any), and last from the flags that were explicitly set.
+*/
+func parseConfig() (Config, bool, error) {
+	config := defaultConfig()
+
+	configFile := flag.String("config", "", "JSON file containing this node's configuration")
+	localAddress := flag.String("localAddress", config.LocalAddress, "This node's ip:port")
+	bootstrapAddress := flag.String("bootstrapAddress", config.BootstrapAddress, "Bootstrap node's ip:port")
+	transactionFile := flag.String("transactionFile", config.TransactionFile, "File containing this node's transactions")
+	isBootstrap := flag.Bool("isBootstrap", config.IsBootstrap, "True if this node is bootstrap")
+	dataDir := flag.String("dataDir", config.DataDir, "Directory to keep the blockchain and wallet in across restarts")
+	wallet := flag.String("wallet", config.Wallet, "Encrypted key file to use as this node's wallet")
+	codec := flag.String("codec", config.Codec, "Preferred message encoding, binary or json")
+	coinSelection := flag.String("coinSelection", config.CoinSelection, "How the wallet picks coins: largest-first, smallest-sufficient, branch-and-bound or consolidate")
+	signatureScheme := flag.String("signatureScheme", config.SignatureScheme, "Signature scheme of a new wallet key: rsa, ed25519 or secp256k1")
+	httpAddress := flag.String("httpAddress", config.HTTPAddress, "ip:port to serve the REST API on, empty to not serve it")
+	apiToken := flag.String("apiToken", config.APIToken, "Token clients of the API must send to spend this node's coins, empty to not let them (or MOCKCHAIN_API_TOKEN)")
+	rpcAddress := flag.String("rpcAddress", config.RPCAddress, "ip:port to serve JSON-RPC on over HTTP, empty to not serve it")
+	rpcSocket := flag.String("rpcSocket", config.RPCSocket, "Unix socket to serve JSON-RPC on, empty to not serve it")
+	numNodes := flag.Int("numNodes", config.Params.NumNodes, "Number of nodes in the network")
+	difficulty := flag.Int("difficulty", config.Params.Difficulty, "Number of hex digits to be zero on the start of a block's hash")
+	capacity := flag.Int("capacity", config.Params.Capacity, "Number of transactions in a block")
+	targetBlockTime := flag.Float64("targetBlockTime", config.Params.TargetBlockTime, "Seconds between blocks to adjust the difficulty for, 0 to keep it fixed")
+	retargetInterval := flag.Int("retargetInterval", config.Params.RetargetInterval, "Number of blocks between difficulty adjustments")
+	blockReward := flag.Uint("blockReward", config.Params.BlockReward, "Coins the miner of a block gets on top of the fees")
+	halvingInterval := flag.Int("halvingInterval", config.Params.HalvingInterval, "Number of blocks after which the block reward halves, 0 to never halve")
+
+	flag.Parse()
+
+	explicitParams := false
+
+	if *configFile != "" {
+		if err := loadConfig(*configFile, &config); err != nil {
+			return Config{}, false, fmt.Errorf("parseConfig: %w", err)
+		}
+		explicitParams = true
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "localAddress":
+			config.LocalAddress = *localAddress
+		case "bootstrapAddress":
+			config.BootstrapAddress = *bootstrapAddress
+		case "transactionFile":
+			config.TransactionFile = *transactionFile
+		case "isBootstrap":
+			config.IsBootstrap = *isBootstrap
+		case "dataDir":
+			config.DataDir = *dataDir
+		case "wallet":
+			config.Wallet = *wallet
+		case "codec":
+			config.Codec = *codec
+		case "coinSelection":
+			config.CoinSelection = *coinSelection
+		case "signatureScheme":
+			config.SignatureScheme = *signatureScheme
+		case "httpAddress":
+			config.HTTPAddress = *httpAddress
+		case "apiToken":
+			config.APIToken = *apiToken
+		case "rpcAddress":
+			config.RPCAddress = *rpcAddress
+		case "rpcSocket":
+			config.RPCSocket = *rpcSocket
+		case "numNodes":
+			config.Params.NumNodes = *numNodes
+			explicitParams = true
+		case "difficulty":
+			config.Params.Difficulty = *difficulty
+			explicitParams = true
+		case "capacity":
+			config.Params.Capacity = *capacity
+			explicitParams = true
+		case "targetBlockTime":
+			config.Params.TargetBlockTime = *targetBlockTime
+			explicitParams = true
+		case "retargetInterval":
+			config.Params.RetargetInterval = *retargetInterval
+			explicitParams = true
+		case "blockReward":
+			config.Params.BlockReward = *blockReward
+			explicitParams = true
+		case "halvingInterval":
+			config.Params.HalvingInterval = *halvingInterval
+			explicitParams = true
+		}
+	})
+
+	// A token on the command line shows up in the process list, so it can be set in the environment instead
+	if token, ok := os.LookupEnv("MOCKCHAIN_API_TOKEN"); ok && config.APIToken == "" {
+		config.APIToken = token
+	}
+
+	if len(config.Params.Genesis) == 0 {
+		config.Params.Genesis = map[string]uint{"id0": uint(100 * config.Params.NumNodes)}
+	}
+
+	if err := config.Params.validate(); err != nil {
+		return Config{}, false, err
+	}
+	if _, err := parseCodec(config.Codec); err != nil {
+		return Config{}, false, err
+	}
+	if _, err := parseCoinSelector(config.CoinSelection); err != nil {
+		return Config{}, false, err
+	}
+	if _, err := signature.ParseScheme(config.SignatureScheme); err != nil {
+		return Config{}, false, err
+	}
+
+	return config, explicitParams, nil
+}
+
+func (params *ChainParams) validate() error {
+	if params.NumNodes < 2 {
+		return errors.New("chain params: NumNodes must be at least 2")
+	}
+	if params.Difficulty < 0 || params.Difficulty > 64 {
+		return errors.New("chain params: Difficulty must be between 0 and 64")
+	}
+	if params.Capacity < 1 {
+		return errors.New("chain params: Capacity must be at least 1")
+	}
+	if params.TargetBlockTime < 0 {
+		return errors.New("chain params: TargetBlockTime can't be negative")
+	}
+	if params.TargetBlockTime > 0 && params.RetargetInterval < 2 {
+		return errors.New("chain params: RetargetInterval must be at least 2")
+	}
+	if params.HalvingInterval < 0 {
+		return errors.New("chain params: HalvingInterval can't be negative")
+	}
+	if params.Genesis["id0"] < uint(100*(params.NumNodes-1)) {
+		return errors.New("chain params: id0 needs at least 100 coins for every other node in the genesis block")
+	}
+	for id := range params.Genesis {
+		if !strings.HasPrefix(id, "id") {
+			return fmt.Errorf("chain params: invalid genesis node id %v", id)
+		}
+		number, err := strconv.Atoi(id[2:])
+		if err != nil || number < 0 || number >= params.NumNodes {
+			return fmt.Errorf("chain params: invalid genesis node id %v", id)
+		}
+	}
+	return nil
+}
+
+func (params *ChainParams) equal(other ChainParams) bool {
+	if params.NumNodes != other.NumNodes || params.Difficulty != other.Difficulty || params.Capacity != other.Capacity {
+		return false
+	}
+	if params.TargetBlockTime != other.TargetBlockTime || params.RetargetInterval != other.RetargetInterval {
+		return false
+	}
+	if params.BlockReward != other.BlockReward || params.HalvingInterval != other.HalvingInterval {
+		return false
+	}
+	if len(params.Genesis) != len(other.Genesis) {
+		return false
+	}
+	for id, amount := range params.Genesis {
+		if otherAmount, ok := other.Genesis[id]; !ok || otherAmount != amount {
+			return false
+		}
+	}
+	return true
+}
+
+// Node ids of the genesis allocation in a fixed order, so that every node builds the same genesis block
+func (params *ChainParams) genesisIDs() []string {
+	ids := make([]string, 0, len(params.Genesis))
+	for id := range params.Genesis {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
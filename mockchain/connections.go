@@ -1,33 +1,111 @@
 package main
 
 import (
+	"io"
 	"log"
 	"net"
+	"noobcash/signature"
 	"strconv"
 	"time"
 )
 
+/*
+Failed dials are retried with exponential backoff, starting at initialBackoff and doubling up
+to maxBackoff, for maxDialAttempts attempts in total.
+*/
+const dialTimeout = 5 * time.Second
+const initialBackoff = 500 * time.Millisecond
+const maxBackoff = 30 * time.Second
+const maxDialAttempts = 10
+
+// How long a rejoining node has to answer the challenge of the bootstrap node
+const challengeTimeout = 5 * time.Second
+
+// How long a node that connects to us has to say who it is
+const handshakeTimeout = 5 * time.Second
+
+/*
+Connect to a node and keep the connection up. When the connection breaks we dial again, unless
+the node has left the network, we are leaving ourselves, or a new connection is already there.
+*/
 func (node *Node) connectionStart(id string, remoteAddress string) {
+	for {
+		connection, err := node.dialWithBackoff(id, remoteAddress)
+		if err != nil {
+			node.stopDialing(id)
 
-	connection, err := net.Dial("tcp", remoteAddress)
-	if err != nil {
-		log.Fatal("connectionStart:", err)
-	}
+			// We can't join without the bootstrap node, any other node may just have gone away
+			if id == "id0" && len(node.blockchain) == 0 {
+				log.Fatal("connectionStart: ", err)
+			}
+			log.Println("connectionStart: giving up on", id, err)
+			return
+		}
+		peer := newPeer(connection)
+
+		// The bootstrap node uses our id to tell a restarted node apart from a new one
+		node.sendNewConnectionMessage(peer)
 
-	if id != "id0" {
-		node.sendNewConnectionMessage(connection)
+		node.addPeer(id, peer)
+		node.stopDialing(id)
+
+		node.monitorConnection(peer)
+
+		// The bootstrap node hangs up on a node that can't prove it holds the key of its id
+		if peer.rejoining.Load() {
+			log.Fatal("connectionStart: the bootstrap node didn't take us back as ", node.id)
+		}
+
+		if node.leaving.Load() || node.hasDeparted(id) || !node.startDialing(id) {
+			return
+		}
+		log.Println("connectionStart: lost the connection to", id, "- reconnecting")
 	}
+}
+
+func (node *Node) dialWithBackoff(id string, remoteAddress string) (net.Conn, error) {
+	backoff := initialBackoff
 
-	node.connectionMap[id] = net.Conn(connection)
+	for attempt := 1; ; attempt++ {
+		connection, err := net.DialTimeout("tcp", remoteAddress, dialTimeout)
+		if err == nil {
+			return connection, nil
+		}
+
+		if attempt == maxDialAttempts || node.leaving.Load() || node.hasDeparted(id) {
+			return nil, err
+		}
+
+		log.Println("dialWithBackoff:", id, err, "- retrying in", backoff)
+		time.Sleep(backoff)
 
-	node.monitorConnection(connection, id)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
 }
 
-func (node *Node) monitorConnection(connection net.Conn, connectionID string) {
+/*
+Read and handle the messages of a peer until the connection breaks. A frame that can't be
+decoded means we have lost track of the stream, and so does a message whose data can't be, so
+the connection is closed and forgotten.
+*/
+func (node *Node) monitorConnection(peer *Peer) {
+	defer node.removePeer(peer)
+
 	for {
-		message, err := receiveMessage(connection)
+		message, err := peer.receive()
 		if err != nil {
-			continue
+			if !peer.isClosed() && err != io.EOF {
+				log.Println("monitorConnection:", peer.getID(), err)
+			}
+			return
+		}
+
+		// A peer that sends us binary frames understands them, even if it never said so in a handshake
+		if message.codec != peer.getCodec() && chooseCodec(node.codecs, []Codec{message.codec}) == message.codec {
+			peer.setCodec(message.codec)
 		}
 
 		switch message.MessageType {
@@ -36,52 +114,100 @@ func (node *Node) monitorConnection(connection net.Conn, connectionID string) {
 			continue
 
 		case WelcomeMessageType:
-			node.receiveWelcomeMessage(message.MessageData)
+			// Only the bootstrap node hands out ids, once for every time we join through it
+			if peer.getID() != "id0" || peer.welcomed.Swap(true) {
+				log.Println("monitorConnection: ignoring a welcome message from", peer.getID())
+				continue
+			}
+			peer.rejoining.Store(false)
+			var chainLength uint
+			if chainLength, err = node.receiveWelcomeMessage(message, peer); err != nil {
+				break
+			}
 
-			node.sendMyInfoMessage(connection)
+			node.sendMyInfoMessage(peer)
 
-		case MyInfoMessageType:
-			_ = node.receiveMyInfoMessage(message.MessageData)
+			/*
+				A node restored from its data directory only asks for the blocks it is missing. A node
+				that joins after the genesis block fetches the whole chain. The bootstrap node sends us
+				the node list before it answers, so we know every public key in the chain by then.
+			*/
+			if len(node.blockchain) > 0 || chainLength > 0 {
+				node.requestMissingBlocks(peer)
+			}
 
-			node.broadcastType = NodeDataMessageType
+		case MyInfoMessageType:
+			var id string
+			if id, err = node.receiveMyInfoMessage(message, peer); id == "" {
+				break
+			}
 
 			node.broadcastLock.Lock()
+			node.broadcastType = NodeDataMessageType
 			node.broadcast <- true
 
 		case NodeDataMessageType:
-			node.receiveNeighborsMessage(message.MessageData)
+			// The node list is the bootstrap node's to give out
+			if peer.getID() != "id0" {
+				log.Println("monitorConnection: ignoring a node list from", peer.getID())
+				continue
+			}
+			err = node.receiveNeighborsMessage(message)
 
 		case TransactionMessageType:
-			node.getTransactionMessage(message.MessageData)
+			err = node.getTransactionMessage(message, peer)
 
 		case BlockMessageType:
-			node.getBlockMessage(message.MessageData)
+			err = node.getBlockMessage(message)
 
 		case ResolveRequestMessageType:
-			node.getResolveRequestMessage(message.MessageData, connection)
+			err = node.getResolveRequestMessage(message, peer)
 
 		case ResolveResponseMessageType:
-			node.receiveResolveResponseMessage(message.MessageData)
+			err = node.receiveResolveResponseMessage(message)
+
+		case LeaveMessageType:
+			if err = node.receiveLeaveMessage(message, peer); err == nil {
+				return
+			}
+
+		case PingMessageType:
+			err = node.receivePingMessage(message, peer)
+
+		case PongMessageType:
+			err = node.receivePongMessage(message, peer)
+
+		case RejectMessageType:
+			err = node.receiveRejectMessage(message, peer)
+
+		case ChallengeMessageType:
+			err = node.receiveChallengeMessage(message, peer)
 
 		default:
 			continue
 		}
-	}
-}
 
-func (node *Node) getResolveRequestMessage(data []byte, connection net.Conn) {
-	resolveResponseMessage := node.receiveResolveRequestMessage(data)
-	node.sendResolveResponseMessage(connection, resolveResponseMessage)
+		if err != nil {
+			log.Printf("monitorConnection: %v sent a message of type %v that can't be decoded - %v", peer.getID(), message.MessageType, err)
+			return
+		}
+	}
 }
 
-func (node *Node) getTransactionMessage(data []byte) {
-	signedTransaction := node.receiveTransactionMessage(data)
-
-	node.transactionQueue.Push(signedTransaction)
+func (node *Node) getResolveRequestMessage(message Message, peer *Peer) error {
+	resolveResponseMessage, err := node.receiveResolveRequestMessage(message)
+	if err != nil {
+		return err
+	}
+	node.sendResolveResponseMessage(peer, resolveResponseMessage)
+	return nil
 }
 
-func (node *Node) getBlockMessage(data []byte) {
-	hashedBlock := node.receiveBlockMessage(data)
+func (node *Node) getBlockMessage(message Message) error {
+	hashedBlock, err := node.receiveBlockMessage(message)
+	if err != nil {
+		return err
+	}
 
 	node.blockchainLock.Lock()
 
@@ -90,6 +216,7 @@ func (node *Node) getBlockMessage(data []byte) {
 	}
 
 	node.blockchainLock.Unlock()
+	return nil
 }
 
 func (node *Node) broadcastMessages() {
@@ -98,63 +225,96 @@ func (node *Node) broadcastMessages() {
 
 		if flag {
 
-			for _, connection := range node.connectionMap {
+			for _, peer := range node.peers() {
 
 				switch node.broadcastType {
 
 				case NodeDataMessageType:
-					node.sendNodeDataMessage(connection)
+					node.sendNodeDataMessage(peer)
 
 				case TransactionMessageType:
-					node.sendTransactionMessage(connection, node.initiatedTransaction)
+					node.sendTransactionMessage(peer, node.initiatedTransaction)
 
 				case BlockMessageType:
-					node.sendBlockMessage(connection, node.minedBlock)
+					node.sendBlockMessage(peer, node.minedBlock)
 
 				case ResolveRequestMessageType:
-					node.resolvingConflict = true
-					node.sendResolveRequestMessage(connection, node.resolveRequestMessage)
-					time.Sleep(time.Millisecond * 200)
+					node.sendResolveRequestMessage(peer, node.resolveRequestMessage)
 
 				default:
 					log.Fatal("broadcastMessages: Trying to broadcast unknown message type")
 				}
 			}
 
-			node.resolvingConflict = false
 			node.broadcastLock.Unlock()
 		}
 	}
 }
 
 func (node *Node) acceptConnectionsBootstrap(listener net.Listener) {
-	currentID := 1
+	// A restarted bootstrap node continues numbering after the nodes it already knows
+	currentID := node.nodeCount()
+	if currentID == 0 {
+		currentID = 1
+	}
 
-	for {
-		connection, err := listener.Accept()
+	handshakes := make(chan handshake)
+	go node.acceptHandshakes(listener, handshakes)
+
+	for handshake := range handshakes {
+		peer := handshake.peer
+
+		rejoinID, err := node.receiveNewConnectionMessage(handshake.message, peer)
 		if err != nil {
+			peer.close()
 			continue
 		}
 
-		node.connectionMap["id"+strconv.Itoa(currentID)] = connection
+		/*
+			A node that was restarted from its data directory tells us the id it had. It gets
+			welcomed back with the same id once it proves it is that node, and fetches the blocks
+			it missed by itself.
+		*/
+		if rejoinID != "" {
+			if nodeData, ok := node.getNodeData(rejoinID); ok && rejoinID != node.id {
+				go node.welcomeBack(rejoinID, nodeData.PublicKey, peer)
+				continue
+			}
+		}
+
+		id := "id" + strconv.Itoa(currentID)
+
+		node.addPeer(id, peer)
+
+		go node.monitorConnection(peer)
 
-		go node.monitorConnection(connection, "id"+strconv.Itoa(currentID))
+		node.sendWelcomeMessage(peer, id)
 
-		node.sendWelcomeMessage(connection, "id"+strconv.Itoa(currentID))
+		/*
+			Ids keep being handed out after the genesis block. A node that joins late gets the node
+			list right away, so that it can check the chain it is about to fetch. Its coins have to
+			come from the other nodes.
+		*/
+		if len(node.blockchain) > 0 {
+			node.sendNodeDataMessage(peer)
 
-		if currentID == NUM_NODES-1 {
+		} else if currentID == node.params.NumNodes-1 {
 			time.Sleep(time.Millisecond * 100)
 
+			// Like a mined block, the genesis block goes in under the lock that waitForChain waits with
 			genesis := node.createGenesisBlock()
+			node.blockchainLock.Lock()
 			node.broadcastBlock(genesis)
+			node.blockchainLock.Unlock()
 
-			for id := range node.nodeDataMap {
+			for _, id := range node.nodeIDs() {
 				if id == node.id {
 					continue
 				}
+				address, _ := node.addressOfID(id)
 				time.Sleep(time.Second * 2)
-				for i := 0; i < CAPACITY; i++ {
-					if !node.sendFunds(id, 100/CAPACITY) {
+				for i := 0; i < node.params.Capacity; i++ {
+					if !node.sendFunds([]Payment{{Address: address, Amount: uint(100 / node.params.Capacity)}}, 0) {
 						log.Fatal("Couldn't create first transaction to node", id)
 					}
 				}
@@ -165,41 +325,202 @@ func (node *Node) acceptConnectionsBootstrap(listener net.Listener) {
 	}
 }
 
+/*
+Anyone can claim an old id, and taking it over also closes the connection of the node that has
+it. So the node has to sign a nonce of ours with the key registered for the id, and is turned
+away if it doesn't answer in time.
+*/
+func (node *Node) welcomeBack(id string, publicKey signature.PublicKey, peer *Peer) {
+	nonce := generateRandom32Byte()
+	sendMessage(peer, ChallengeMessageType, ChallengeMessage{Nonce: nonce})
+
+	_ = peer.connection.SetReadDeadline(time.Now().Add(challengeTimeout))
+	message, err := peer.receive()
+	_ = peer.connection.SetReadDeadline(time.Time{})
+
+	var response ChallengeResponseMessage
+	if err == nil && message.MessageType == ChallengeResponseMessageType {
+		err = message.decode(&response)
+	}
+	if err != nil || message.MessageType != ChallengeResponseMessageType || !publicKey.Verify(challengeDigest(id, nonce), response.Signature) {
+		log.Println("welcomeBack: a node claiming to be", id, "couldn't prove it")
+		peer.close()
+		return
+	}
+
+	node.addPeer(id, peer)
+
+	go node.monitorConnection(peer)
+
+	node.sendWelcomeMessage(peer, id)
+	node.sendNodeDataMessage(peer)
+}
+
+// Nodes with a larger id connect to us, including the ones that join after the genesis block
 func (node *Node) acceptConnections(listener net.Listener) {
-	for count := 0; count < NUM_NODES-2; count++ {
-		connection, err := listener.Accept()
+	handshakes := make(chan handshake)
+	go node.acceptHandshakes(listener, handshakes)
+
+	for handshake := range handshakes {
+		id, err := node.receiveNewConnectionMessage(handshake.message, handshake.peer)
 		if err != nil {
+			handshake.peer.close()
 			continue
 		}
-		message, err := receiveMessage(connection)
+		node.addPeer(id, handshake.peer)
+
+		go node.monitorConnection(handshake.peer)
+	}
+}
+
+// A new connection and the NewConnectionMessage it opened with
+type handshake struct {
+	peer    *Peer
+	message Message
+}
+
+/*
+Accept connections and pass on the ones that open with a NewConnectionMessage in time. Each one
+is read in a goroutine of its own, so that a connection that says nothing holds up no other.
+*/
+func (node *Node) acceptHandshakes(listener net.Listener, handshakes chan<- handshake) {
+	for {
+		connection, err := listener.Accept()
 		if err != nil {
 			continue
 		}
 
-		id := receiveNewConnectionMessage(message.MessageData)
-		node.connectionMap[id] = connection
+		go func() {
+			peer := newPeer(connection)
 
-		go node.monitorConnection(connection, id)
+			_ = connection.SetReadDeadline(time.Now().Add(handshakeTimeout))
+			message, err := peer.receive()
+			_ = connection.SetReadDeadline(time.Time{})
+
+			if err != nil || message.MessageType != NewConnectionMessageType {
+				peer.close()
+				return
+			}
+			handshakes <- handshake{peer: peer, message: message}
+		}()
 	}
 }
 
 func (node *Node) establishConnections() {
-	myID, err := strconv.Atoi(node.id[2:])
-	if err != nil {
-		log.Fatal("establishConnections:", err)
+	myID := idNumber(node.id)
+	if myID < 0 {
+		log.Fatal("establishConnections: invalid id ", node.id)
 	}
 
-	for id, nodeData := range node.nodeDataMap {
-		targetID, err := strconv.Atoi(id[2:])
-		if err != nil {
-			log.Fatal("establishConnections:", err)
+	for id, nodeData := range node.nodeDataSnapshot() {
+		targetID := idNumber(id)
+		if targetID < 0 {
+			log.Fatal("establishConnections: invalid id ", id)
 		}
 
-		if _, ok := node.connectionMap[id]; ok {
+		if nodeData.Departed || nodeData.Address == "" {
 			continue
 
-		} else if myID > targetID {
+		} else if myID > targetID && node.startDialing(id) {
 			go node.connectionStart(id, nodeData.Address)
 		}
 	}
 }
+
+/*
+Leave the network: tell every peer that we are going, so that they keep our public key but stop
+counting on us, and wait a little for the messages to go out. We can come back later with the
+same data directory and get our old id.
+*/
+func (node *Node) leave() {
+	node.leaving.Store(true)
+
+	peers := node.peers()
+	for _, peer := range peers {
+		node.sendLeaveMessage(peer)
+	}
+
+	deadline := time.Now().Add(time.Second * 2)
+	for _, peer := range peers {
+		for !peer.isClosed() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 10)
+		}
+		node.removePeer(peer)
+	}
+
+	if node.blockStore != nil {
+		node.blockchainLock.Lock()
+		node.blockStore.Close()
+		node.blockStore = nil
+		node.blockchainLock.Unlock()
+	}
+}
+
+func (node *Node) addPeer(id string, peer *Peer) {
+	node.connectionLock.Lock()
+	defer node.connectionLock.Unlock()
+
+	peer.setID(id)
+	if oldPeer, ok := node.connectionMap[id]; ok && oldPeer != peer {
+		oldPeer.close()
+	}
+	node.connectionMap[id] = peer
+	node.events.Publish(Event{Type: PeerEvent, Data: PeerChange{ID: id, Connected: true}})
+}
+
+// Forget a peer whose connection broke, unless it has already been replaced by a new connection
+func (node *Node) removePeer(peer *Peer) {
+	peer.close()
+
+	node.connectionLock.Lock()
+	defer node.connectionLock.Unlock()
+
+	if node.connectionMap[peer.getID()] == peer {
+		delete(node.connectionMap, peer.getID())
+		log.Println("Connection to", peer.getID(), "closed")
+		node.events.Publish(Event{Type: PeerEvent, Data: PeerChange{ID: peer.getID(), Connected: false}})
+	}
+}
+
+/*
+The node list arrives again every time a node joins, so we may be asked to connect to a node
+while we are still connecting to it. Two connections to the same node could make each side
+close a different one, leaving us with none.
+*/
+func (node *Node) startDialing(id string) bool {
+	node.connectionLock.Lock()
+	defer node.connectionLock.Unlock()
+
+	if _, ok := node.connectionMap[id]; ok || node.dialing[id] {
+		return false
+	}
+	node.dialing[id] = true
+	return true
+}
+
+func (node *Node) stopDialing(id string) {
+	node.connectionLock.Lock()
+	defer node.connectionLock.Unlock()
+
+	delete(node.dialing, id)
+}
+
+func (node *Node) hasPeer(id string) bool {
+	node.connectionLock.Lock()
+	defer node.connectionLock.Unlock()
+
+	_, ok := node.connectionMap[id]
+	return ok
+}
+
+// A snapshot of the connected peers, safe to range over while peers come and go
+func (node *Node) peers() []*Peer {
+	node.connectionLock.Lock()
+	defer node.connectionLock.Unlock()
+
+	peers := make([]*Peer, 0, len(node.connectionMap))
+	for _, peer := range node.connectionMap {
+		peers = append(peers, peer)
+	}
+	return peers
+}
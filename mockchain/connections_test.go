@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func testFrame(t *testing.T, connection net.Conn, messageType MessageType, messageData []byte) {
+	if err := writeFrame(connection, Message{MessageType: messageType, MessageData: messageData, codec: JSONCodec}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A connection that never says who it is doesn't keep the next one from being accepted
+func TestHandshakeDoesNotBlock(t *testing.T) {
+	var node Node
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	handshakes := make(chan handshake)
+	go node.acceptHandshakes(listener, handshakes)
+
+	silent, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	connection, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	data, err := marshalWithCodec(JSONCodec, NewConnectionMessage{ID: "id3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFrame(t, connection, NewConnectionMessageType, data)
+
+	select {
+	case handshake := <-handshakes:
+		defer handshake.peer.close()
+		if id, err := node.receiveNewConnectionMessage(handshake.message, handshake.peer); err != nil || id != "id3" {
+			t.Errorf("got id %q - %v", id, err)
+		}
+	case <-time.After(handshakeTimeout / 2):
+		t.Fatal("the second connection waited for the silent one")
+	}
+}
+
+// A message whose data doesn't decode closes the connection
+func TestUndecodableMessageClosesPeer(t *testing.T) {
+	var node Node
+	node.connectionMap = make(map[string]*Peer)
+	node.codecs = supportedCodecs(JSONCodec)
+
+	local, remote := net.Pipe()
+	defer remote.Close()
+	peer := newPeer(local)
+
+	done := make(chan struct{})
+	go func() {
+		node.monitorConnection(peer)
+		close(done)
+	}()
+
+	testFrame(t, remote, BlockMessageType, []byte("not a block"))
+
+	select {
+	case <-done:
+		if !peer.isClosed() {
+			t.Error("the peer is still open")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the connection is still being read")
+	}
+}
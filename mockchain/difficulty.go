@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"time"
+)
+
+/*
+Proof of work: a block is mined when its hash, read as a 256 bit big-endian number, is not
+greater than the target in its header. The target of every block is fixed by the chain before
+it, so validateBlock can check that nobody made things easier for themselves:
+
+  - the genesis block has the target of the Difficulty in the chain params
+  - every RetargetInterval blocks, the target is scaled by how long the last RetargetInterval
+    blocks took compared to TargetBlockTime, by at most a factor of retargetClamp either way
+  - every other block has the target of its parent
+
+With TargetBlockTime set to 0 the target never changes.
+*/
+const retargetClamp = 4
+
+// A block's timestamp must be after the median of the timestamps of this many blocks before it
+const medianTimeSpan = 11
+
+// and not further in the future than this, by our clock
+const maxFutureBlockTime = 2 * time.Minute
+
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+/*
+While trying to do the project we run up to this problem:
+The difficulty of the block is the amount of 0s that need to
+be found in the beginning of the SHA256 hash. That means that if
+the difficulty is 5, then the 5 MSBs of the hash must be 0. This turned
+out to be very easy for the node to calculate and caused some synchronization
+issues. Thus, we changed it from Difficulty bits to Difficulty hexadecimals
+(or nibbles, 4-bits).
+
+The Difficulty of the chain params is still given in nibbles. It becomes the target that
+makes the first Difficulty hex digits of the hash zero.
+*/
+func targetFromDifficulty(difficulty int) [32]byte {
+	target := new(big.Int).Lsh(big.NewInt(1), uint(256-4*difficulty))
+	target.Sub(target, big.NewInt(1))
+	return intToTarget(target)
+}
+
+func targetToInt(target [32]byte) *big.Int {
+	return new(big.Int).SetBytes(target[:])
+}
+
+func intToTarget(x *big.Int) [32]byte {
+	var target [32]byte
+	if x.Cmp(maxTarget) > 0 {
+		x = maxTarget
+	}
+	x.FillBytes(target[:])
+	return target
+}
+
+func hashMeetsTarget(hash [32]byte, target [32]byte) bool {
+	return bytes.Compare(hash[:], target[:]) <= 0
+}
+
+// The number of hashes it takes on average to mine a block, 2^256 / (target + 1)
+func blockWork(header BlockHeader) *big.Int {
+	denominator := targetToInt(header.Target)
+	denominator.Add(denominator, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+func ancestor(block *treeBlock, index uint) *treeBlock {
+	for block != nil && block.block.Index > index {
+		block = block.parent
+	}
+	return block
+}
+
+// The target that the child of parent must have
+func (node *Node) nextTarget(parent *treeBlock) [32]byte {
+	index := parent.block.Index + 1
+	interval := uint(node.params.RetargetInterval)
+
+	if node.params.TargetBlockTime <= 0 || index < interval || index%interval != 0 {
+		return parent.block.Target
+	}
+
+	// The blocks from index-interval to the parent are interval-1 block times apart
+	first := ancestor(parent, index-interval)
+	actual := parent.block.Timestamp - first.block.Timestamp
+	expected := int64(float64(interval-1) * node.params.TargetBlockTime * 1000)
+	if expected < 1 {
+		expected = 1
+	}
+
+	if actual < expected/retargetClamp {
+		actual = expected / retargetClamp
+	}
+	if actual > expected*retargetClamp {
+		actual = expected * retargetClamp
+	}
+
+	target := targetToInt(parent.block.Target)
+	target.Mul(target, big.NewInt(actual))
+	target.Div(target, big.NewInt(expected))
+	if target.Sign() == 0 {
+		target.SetInt64(1)
+	}
+	return intToTarget(target)
+}
+
+// The median timestamp of the last medianTimeSpan blocks up to and including block
+func medianTimePast(block *treeBlock) int64 {
+	timestamps := make([]int64, 0, medianTimeSpan)
+	for ; block != nil && len(timestamps) < medianTimeSpan; block = block.parent {
+		timestamps = append(timestamps, block.block.Timestamp)
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// A chain of tree blocks with the given timestamps, all with the same target
+func testTreeChain(target [32]byte, timestamps ...int64) []*treeBlock {
+	chain := make([]*treeBlock, len(timestamps))
+	var parent *treeBlock
+	for i, timestamp := range timestamps {
+		chain[i] = &treeBlock{
+			block:  HashedBlock{BlockHeader: BlockHeader{Index: uint(i), Timestamp: timestamp, Target: target}},
+			parent: parent,
+		}
+		parent = chain[i]
+	}
+	return chain
+}
+
+// Timestamps of count blocks, spacing milliseconds apart
+func evenTimestamps(count int, spacing int64) []int64 {
+	timestamps := make([]int64, count)
+	for i := range timestamps {
+		timestamps[i] = 1700000000000 + int64(i)*spacing
+	}
+	return timestamps
+}
+
+func testRetargetNode() *Node {
+	node := &Node{}
+	node.params = defaultConfig().Params
+	node.params.TargetBlockTime = 10
+	node.params.RetargetInterval = 10
+	return node
+}
+
+func scaledTarget(target [32]byte, numerator int64, denominator int64) [32]byte {
+	x := targetToInt(target)
+	x.Mul(x, big.NewInt(numerator))
+	x.Div(x, big.NewInt(denominator))
+	return intToTarget(x)
+}
+
+// The target follows the time the last interval took, but by no more than retargetClamp either way
+func TestRetargetClamp(t *testing.T) {
+	node := testRetargetNode()
+	target := targetFromDifficulty(4)
+	expected := int64(node.params.TargetBlockTime * 1000)
+
+	cases := []struct {
+		name    string
+		spacing int64
+		want    [32]byte
+	}{
+		{"on time", expected, target},
+		{"twice as slow", 2 * expected, scaledTarget(target, 2, 1)},
+		{"twice as fast", expected / 2, scaledTarget(target, 1, 2)},
+		{"far too slow", 100 * expected, scaledTarget(target, retargetClamp, 1)},
+		{"far too fast", 1, scaledTarget(target, 1, retargetClamp)},
+		{"all at once", 0, scaledTarget(target, 1, retargetClamp)},
+	}
+	for _, c := range cases {
+		chain := testTreeChain(target, evenTimestamps(node.params.RetargetInterval, c.spacing)...)
+		if got := node.nextTarget(chain[len(chain)-1]); got != c.want {
+			t.Errorf("%v: target %x, want %x", c.name, got, c.want)
+		}
+	}
+}
+
+// Only a block whose index is a multiple of RetargetInterval gets a new target
+func TestRetargetInterval(t *testing.T) {
+	node := testRetargetNode()
+	target := targetFromDifficulty(4)
+	interval := node.params.RetargetInterval
+
+	// Every block comes twice as fast as it should, so a retarget halves the target
+	chain := testTreeChain(target, evenTimestamps(2*interval+1, int64(node.params.TargetBlockTime*1000)/2)...)
+	for index := 1; index <= 2*interval; index++ {
+		want := target
+		if index%interval == 0 {
+			want = scaledTarget(target, 1, 2)
+		}
+		if got := node.nextTarget(chain[index-1]); got != want {
+			t.Errorf("block %v: target %x, want %x", index, got, want)
+		}
+	}
+
+	node.params.TargetBlockTime = 0
+	if got := node.nextTarget(chain[interval-1]); got != target {
+		t.Errorf("the target changed with retargeting off: %x", got)
+	}
+}
+
+func TestMedianTimePast(t *testing.T) {
+	target := targetFromDifficulty(4)
+
+	// Out of order, as the clocks of the miners allow, the median is still the middle one
+	chain := testTreeChain(target, 5, 1, 9, 3, 7, 11, 2, 10, 4, 8, 6)
+	if median := medianTimePast(chain[len(chain)-1]); median != 6 {
+		t.Errorf("median %v of 1 to 11", median)
+	}
+
+	// Only the last medianTimeSpan blocks count
+	chain = testTreeChain(target, 100, 100, 100, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11)
+	if median := medianTimePast(chain[len(chain)-1]); median != 6 {
+		t.Errorf("median %v of the last 11", median)
+	}
+
+	// A short chain uses the blocks it has
+	chain = testTreeChain(target, 30, 10, 20)
+	if median := medianTimePast(chain[len(chain)-1]); median != 20 {
+		t.Errorf("median %v of 3 blocks", median)
+	}
+}
+
+// A block must be later than the median time past and at most maxFutureBlockTime ahead of our clock
+func TestBlockTimestampLimits(t *testing.T) {
+	_, node := testBootstrapNode(t)
+	genesis := node.blockchain[0]
+	block := node.mineBlock(nil, 1, genesis.Hash)
+
+	withTimestamp := func(timestamp int64) HashedBlock {
+		changed := block
+		changed.Timestamp = timestamp
+		changed.Hash = changed.BlockHeader.hash()
+		return changed
+	}
+
+	now := time.Now()
+	rejected := map[string]int64{
+		"at the median time past": genesis.Timestamp,
+		"before it":               genesis.Timestamp - 1,
+		"too far in the future":   now.Add(maxFutureBlockTime + time.Minute).UnixMilli(),
+	}
+	for name, timestamp := range rejected {
+		node.blockchainLock.Lock()
+		valid := node.validateBlock(withTimestamp(timestamp))
+		node.blockchainLock.Unlock()
+		if valid {
+			t.Errorf("a block %v was accepted", name)
+		}
+	}
+
+	acceptBlock(t, node, withTimestamp(now.Add(maxFutureBlockTime-time.Minute).UnixMilli()))
+}
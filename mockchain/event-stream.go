@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+GET /api/v1/events streams the events of the node (see events.go) as server-sent events, which
+browsers read with EventSource. Each event has the name of its type and the same JSON as the
+REST API:
+
+	block        the header of the block (APIBlockSummary)
+	transaction  the transaction (APITransaction)
+	reorg        the fork point and the old and new tips (APIReorg)
+	peer         the peer and whether it is now connected (APIPeer)
+	balance      the new confirmed balance of a watched address (APIBalanceChange)
+
+Unlike the rest of the API, the stream can be followed before the node has the blockchain, so
+that it tells about the genesis block too.
+
+The stream can be narrowed down with type=block,transaction,... and address=<address|id>, both
+of which can be given more than once. The addresses are watched: the stream starts with their
+balances and tells when they change. With addresses given, block and transaction events only
+come for the ones that pay or spend from them, while reorg and peer events come anyway.
+*/
+
+const eventKeepAlive = 15 * time.Second
+
+var eventTypes = []EventType{BlockEvent, TransactionEvent, ReorgEvent, PeerEvent, BalanceEvent}
+
+type eventFilter struct {
+	types     map[EventType]bool
+	addresses map[Address]bool
+}
+
+func (node *Node) parseEventFilter(request *http.Request) (eventFilter, error) {
+	filter := eventFilter{types: make(map[EventType]bool), addresses: make(map[Address]bool)}
+	query := request.URL.Query()
+
+	for _, param := range query["type"] {
+		for _, name := range strings.Split(param, ",") {
+			eventType := EventType(name)
+			known := false
+			for _, candidate := range eventTypes {
+				known = known || candidate == eventType
+			}
+			if !known {
+				return filter, apiErrorf(http.StatusBadRequest, "unknown event type %v", name)
+			}
+			filter.types[eventType] = true
+		}
+	}
+	if len(filter.types) == 0 {
+		for _, eventType := range eventTypes {
+			filter.types[eventType] = true
+		}
+	}
+
+	for _, param := range query["address"] {
+		for _, name := range strings.Split(param, ",") {
+			address, err := node.parseReceiver(name)
+			if err != nil {
+				return filter, apiErrorf(http.StatusBadRequest, "%v", err)
+			}
+			filter.addresses[address] = true
+		}
+	}
+	return filter, nil
+}
+
+// Whether the transaction spends from or pays one of the watched addresses
+func (filter eventFilter) concerns(transaction SignedTransaction) bool {
+	if len(filter.addresses) == 0 {
+		return true
+	}
+	if !isCoinbase(transaction) && filter.addresses[transaction.senderAddress()] {
+		return true
+	}
+	for _, transactionOutput := range transaction.TransactionOutputs {
+		if filter.addresses[transactionOutput.RecipientAddress] {
+			return true
+		}
+	}
+	return false
+}
+
+func (node *Node) handleEvents(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writer.Header().Set("Allow", http.MethodGet)
+		writeAPIError(writer, apiErrorf(http.StatusMethodNotAllowed, "%v is not allowed on %v", request.Method, request.URL.Path))
+		return
+	}
+	filter, err := node.parseEventFilter(request)
+	if err != nil {
+		writeAPIError(writer, err)
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		writeAPIError(writer, apiErrorf(http.StatusInternalServerError, "the connection can't stream"))
+		return
+	}
+
+	// Subscribe before reading the balances, so that no change slips in between
+	events := node.events.Subscribe()
+	defer node.events.Unsubscribe(events)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	// The stream is read-only, so any page may follow it
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	writer.WriteHeader(http.StatusOK)
+
+	stream := eventStream{writer: writer, filter: filter, balances: make(map[Address]uint)}
+	for address := range filter.addresses {
+		balance := node.queryBalance(address)
+		stream.balances[address] = balance.Balance
+		stream.send(BalanceEvent, APIBalanceChange{APIBalance: balance, Previous: balance.Balance})
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case <-keepAlive.C:
+			fmt.Fprint(writer, ": keep-alive\n\n")
+
+		case event, ok := <-events:
+			if !ok {
+				// We fell too far behind, so the client has to connect again
+				return
+			}
+			node.streamEvent(&stream, event)
+		}
+
+		if stream.err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+type eventStream struct {
+	writer   http.ResponseWriter
+	filter   eventFilter
+	balances map[Address]uint // the last balance sent for each watched address
+	id       uint64
+	err      error
+}
+
+func (stream *eventStream) send(eventType EventType, data any) {
+	if !stream.filter.types[eventType] || stream.err != nil {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		stream.err = err
+		return
+	}
+	stream.id++
+	_, stream.err = fmt.Fprintf(stream.writer, "event: %v\nid: %d\ndata: %s\n\n", eventType, stream.id, encoded)
+}
+
+func (node *Node) streamEvent(stream *eventStream, event Event) {
+	switch data := event.Data.(type) {
+	case HashedBlock:
+		concerned := false
+		for _, transaction := range data.Transactions {
+			concerned = concerned || stream.filter.concerns(transaction)
+		}
+		if concerned {
+			stream.send(BlockEvent, node.apiBlockSummary(data))
+		}
+		node.streamBalances(stream)
+
+	case SignedTransaction:
+		if stream.filter.concerns(data) {
+			stream.send(TransactionEvent, node.apiTransaction(data, nil, node.tipIndex()))
+		}
+
+	case Reorg:
+		stream.send(ReorgEvent, APIReorg{
+			ForkIndex:    data.ForkIndex,
+			OldTip:       hex.EncodeToString(data.OldTip[:]),
+			NewTip:       hex.EncodeToString(data.NewTip[:]),
+			Disconnected: data.Disconnected,
+			Connected:    data.Connected,
+		})
+
+	case PeerChange:
+		nodeData, _ := node.getNodeData(data.ID)
+		peer := APIPeer{ID: data.ID, Address: nodeData.Address, State: "disconnected"}
+		if data.Connected {
+			peer.State = "connected"
+		}
+		if !nodeData.PublicKey.IsZero() {
+			walletAddress := addressOf(nodeData.PublicKey)
+			peer.WalletAddress = &walletAddress
+		}
+		stream.send(PeerEvent, peer)
+	}
+}
+
+// Tell about the watched addresses whose balance isn't the one we last sent
+func (node *Node) streamBalances(stream *eventStream) {
+	for address, previous := range stream.balances {
+		balance := node.queryBalance(address)
+		if balance.Balance != previous {
+			stream.balances[address] = balance.Balance
+			stream.send(BalanceEvent, APIBalanceChange{APIBalance: balance, Previous: previous})
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "sync"
+
+/*
+The node publishes an event whenever its state changes in a way clients may want to hear about
+right away, instead of polling the API for it:
+
+	block        a block joined the main chain
+	transaction  a transaction entered the mempool
+	reorg        the main chain switched to another branch, before its blocks are connected
+	peer         a connection to a peer was opened or closed
+
+Events are published with the locks of the change held, so Publish never blocks: a subscriber
+that falls too far behind has its channel closed and has to subscribe again. The events carry
+the node's own types, and subscribers turn them into what they send out (see event-stream.go).
+*/
+
+type EventType string
+
+const (
+	BlockEvent       EventType = "block"
+	TransactionEvent EventType = "transaction"
+	ReorgEvent       EventType = "reorg"
+	PeerEvent        EventType = "peer"
+	BalanceEvent     EventType = "balance" // made by the event stream from the block events
+)
+
+const eventBufferSize = 256
+
+type Event struct {
+	Type EventType
+	Data any // HashedBlock, SignedTransaction, Reorg or PeerChange, by Type
+}
+
+type Reorg struct {
+	ForkIndex    uint // the Index of the last block both branches share
+	OldTip       [32]byte
+	NewTip       [32]byte
+	Disconnected int
+	Connected    int
+}
+
+type PeerChange struct {
+	ID        string
+	Connected bool
+}
+
+type EventHub struct {
+	lock        sync.Mutex
+	subscribers map[chan Event]bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[chan Event]bool)}
+}
+
+func (hub *EventHub) Subscribe() chan Event {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	events := make(chan Event, eventBufferSize)
+	hub.subscribers[events] = true
+	return events
+}
+
+func (hub *EventHub) Unsubscribe(events chan Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	if hub.subscribers[events] {
+		delete(hub.subscribers, events)
+		close(events)
+	}
+}
+
+func (hub *EventHub) Publish(event Event) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	for events := range hub.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(hub.subscribers, events)
+			close(events)
+		}
+	}
+}
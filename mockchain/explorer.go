@@ -0,0 +1,32 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+/*
+The block explorer is a page served at / next to the REST API, made of the files in explorer/,
+which are built into the binary. It only talks to the node through /api/v1/, so it shows what
+any client of the API can see:
+
+	#/                          the latest blocks, kept up to date with the event stream
+	#/blocks/<index|hash>       a block and its transactions
+	#/transactions/<id>         a transaction, on the main chain or in the mempool
+	#/addresses/<address|id>    the balance and the unspent outputs of an address
+
+The search box takes a block index, a block hash, a transaction id, an address or a node id.
+*/
+
+//go:embed explorer
+var explorerFiles embed.FS
+
+func explorerHandler() http.Handler {
+	files, err := fs.Sub(explorerFiles, "explorer")
+	if err != nil {
+		// The directory is embedded above, so it is always there
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,272 @@
+'use strict';
+
+// The explorer is a page of the node, so it talks to the API of the node that serves it
+const api = '/api/v1/';
+const blocksPerPage = 20;
+
+const content = document.getElementById('content');
+const status = document.getElementById('status');
+
+// The pages, by the first segment of the path after the #
+const pages = {
+  '': showBlocks,
+  'blocks': showBlock,
+  'transactions': showTransaction,
+  'addresses': showAddress,
+  'search': search,
+};
+
+async function get(path) {
+  const response = await fetch(api + path);
+  const body = await response.json();
+  if (!response.ok) {
+    const error = new Error(body.error ? body.error.message : response.statusText);
+    error.status = response.status;
+    throw error;
+  }
+  return body;
+}
+
+function escape(text) {
+  return String(text)
+    .replace(/&/g, '&amp;')
+    .replace(/</g, '&lt;')
+    .replace(/>/g, '&gt;')
+    .replace(/"/g, '&quot;');
+}
+
+function link(path, text) {
+  return `<a href="#/${path}">${escape(text)}</a>`;
+}
+
+function hash(text) {
+  return `<span class="hash">${escape(text)}</span>`;
+}
+
+function time(milliseconds) {
+  return escape(new Date(milliseconds).toLocaleString());
+}
+
+// An address, named after its node if it has one
+function address(address, id) {
+  return link('addresses/' + encodeURIComponent(address), id || address);
+}
+
+// A table of label and value rows, with the values already escaped
+function fields(rows) {
+  return '<table class="fields">' +
+    rows.map(([label, value]) => `<tr><th>${escape(label)}</th><td>${value}</td></tr>`).join('') +
+    '</table>';
+}
+
+function showError(error) {
+  content.innerHTML = `<p class="error">${escape(error.message)}</p>`;
+}
+
+async function showStatus() {
+  try {
+    const node = await get('status');
+    status.textContent = `Node ${node.id}, ${node.height + 1} blocks, ` +
+      `${node.mempoolSize} pending transactions, ${node.peers} connected peers`;
+  } catch (error) {
+    status.textContent = error.message;
+  }
+}
+
+async function showBlocks(params) {
+  const query = params.get('from') !== null ? `&from=${encodeURIComponent(params.get('from'))}` : '';
+  const blocks = await get(`blocks?limit=${blocksPerPage}${query}`);
+
+  let rows = '';
+  for (const block of blocks) {
+    rows += `<tr>
+      <td>${link('blocks/' + block.index, block.index)}</td>
+      <td>${link('blocks/' + block.hash, block.hash.slice(0, 16) + '…')}</td>
+      <td>${hash(block.nonce)}</td>
+      <td>${time(block.timestamp)}</td>
+      <td>${block.miner ? address(block.miner) : 'genesis'}</td>
+      <td>${block.transactionCount}</td>
+    </tr>`;
+  }
+
+  let pager = '';
+  if (params.get('from') !== null) {
+    pager += link('', 'Latest');
+  }
+  if (blocks.length > 0 && blocks[blocks.length - 1].index > 0) {
+    pager += link(`?from=${blocks[blocks.length - 1].index - 1}`, 'Older');
+  }
+
+  content.innerHTML = `<h2>Blocks</h2>
+    <table>
+      <tr><th>Index</th><th>Hash</th><th>Nonce</th><th>Time</th><th>Miner</th><th>Transactions</th></tr>
+      ${rows}
+    </table>
+    <div class="pager">${pager}</div>`;
+}
+
+async function showBlock(params, block) {
+  block = await get('blocks/' + encodeURIComponent(block));
+
+  let rows = '';
+  for (const transaction of block.transactions) {
+    const paid = transaction.outputs.map((output) => `${address(output.address, output.owner)}: ${output.amount}`);
+    rows += `<tr>
+      <td>${link('transactions/' + transaction.id, transaction.id.slice(0, 16) + '…')}</td>
+      <td>${transaction.coinbase ? 'coinbase' : address(transaction.sender, transaction.senderId)}</td>
+      <td>${paid.join('<br>')}</td>
+      <td>${transaction.fee}</td>
+    </tr>`;
+  }
+
+  const previous = block.index > 0 ? link('blocks/' + (block.index - 1), 'Previous block') : '';
+  content.innerHTML = `<h2>Block ${block.index}</h2>
+    ${fields([
+      ['Hash', hash(block.hash)],
+      ['Previous hash', block.index > 0 ? link('blocks/' + block.previousHash, block.previousHash) : hash(block.previousHash)],
+      ['Merkle root', hash(block.merkleRoot)],
+      ['Nonce', hash(block.nonce)],
+      ['Target', hash(block.target)],
+      ['Time', time(block.timestamp)],
+      ['Miner', block.miner ? address(block.miner) : 'genesis'],
+    ])}
+    <h3>${block.transactionCount} transactions</h3>
+    <table>
+      <tr><th>Id</th><th>From</th><th>To</th><th>Fee</th></tr>
+      ${rows}
+    </table>
+    <div class="pager">${previous}</div>`;
+}
+
+async function showTransaction(params, id) {
+  const transaction = await get('transactions/' + encodeURIComponent(id));
+
+  let inputs = '';
+  for (const input of transaction.inputs) {
+    inputs += `<tr><td>${hash(input.previousOutputId)}</td><td>${hash(input.unlockingScript || '')}</td></tr>`;
+  }
+  let outputs = '';
+  for (const output of transaction.outputs) {
+    outputs += `<tr>
+      <td>${hash(output.id)}</td>
+      <td>${address(output.address, output.owner)}</td>
+      <td>${output.amount}</td>
+      <td>${hash(output.lockingScript || '')}</td>
+    </tr>`;
+  }
+
+  const confirmed = transaction.status === 'confirmed'
+    ? `in block ${link('blocks/' + transaction.blockIndex, transaction.blockIndex)}, ${transaction.confirmations} confirmations`
+    : '<span class="pending">pending, in the mempool</span>';
+  content.innerHTML = `<h2>Transaction</h2>
+    ${fields([
+      ['Id', hash(transaction.id)],
+      ['Status', confirmed],
+      ['Sender', transaction.coinbase ? 'coinbase' : address(transaction.sender, transaction.senderId)],
+      ['Fee', transaction.fee],
+    ])}
+    <h3>Inputs</h3>
+    <table>
+      <tr><th>Output spent</th><th>Unlocking script</th></tr>
+      ${inputs}
+    </table>
+    <h3>Outputs</h3>
+    <table>
+      <tr><th>Id</th><th>Address</th><th>Amount</th><th>Locking script</th></tr>
+      ${outputs}
+    </table>`;
+}
+
+async function showAddress(params, name) {
+  const [balance, outputs] = await Promise.all([
+    get('addresses/' + encodeURIComponent(name)),
+    get('addresses/' + encodeURIComponent(name) + '/utxos'),
+  ]);
+
+  let rows = '';
+  for (const output of outputs) {
+    rows += `<tr>
+      <td>${link('transactions/' + output.transactionId, output.transactionId.slice(0, 16) + '…')}</td>
+      <td>${hash(output.id)}</td>
+      <td>${output.amount}</td>
+      <td>${hash(output.lockingScript || '')}</td>
+    </tr>`;
+  }
+
+  content.innerHTML = `<h2>Address</h2>
+    ${fields([
+      ['Address', hash(balance.address)],
+      ['Node', escape(balance.id || '')],
+      ['Balance', balance.balance],
+    ])}
+    <h3>${balance.outputs} unspent outputs</h3>
+    <table>
+      <tr><th>Transaction</th><th>Output</th><th>Amount</th><th>Locking script</th></tr>
+      ${rows}
+    </table>`;
+}
+
+// A 64 digit hex string is a transaction id or a block hash, a number a block index, and
+// anything else an address or a node id
+async function search(params, query) {
+  query = query.trim();
+  if (/^[0-9]+$/.test(query)) {
+    location.replace('#/blocks/' + query);
+    return;
+  }
+  if (!/^[0-9a-fA-F]{64}$/.test(query)) {
+    location.replace('#/addresses/' + encodeURIComponent(query));
+    return;
+  }
+
+  for (const kind of ['transactions', 'blocks']) {
+    try {
+      await get(kind + '/' + query);
+      location.replace(`#/${kind}/${query.toLowerCase()}`);
+      return;
+    } catch (error) {
+      if (error.status !== 404) {
+        throw error;
+      }
+    }
+  }
+  throw new Error(`no transaction or block has the hash ${query}`);
+}
+
+async function route() {
+  const [path, query] = location.hash.replace(/^#\/?/, '').split('?');
+  const segments = path.split('/');
+  const page = pages[segments[0]];
+
+  try {
+    if (!page) {
+      throw new Error(`no page ${location.hash}`);
+    }
+    await page(new URLSearchParams(query), ...segments.slice(1).map(decodeURIComponent));
+  } catch (error) {
+    showError(error);
+  }
+}
+
+document.getElementById('search').addEventListener('submit', (event) => {
+  event.preventDefault();
+  const query = document.getElementById('query').value.trim();
+  if (query !== '') {
+    location.hash = '#/search/' + encodeURIComponent(query);
+  }
+});
+
+// Keep the status and the latest blocks up to date as the node hears of new ones
+const events = new EventSource(api + 'events?type=block,reorg,transaction');
+for (const type of ['block', 'reorg', 'transaction']) {
+  events.addEventListener(type, () => {
+    showStatus();
+    if (type !== 'transaction' && location.hash.replace(/^#\/?/, '') === '') {
+      route();
+    }
+  });
+}
+
+window.addEventListener('hashchange', route);
+showStatus();
+route();
@@ -1,3 +1,11 @@
 module noobcash
 
 go 1.20
+
+require (
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.3.0
+	golang.org/x/crypto v0.33.0
+	golang.org/x/term v0.29.0
+)
+
+require golang.org/x/sys v0.30.0 // indirect
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"noobcash/merkle"
+	"noobcash/signature"
+)
+
+/*
+Hashes of transactions and blocks are taken over a canonical binary serialization, so that they
+don't depend on the JSON encoder or on the order of the fields in our structs. Any other
+implementation can check them by following these rules. All integers are big-endian.
+
+	u8, u32, u64 1, 4 and 8 byte unsigned integers (timestamps are written as their two's complement)
+	hash         32 raw bytes
+	address      u8 type, then 20 raw bytes of hash (see address.go)
+	publicKey    u8 signature scheme, u32 length of the key, the key (see the signature package)
+	policy       u32 Required, u32 number of keys, then each publicKey (see multisig.go)
+
+Transaction body:
+
+	publicKey    SenderAddress, the zero key with no scheme and no bytes for multisig spends
+	u8           1 if the transaction spends from a multisig, 0 otherwise, then if 1:
+	policy         Multisig
+	u64          Fee
+	u32          number of inputs, then for each input:
+	hash           PreviousOutputID
+	u32          number of outputs, then for each output:
+	address        RecipientAddress
+	u64            Amount
+	u32            length of the LockingScript, then the script
+
+	TransactionID = SHA-256(transaction body)
+	output ID     = SHA-256(TransactionID || u32 index of the output)
+
+The signature of a transaction is a signature of its TransactionID (which is already a SHA-256
+digest) with the scheme of the sender's key, tagged with that scheme. The cosignatures of a
+multisig spend are signatures of the TransactionID in the same way. Output IDs and the
+TransactionID field of the outputs are derived from the body, so they are not part of it. Neither
+are the unlocking scripts of the inputs, since the signatures they carry sign the TransactionID.
+
+Witness of a transaction, the signatures and unlocking scripts that its body leaves out:
+
+	hash         TransactionID
+	u32          length of the Signature, then the signature
+	u32          number of cosignatures, then for each cosignature:
+	u32            KeyIndex
+	u32            length of the signature, then the signature
+	u32          number of inputs, then for each input:
+	u32            length of the UnlockingScript, then the script
+
+	witness hash  = SHA-256(witness)
+
+Block header:
+
+	u64          Index
+	u64          Timestamp, in milliseconds
+	hash         PreviousHash
+	hash         MerkleRoot, of the TransactionIDs of the block's transactions (see the merkle package)
+	hash         WitnessRoot, of the witness hashes of the block's transactions, in the same kind of tree
+	hash         Nonce
+	hash         Target
+
+	block hash    = SHA-256(block header)
+
+Without the WitnessRoot, whoever passes a block on could swap a signature or an unlocking script
+for another one that is just as valid, and the changed block would still have the same hash.
+*/
+
+func appendUint32(buffer []byte, x uint32) []byte {
+	return binary.BigEndian.AppendUint32(buffer, x)
+}
+
+func appendUint64(buffer []byte, x uint64) []byte {
+	return binary.BigEndian.AppendUint64(buffer, x)
+}
+
+func appendPublicKey(buffer []byte, publicKey signature.PublicKey) []byte {
+	buffer = append(buffer, byte(publicKey.Scheme))
+	buffer = appendUint32(buffer, uint32(len(publicKey.Key)))
+	return append(buffer, publicKey.Key...)
+}
+
+func appendAddress(buffer []byte, address Address) []byte {
+	buffer = append(buffer, byte(address.Type))
+	return append(buffer, address.Hash[:]...)
+}
+
+func appendMultisigPolicy(buffer []byte, policy MultisigPolicy) []byte {
+	buffer = appendUint32(buffer, policy.Required)
+	buffer = appendUint32(buffer, uint32(len(policy.PublicKeys)))
+	for _, publicKey := range policy.PublicKeys {
+		buffer = appendPublicKey(buffer, publicKey)
+	}
+	return buffer
+}
+
+func (transaction *Transaction) canonicalBody() []byte {
+	buffer := make([]byte, 0, 1024)
+
+	buffer = appendPublicKey(buffer, transaction.SenderAddress)
+	if transaction.Multisig != nil {
+		buffer = append(buffer, 1)
+		buffer = appendMultisigPolicy(buffer, *transaction.Multisig)
+	} else {
+		buffer = append(buffer, 0)
+	}
+	buffer = appendUint64(buffer, uint64(transaction.Fee))
+
+	buffer = appendUint32(buffer, uint32(len(transaction.TransactionInputs)))
+	for _, transactionInput := range transaction.TransactionInputs {
+		buffer = append(buffer, transactionInput.PreviousOutputID[:]...)
+	}
+
+	buffer = appendUint32(buffer, uint32(len(transaction.TransactionOutputs)))
+	for _, transactionOutput := range transaction.TransactionOutputs {
+		buffer = appendAddress(buffer, transactionOutput.RecipientAddress)
+		buffer = appendUint64(buffer, uint64(transactionOutput.Amount))
+		buffer = appendUint32(buffer, uint32(len(transactionOutput.LockingScript)))
+		buffer = append(buffer, transactionOutput.LockingScript...)
+	}
+
+	return buffer
+}
+
+func (transaction *Transaction) hash() [32]byte {
+	return sha256.Sum256(transaction.canonicalBody())
+}
+
+func outputID(transactionID [32]byte, index int) [32]byte {
+	buffer := make([]byte, 0, 32+4)
+	buffer = append(buffer, transactionID[:]...)
+	buffer = appendUint32(buffer, uint32(index))
+	return sha256.Sum256(buffer)
+}
+
+// Fill in the TransactionID and the output IDs, which are all derived from the transaction body
+func (transaction *Transaction) setIDs() {
+	transaction.TransactionID = transaction.hash()
+
+	for index := range transaction.TransactionOutputs {
+		transaction.TransactionOutputs[index].ID = outputID(transaction.TransactionID, index)
+		transaction.TransactionOutputs[index].TransactionID = transaction.TransactionID
+	}
+}
+
+// Check that the ids carried by the transaction are the ones derived from its body
+func (transaction *Transaction) idsValid() bool {
+	if transaction.TransactionID != transaction.hash() {
+		return false
+	}
+
+	for index, transactionOutput := range transaction.TransactionOutputs {
+		if transactionOutput.ID != outputID(transaction.TransactionID, index) || transactionOutput.TransactionID != transaction.TransactionID {
+			return false
+		}
+	}
+	return true
+}
+
+func (signedTransaction *SignedTransaction) unsigned() Transaction {
+	return Transaction{
+		SenderAddress:      signedTransaction.SenderAddress,
+		Multisig:           signedTransaction.Multisig,
+		Fee:                signedTransaction.Fee,
+		TransactionID:      signedTransaction.TransactionID,
+		TransactionInputs:  signedTransaction.TransactionInputs,
+		TransactionOutputs: signedTransaction.TransactionOutputs,
+	}
+}
+
+func transactionIDs(transactions []SignedTransaction) [][32]byte {
+	ids := make([][32]byte, len(transactions))
+	for i, transaction := range transactions {
+		ids[i] = transaction.TransactionID
+	}
+	return ids
+}
+
+func merkleRoot(transactions []SignedTransaction) [32]byte {
+	return merkle.Root(transactionIDs(transactions))
+}
+
+func (signedTransaction *SignedTransaction) witnessHash() [32]byte {
+	buffer := make([]byte, 0, 256)
+
+	buffer = append(buffer, signedTransaction.TransactionID[:]...)
+	buffer = appendUint32(buffer, uint32(len(signedTransaction.Signature)))
+	buffer = append(buffer, signedTransaction.Signature...)
+
+	buffer = appendUint32(buffer, uint32(len(signedTransaction.Cosignatures)))
+	for _, cosignature := range signedTransaction.Cosignatures {
+		buffer = appendUint32(buffer, cosignature.KeyIndex)
+		buffer = appendUint32(buffer, uint32(len(cosignature.Signature)))
+		buffer = append(buffer, cosignature.Signature...)
+	}
+
+	buffer = appendUint32(buffer, uint32(len(signedTransaction.TransactionInputs)))
+	for _, transactionInput := range signedTransaction.TransactionInputs {
+		buffer = appendUint32(buffer, uint32(len(transactionInput.UnlockingScript)))
+		buffer = append(buffer, transactionInput.UnlockingScript...)
+	}
+
+	return sha256.Sum256(buffer)
+}
+
+func witnessRoot(transactions []SignedTransaction) [32]byte {
+	hashes := make([][32]byte, len(transactions))
+	for i, transaction := range transactions {
+		hashes[i] = transaction.witnessHash()
+	}
+	return merkle.Root(hashes)
+}
+
+func (header *BlockHeader) hash() [32]byte {
+	buffer := make([]byte, 0, 8+8+32+32+32+32+32)
+
+	buffer = appendUint64(buffer, uint64(header.Index))
+	buffer = appendUint64(buffer, uint64(header.Timestamp))
+	buffer = append(buffer, header.PreviousHash[:]...)
+	buffer = append(buffer, header.MerkleRoot[:]...)
+	buffer = append(buffer, header.WitnessRoot[:]...)
+	buffer = append(buffer, header.Nonce[:]...)
+	buffer = append(buffer, header.Target[:]...)
+
+	return sha256.Sum256(buffer)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+/*
+Changing a signature, a cosignature or an unlocking script leaves the TransactionIDs, and so the
+Merkle root, as they were. The witness root, and with it the block hash, has to change.
+*/
+func TestWitnessRootCommitsToSignatures(t *testing.T) {
+	block := testBlock(t, 3)
+	block.MerkleRoot = merkleRoot(block.Transactions)
+	block.WitnessRoot = witnessRoot(block.Transactions)
+	block.Hash = block.BlockHeader.hash()
+
+	flip := func(data []byte) []byte {
+		changed := bytes.Clone(data)
+		changed[len(changed)-1] ^= 1
+		return changed
+	}
+	mutations := map[string]func(transactions []SignedTransaction){
+		"signature": func(transactions []SignedTransaction) {
+			transactions[1].Signature = flip(transactions[1].Signature)
+		},
+		"unlocking script": func(transactions []SignedTransaction) {
+			transactionInputs := append([]TransactionInput{}, transactions[2].TransactionInputs...)
+			transactionInputs[0].UnlockingScript = flip(transactionInputs[0].UnlockingScript)
+			transactions[2].TransactionInputs = transactionInputs
+		},
+		"cosignature": func(transactions []SignedTransaction) {
+			cosignatures := append([]Cosignature{}, transactions[3].Cosignatures...)
+			cosignatures[1].Signature = flip(cosignatures[1].Signature)
+			transactions[3].Cosignatures = cosignatures
+		},
+	}
+
+	for name, mutate := range mutations {
+		transactions := append([]SignedTransaction{}, block.Transactions...)
+		mutate(transactions)
+
+		if merkleRoot(transactions) != block.MerkleRoot {
+			t.Errorf("changing a %v changed the Merkle root", name)
+		}
+		if witnessRoot(transactions) == block.WitnessRoot {
+			t.Errorf("changing a %v didn't change the witness root", name)
+		}
+	}
+}
+
+// A relayed block with another signature doesn't pass for the mined one, which is still taken after it
+func TestMalleatedBlockRejected(t *testing.T) {
+	_, node := testBootstrapNode(t)
+	other := testJoinedNode(t, node)
+
+	block := node.mineBlock([]SignedTransaction{testPayment(t, node, other.walletAddress, 50)}, 1, node.blockchain[0].Hash)
+
+	malleated := block
+	malleated.Transactions = append([]SignedTransaction{}, block.Transactions...)
+	malleated.Transactions[1].Signature = append(bytes.Clone(block.Transactions[1].Signature), 0)
+
+	other.blockchainLock.Lock()
+	valid := other.validateBlock(malleated)
+	other.blockchainLock.Unlock()
+	if valid {
+		t.Fatal("the malleated block was accepted")
+	}
+	acceptBlock(t, other, block)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+/*
+Every node pings its peers every heartbeatInterval. Any frame counts as a sign of life, so a
+busy peer doesn't need to answer the pings in time. A peer we haven't heard from in peerTimeout
+is dead: its connection gets closed, which removes it from connectionMap, and the side that
+opened the connection starts dialing again.
+*/
+const heartbeatInterval = 2 * time.Second
+const peerTimeout = 5 * heartbeatInterval
+
+func (node *Node) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if node.leaving.Load() {
+			return
+		}
+
+		for _, peer := range node.peers() {
+			if time.Since(peer.getLastSeen()) > peerTimeout {
+				log.Println("heartbeat:", peer.getID(), "hasn't answered in", peerTimeout, "- closing the connection")
+				peer.close()
+				continue
+			}
+
+			node.sendPingMessage(peer)
+		}
+	}
+}
+
+func (node *Node) sendPingMessage(peer *Peer) {
+	sendMessage(peer, PingMessageType, PingMessage{Time: time.Now().UnixNano()})
+}
+
+func (node *Node) receivePingMessage(message Message, peer *Peer) error {
+	var pingMessage PingMessage
+	err := message.decode(&pingMessage)
+	if err != nil {
+		return err
+	}
+
+	sendMessage(peer, PongMessageType, PongMessage{Time: pingMessage.Time})
+	return nil
+}
+
+func (node *Node) receivePongMessage(message Message, peer *Peer) error {
+	var pongMessage PongMessage
+	err := message.decode(&pongMessage)
+	if err != nil {
+		return err
+	}
+
+	rtt := time.Since(time.Unix(0, pongMessage.Time))
+	if rtt >= 0 {
+		peer.rtt.Store(int64(rtt))
+	}
+	return nil
+}
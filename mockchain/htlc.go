@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"noobcash/script"
+)
+
+/*
+Hash time-locked contracts (see script.HashTimeLock) let two nodes swap coins across two separate
+networks without trusting each other. Each of them has a node on both networks:
+
+	htlc open <id> <amount> <timeout> new      A locks coins for B on the first network, with a new secret
+	htlc open <id> <amount> <timeout> <hash>   B locks coins for A on the second network, with the same hash
+	htlc claim <outputID> <preimage>           A claims the coins of B, which reveals the secret
+	htlc preimage <hash>                       B reads the secret off the second network
+	htlc claim <outputID> <preimage>           and claims the coins of A with it
+
+If either of them backs out, the other gets the coins back with htlc refund once the timeout has
+passed, and from then on the coins can't be claimed any more. A has to give B's contract an
+earlier timeout than their own, so that B has time to claim after the secret is out.
+
+The timeout is the Index of the first block that the refund can go in, and the first block that
+the claim can't. Timeouts written as +n
+are n blocks after the tip of the chain.
+*/
+
+func (node *Node) htlcCommand(fields []string) {
+	var err error
+
+	switch {
+	case len(fields) >= 6 && len(fields) <= 7 && fields[1] == "open":
+		err = node.openHTLC(fields[2], fields[3], fields[4], fields[5], fields[6:])
+
+	case len(fields) == 2 && fields[1] == "list":
+		node.listHTLCs()
+
+	case len(fields) >= 4 && len(fields) <= 5 && fields[1] == "claim":
+		err = node.claimHTLC(fields[2], fields[3], fields[4:])
+
+	case len(fields) >= 3 && len(fields) <= 4 && fields[1] == "refund":
+		err = node.refundHTLC(fields[2], fields[3:])
+
+	case len(fields) == 3 && fields[1] == "preimage":
+		err = node.findPreimage(fields[2])
+
+	default:
+		node.help()
+	}
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func parseHexHash(encoded string) ([32]byte, error) {
+	var hash [32]byte
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil || len(decoded) != len(hash) {
+		return hash, fmt.Errorf("%v must be 64 hex digits", encoded)
+	}
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// The optional fee at the end of a command, 0 if it's missing
+func parseOptionalFee(fields []string) (uint, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	fee, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return 0, errors.New("Fee must be a number")
+	}
+	return uint(fee), nil
+}
+
+func (node *Node) parseTimeout(timeout string) (uint64, error) {
+	relative := strings.HasPrefix(timeout, "+")
+	parsed, err := strconv.ParseUint(strings.TrimPrefix(timeout, "+"), 10, 32)
+	if err != nil {
+		return 0, errors.New("the timeout must be a block index, or +<blocks> after the tip")
+	}
+	if relative {
+		node.blockchainLock.Lock()
+		parsed += uint64(len(node.blockchain) - 1)
+		node.blockchainLock.Unlock()
+	}
+	return parsed, nil
+}
+
+func (node *Node) openHTLC(receiver string, amountField string, timeoutField string, hashField string, feeFields []string) error {
+	receiverData, ok := node.getNodeData(receiver)
+	if !ok || receiverData.PublicKey.IsZero() {
+		return fmt.Errorf("No client with id %v", receiver)
+	}
+	amount, err := strconv.ParseUint(amountField, 10, 32)
+	if err != nil {
+		return errors.New("Amount must be a number")
+	}
+	timeout, err := node.parseTimeout(timeoutField)
+	if err != nil {
+		return err
+	}
+	fee, err := parseOptionalFee(feeFields)
+	if err != nil {
+		return err
+	}
+
+	htlc := script.HashTimeLock{Receiver: receiverData.PublicKey, Sender: node.publicKey, Timeout: timeout}
+	if hashField == "new" {
+		preimage := generateRandom32Byte()
+		htlc.Hash = sha256.Sum256(preimage[:])
+		fmt.Printf("\nSecret preimage: %x\nKeep it to yourself until the other side has opened their contract with the hash\n", preimage)
+	} else if htlc.Hash, err = parseHexHash(hashField); err != nil {
+		return err
+	}
+
+	lockingScript := htlc.Script()
+	address := scriptAddress(lockingScript)
+	if !node.sendFunds([]Payment{{Address: address, Amount: uint(amount), LockingScript: lockingScript}}, fee) {
+		return errors.New("openHTLC: the transaction could not be made")
+	}
+
+	fmt.Printf("\nContract opened for %v at %v\nHash: %x\nRefundable from block %v\n", receiver, address, htlc.Hash, htlc.Timeout)
+	return nil
+}
+
+// The committed contracts that pay us, or that we can take back
+func (node *Node) listHTLCs() {
+	node.blockchainLock.Lock()
+	transactionOutputs := node.UTXOsCommitted.ScriptOutputs()
+	nextIndex := uint64(len(node.blockchain))
+	node.blockchainLock.Unlock()
+
+	sort.Slice(transactionOutputs, func(i, j int) bool {
+		return bytes.Compare(transactionOutputs[i].ID[:], transactionOutputs[j].ID[:]) < 0
+	})
+
+	fmt.Println("\nContracts:")
+	for _, transactionOutput := range transactionOutputs {
+		htlc, ok := script.ParseHashTimeLock(transactionOutput.LockingScript)
+		if !ok {
+			continue
+		}
+
+		var role string
+		switch {
+		case htlc.Receiver.Equal(node.publicKey):
+			role = "from " + node.getId(htlc.Sender)
+		case htlc.Sender.Equal(node.publicKey):
+			role = "to " + node.getId(htlc.Receiver)
+		default:
+			continue
+		}
+
+		state := "claimable"
+		if nextIndex >= htlc.Timeout {
+			state = "refundable"
+		}
+		fmt.Printf("%x %v %v hash %x timeout %v %v\n", transactionOutput.ID, transactionOutput.Amount, role, htlc.Hash, htlc.Timeout, state)
+	}
+}
+
+func (node *Node) claimHTLC(outputField string, preimageField string, feeFields []string) error {
+	outputID, err := parseHexHash(outputField)
+	if err != nil {
+		return err
+	}
+	preimage, err := hex.DecodeString(preimageField)
+	if err != nil {
+		return errors.New("the preimage must be in hex")
+	}
+	fee, err := parseOptionalFee(feeFields)
+	if err != nil {
+		return err
+	}
+
+	return node.spendScriptOutput(outputID, fee, func(spentOutput TransactionOutput, spenderSignature []byte) ([]byte, error) {
+		htlc, ok := script.ParseHashTimeLock(spentOutput.LockingScript)
+		if !ok || !htlc.Receiver.Equal(node.publicKey) {
+			return nil, errors.New("claimHTLC: the output is not a contract that pays this client")
+		}
+		if sha256.Sum256(preimage) != htlc.Hash {
+			return nil, errors.New("claimHTLC: that is not the preimage of the hash of the contract")
+		}
+		return script.ClaimHashTimeLock(spenderSignature, preimage), nil
+	})
+}
+
+func (node *Node) refundHTLC(outputField string, feeFields []string) error {
+	outputID, err := parseHexHash(outputField)
+	if err != nil {
+		return err
+	}
+	fee, err := parseOptionalFee(feeFields)
+	if err != nil {
+		return err
+	}
+
+	return node.spendScriptOutput(outputID, fee, func(spentOutput TransactionOutput, spenderSignature []byte) ([]byte, error) {
+		htlc, ok := script.ParseHashTimeLock(spentOutput.LockingScript)
+		if !ok || !htlc.Sender.Equal(node.publicKey) {
+			return nil, errors.New("refundHTLC: the output is not a contract this client opened")
+		}
+		return script.RefundHashTimeLock(spenderSignature), nil
+	})
+}
+
+// Look for a claim that revealed a preimage of the hash, on the chain or in the mempool
+func (node *Node) findPreimage(hashField string) error {
+	hash, err := parseHexHash(hashField)
+	if err != nil {
+		return err
+	}
+
+	for _, transaction := range node.transactionHistory() {
+		for _, transactionInput := range transaction.TransactionInputs {
+			preimage, ok := script.HashTimeLockPreimage(transactionInput.UnlockingScript)
+			if ok && sha256.Sum256(preimage) == hash {
+				fmt.Printf("\nPreimage: %x\nRevealed by transaction %x\n", preimage, transaction.TransactionID)
+				return nil
+			}
+		}
+	}
+	return errors.New("the preimage hasn't been revealed yet")
+}
@@ -1,57 +1,96 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	mathrand "math/rand"
+	"strings"
 	"time"
+
+	"noobcash/merkle"
 )
 
+// The coins of the node with the given id in the committed UTXOs, which the chain changes under blockchainLock
 func (node *Node) walletBalance(id string) uint {
-	neighbor, ok := node.nodeDataMap[id]
-	if !ok {
+	neighbor, ok := node.getNodeData(id)
+	if !ok || neighbor.PublicKey.IsZero() {
 		return 0
 	}
 
-	address := neighbor.PublicKey
+	node.blockchainLock.Lock()
+	defer node.blockchainLock.Unlock()
 
-	var amount uint = 0
-	for _, UTXO := range node.UTXOsCommitted {
-		if equal(address, UTXO.RecipientAddress) {
-			amount += UTXO.Amount
-		}
-	}
-	return amount
+	return node.UTXOsCommitted.Balance(addressOf(neighbor.PublicKey))
+}
+
+func (node *Node) sendFunds(payments []Payment, fee uint) bool {
+	return node.sendFundsWith(payments, fee, nil)
 }
 
-func (node *Node) sendFunds(id string, coins uint) bool {
-	time.Sleep(time.Millisecond*100 + time.Millisecond*time.Duration(mathrand.Intn(NUM_NODES))*200)
+// Send funds picking the coins with coinSelector, or with the wallet's coin selection if it is nil
+func (node *Node) sendFundsWith(payments []Payment, fee uint, coinSelector CoinSelector) bool {
+	time.Sleep(time.Millisecond*100 + time.Millisecond*time.Duration(mathrand.Intn(node.params.NumNodes))*200)
 
+	_, err := node.makeTransaction(payments, fee, coinSelector)
+	return err == nil
+}
+
+// Create, sign, admit and broadcast a transaction that makes the payments, with the reason if it couldn't be sent
+func (node *Node) makeTransaction(payments []Payment, fee uint, coinSelector CoinSelector) (SignedTransaction, error) {
 	node.mineLock.Lock()
+	defer node.mineLock.Unlock()
+
+	if coinSelector == nil {
+		coinSelector = node.coinSelector
+	}
 
-	transaction, err := node.createTransaction(id, coins)
+	transaction, err := node.createTransaction(payments, fee, coinSelector)
 	if err != nil {
-		node.mineLock.Unlock()
-		return false
+		return SignedTransaction{}, err
 	}
 
-	signedTransaction := node.signTransaction(transaction)
+	signedTransaction, err := node.signTransaction(transaction)
+	if err != nil {
+		return SignedTransaction{}, err
+	}
+
+	if rejectError := node.admitTransaction(signedTransaction); rejectError != nil {
+		log.Println("sendFunds: transaction rejected -", rejectError)
+		return SignedTransaction{}, rejectError
+	}
 
 	node.broadcastTransaction(signedTransaction)
 
-	node.transactionQueue.Push(signedTransaction)
+	return signedTransaction, nil
+}
 
-	node.mineLock.Unlock()
+/*
+All the transactions we know of in the order they happened: the ones on the main chain first,
+leaving out the genesis block and the coinbases, and then the ones still in the mempool.
+*/
+func (node *Node) transactionHistory() []SignedTransaction {
+	transactions := make([]SignedTransaction, 0)
+
+	node.blockchainLock.Lock()
+	for _, block := range node.blockchain {
+		if block.Index == 0 {
+			continue
+		}
+		for _, transaction := range block.Transactions {
+			if !isCoinbase(transaction) {
+				transactions = append(transactions, transaction)
+			}
+		}
+	}
+	node.blockchainLock.Unlock()
 
-	return true
+	return append(transactions, node.mempool.Transactions()...)
 }
 
 func (node *Node) viewAllTransactions() {
-	tempTransactions := NewTransactionQueue()
-	tempTransactions.Copy(&node.transactionQueue)
-	transaction, err := tempTransactions.Pop()
-	for err == nil {
-		fmt.Println("Transaction from", node.getId(transaction.SenderAddress), "to", node.getId(transaction.ReceiverAddress), "for", transaction.Amount)
-		transaction, err = tempTransactions.Pop()
+	for _, transaction := range node.transactionHistory() {
+		fmt.Println("Transaction from", node.addressName(transaction.senderAddress()), "to", node.recipientIDs(transaction), "for", transaction.amountPaid())
 	}
 }
 
@@ -61,19 +100,57 @@ func (node *Node) viewLastBlockTransactions() {
 
 	for index, transaction := range lastBlock.Transactions {
 		fmt.Println("Transaction", index)
-		fmt.Println("Amount:", transaction.Amount)
-
-		var sender, receiver string
-		for id, nghb := range node.nodeDataMap {
-			if equal(nghb.PublicKey, transaction.ReceiverAddress) {
-				receiver = id
-			} else if equal(nghb.PublicKey, transaction.SenderAddress) {
-				sender = id
-			}
+		fmt.Printf("ID: %x\n", transaction.TransactionID)
+		fmt.Println("Amount:", transaction.amountPaid())
+		fmt.Println("Fee:", transaction.Fee)
+
+		if isCoinbase(transaction) {
+			fmt.Println("From: coinbase")
+		} else {
+			fmt.Println("From:", node.addressName(transaction.senderAddress()))
+		}
+		for _, transactionOutput := range transaction.payments() {
+			fmt.Println("To:", node.addressName(transactionOutput.RecipientAddress), transactionOutput.Amount)
 		}
-
-		fmt.Println("From:", sender)
-		fmt.Println("To:", receiver)
 		fmt.Println("")
 	}
 }
+
+// The ids of the nodes a transaction pays, or the addresses that aren't a node's, separated by commas
+func (node *Node) recipientIDs(transaction SignedTransaction) string {
+	ids := make([]string, 0, len(transaction.TransactionOutputs))
+	for _, transactionOutput := range transaction.payments() {
+		ids = append(ids, node.addressName(transactionOutput.RecipientAddress))
+	}
+	return strings.Join(ids, ", ")
+}
+
+/*
+Find the block that contains the transaction and build the Merkle proof that it is part of it.
+A light client that has the header of the block can check the proof with verifyInclusion.
+*/
+func (node *Node) transactionProof(transactionID [32]byte) (BlockHeader, [32]byte, merkle.Proof, error) {
+	node.blockchainLock.Lock()
+	defer node.blockchainLock.Unlock()
+
+	for _, block := range node.blockchain {
+		for index, transaction := range block.Transactions {
+			if transaction.TransactionID != transactionID {
+				continue
+			}
+
+			proof, err := merkle.BuildProof(transactionIDs(block.Transactions), index)
+			if err != nil {
+				return BlockHeader{}, [32]byte{}, merkle.Proof{}, err
+			}
+			return block.BlockHeader, block.Hash, proof, nil
+		}
+	}
+
+	return BlockHeader{}, [32]byte{}, merkle.Proof{}, errors.New("transaction not found in the blockchain")
+}
+
+// Check that the header hashes to the block hash and that its Merkle root includes the transaction
+func verifyInclusion(header BlockHeader, blockHash [32]byte, transactionID [32]byte, proof merkle.Proof) bool {
+	return header.hash() == blockHash && merkle.Verify(transactionID, proof, header.MerkleRoot)
+}
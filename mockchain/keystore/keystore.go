@@ -0,0 +1,301 @@
+/*
+Package keystore keeps wallet keys on disk, encrypted with a passphrase.
+
+Every key lives in its own <name>.json file inside the keystore directory. The passphrase is
+stretched with scrypt into an AES-256 key, and the PEM encoding of the private key (see the
+signature package) is sealed with AES-GCM. The public key is stored in the clear so that keys
+can be listed without asking for the passphrase.
+
+Files without a Scheme were written before there were signature schemes. They hold an RSA key,
+with the PKCS#1 encoding of the private key sealed and the PKIX encoding of the public key in
+the clear, and they still load.
+*/
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"noobcash/signature"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const version = 1
+
+// scrypt cost parameters, as recommended for interactive logins
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+var ErrWrongPassphrase = errors.New("keystore: wrong passphrase")
+var ErrKeyExists = errors.New("keystore: a key with this name already exists")
+
+type scryptParams struct {
+	N    int
+	R    int
+	P    int
+	Salt []byte
+}
+
+// The on-disk format of a key file
+type keyFile struct {
+	Version    int
+	Name       string
+	Scheme     string `json:",omitempty"` // the signature scheme of the key
+	PublicKey  []byte // the Key of the signature.PublicKey, PKIX encoding in files without a Scheme
+	KDF        string
+	KDFParams  scryptParams
+	Cipher     string
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+type KeyInfo struct {
+	Name        string
+	Path        string
+	Scheme      string
+	Fingerprint string
+}
+
+type Keystore struct {
+	dir string
+}
+
+func Open(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Keystore{dir: dir}, nil
+}
+
+func (keystore *Keystore) Path(name string) string {
+	return filepath.Join(keystore.dir, name+".json")
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\.`)
+}
+
+/*
+Encrypt a key with the passphrase and store it under the given name. The file is only created if
+it isn't there yet, so a key saved under the same name at the same time is never overwritten.
+*/
+func (keystore *Keystore) Save(name string, privateKey *signature.PrivateKey, passphrase []byte) error {
+	if !validName(name) {
+		return errors.New("keystore: invalid key name " + name)
+	}
+
+	keyFileJSON, err := encrypt(name, privateKey, passphrase)
+	if err != nil {
+		return err
+	}
+
+	path := keystore.Path(name)
+	keyFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, fs.ErrExist) {
+		return ErrKeyExists
+	} else if err != nil {
+		return err
+	}
+
+	// A key file that was only partly written can't be loaded, and would keep the name taken
+	_, err = keyFile.Write(keyFileJSON)
+	if closeErr := keyFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+func (keystore *Keystore) Load(name string, passphrase []byte) (*signature.PrivateKey, error) {
+	return LoadFile(keystore.Path(name), passphrase)
+}
+
+// Decrypt the key stored in the given key file
+func LoadFile(path string, passphrase []byte) (*signature.PrivateKey, error) {
+	keyFileJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return decrypt(keyFileJSON, passphrase)
+}
+
+func (keystore *Keystore) List() ([]KeyInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(keystore.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keys := make([]KeyInfo, 0, len(paths))
+	for _, path := range paths {
+		keyFileJSON, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var file keyFile
+		if err := json.Unmarshal(keyFileJSON, &file); err != nil || file.Version != version {
+			continue
+		}
+
+		// Files without a Scheme hold RSA keys, and their public key is already PKIX encoded
+		scheme := signature.RSA
+		keyFingerprint := fingerprint(file.PublicKey)
+		if file.Scheme != "" {
+			if scheme, err = signature.ParseScheme(file.Scheme); err != nil {
+				continue
+			}
+			keyFingerprint = Fingerprint(signature.PublicKey{Scheme: scheme, Key: file.PublicKey})
+		}
+
+		keys = append(keys, KeyInfo{
+			Name:        file.Name,
+			Path:        path,
+			Scheme:      scheme.String(),
+			Fingerprint: keyFingerprint,
+		})
+	}
+	return keys, nil
+}
+
+/*
+First 8 bytes of the SHA-256 of the encoded public key, in hex. RSA keys are hashed in their PKIX
+encoding, so that they keep the fingerprint they had before there were signature schemes.
+*/
+func Fingerprint(publicKey signature.PublicKey) string {
+	if publicKey.Scheme != signature.RSA {
+		return fingerprint(publicKey.Key)
+	}
+
+	rsaPublicKey, err := x509.ParsePKCS1PublicKey(publicKey.Key)
+	if err != nil {
+		return ""
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(rsaPublicKey)
+	if err != nil {
+		return ""
+	}
+	return fingerprint(publicKeyDER)
+}
+
+func fingerprint(encodedPublicKey []byte) string {
+	hash := sha256.Sum256(encodedPublicKey)
+	return hex.EncodeToString(hash[:8])
+}
+
+// Unencrypted PEM encoding of the key, to move it to another keystore
+func ExportPEM(privateKey *signature.PrivateKey) []byte {
+	return privateKey.MarshalPEM()
+}
+
+// Read a key from a PEM file, see signature.ParsePEM for the formats
+func ImportPEM(keyPEM []byte) (*signature.PrivateKey, error) {
+	return signature.ParsePEM(keyPEM)
+}
+
+func encrypt(name string, privateKey *signature.PrivateKey, passphrase []byte) ([]byte, error) {
+	publicKey := privateKey.Public()
+
+	params := scryptParams{N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 32)}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(passphrase, params)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The public key is authenticated too, so that it can't be swapped in the file
+	ciphertext := aead.Seal(nil, nonce, privateKey.MarshalPEM(), publicKey.Key)
+
+	return json.MarshalIndent(keyFile{
+		Version:    version,
+		Name:       name,
+		Scheme:     publicKey.Scheme.String(),
+		PublicKey:  publicKey.Key,
+		KDF:        "scrypt",
+		KDFParams:  params,
+		Cipher:     "aes-256-gcm",
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	}, "", "\t")
+}
+
+func decrypt(keyFileJSON []byte, passphrase []byte) (*signature.PrivateKey, error) {
+	var file keyFile
+	if err := json.Unmarshal(keyFileJSON, &file); err != nil {
+		return nil, err
+	}
+
+	if file.Version != version || file.KDF != "scrypt" || file.Cipher != "aes-256-gcm" {
+		return nil, errors.New("keystore: unsupported key file format")
+	}
+
+	aead, err := newAEAD(passphrase, file.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, errors.New("keystore: invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, file.Nonce, file.Ciphertext, file.PublicKey)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	if file.Scheme == "" {
+		rsaPrivateKey, err := x509.ParsePKCS1PrivateKey(plaintext)
+		if err != nil {
+			return nil, err
+		}
+		return signature.FromRSA(rsaPrivateKey), nil
+	}
+
+	privateKey, err := signature.ParsePEM(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if privateKey.Scheme().String() != file.Scheme {
+		return nil, errors.New("keystore: the key doesn't match the scheme of the file")
+	}
+	return privateKey, nil
+}
+
+func newAEAD(passphrase []byte, params scryptParams) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, params.Salt, params.N, params.R, params.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,141 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"noobcash/signature"
+)
+
+func testKeystore(t *testing.T) *Keystore {
+	keystore, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keystore
+}
+
+func testKey(t *testing.T, scheme signature.Scheme) *signature.PrivateKey {
+	privateKey, err := signature.GenerateKey(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey
+}
+
+var passphrase = []byte("correct horse battery staple")
+
+// A key comes back from the keystore as it went in, whatever its scheme
+func TestSaveLoad(t *testing.T) {
+	keystore := testKeystore(t)
+
+	for _, scheme := range signature.Schemes {
+		privateKey := testKey(t, scheme)
+		if err := keystore.Save(scheme.String(), privateKey, passphrase); err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, err := keystore.Load(scheme.String(), passphrase)
+		if err != nil {
+			t.Fatalf("%v: %v", scheme, err)
+		}
+		if !bytes.Equal(loaded.MarshalPEM(), privateKey.MarshalPEM()) {
+			t.Errorf("%v: another key came back", scheme)
+		}
+	}
+
+	keys, err := keystore.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != len(signature.Schemes) {
+		t.Fatalf("listed %v keys", len(keys))
+	}
+	for _, key := range keys {
+		if key.Name != key.Scheme || key.Fingerprint == "" {
+			t.Errorf("listed %+v", key)
+		}
+	}
+}
+
+func TestSaveDoesNotOverwrite(t *testing.T) {
+	keystore := testKeystore(t)
+	if err := keystore.Save("wallet", testKey(t, signature.Ed25519), passphrase); err != nil {
+		t.Fatal(err)
+	}
+	if err := keystore.Save("wallet", testKey(t, signature.Ed25519), passphrase); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("saving over a key: %v", err)
+	}
+	for _, name := range []string{"", "../wallet", "a.b", `a\b`} {
+		if err := keystore.Save(name, testKey(t, signature.Ed25519), passphrase); err == nil {
+			t.Errorf("saved a key named %q", name)
+		}
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	keystore := testKeystore(t)
+	if err := keystore.Save("wallet", testKey(t, signature.Ed25519), passphrase); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, wrong := range [][]byte{[]byte("correct horse battery stapler"), {}, nil} {
+		if _, err := keystore.Load("wallet", wrong); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("loading with %q: %v", wrong, err)
+		}
+	}
+}
+
+// A damaged key file doesn't load, and never as some other key
+func TestCorruptedFile(t *testing.T) {
+	keystore := testKeystore(t)
+	if err := keystore.Save("wallet", testKey(t, signature.Secp256k1), passphrase); err != nil {
+		t.Fatal(err)
+	}
+	keyFileJSON, err := os.ReadFile(keystore.Path("wallet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	change := func(edit func(file *keyFile)) []byte {
+		var file keyFile
+		if err := json.Unmarshal(keyFileJSON, &file); err != nil {
+			t.Fatal(err)
+		}
+		edit(&file)
+		changed, err := json.Marshal(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changed
+	}
+	flip := func(data []byte) []byte {
+		changed := bytes.Clone(data)
+		changed[len(changed)/2] ^= 1
+		return changed
+	}
+
+	corrupted := map[string][]byte{
+		"truncated":   keyFileJSON[:len(keyFileJSON)/2],
+		"not json":    []byte("wallet"),
+		"ciphertext":  change(func(file *keyFile) { file.Ciphertext = flip(file.Ciphertext) }),
+		"nonce":       change(func(file *keyFile) { file.Nonce = flip(file.Nonce) }),
+		"short nonce": change(func(file *keyFile) { file.Nonce = file.Nonce[1:] }),
+		"salt":        change(func(file *keyFile) { file.KDFParams.Salt = flip(file.KDFParams.Salt) }),
+		"public key":  change(func(file *keyFile) { file.PublicKey = testKey(t, signature.Secp256k1).Public().Key }),
+		"scheme":      change(func(file *keyFile) { file.Scheme = signature.Ed25519.String() }),
+		"version":     change(func(file *keyFile) { file.Version = version + 1 }),
+		"cipher":      change(func(file *keyFile) { file.Cipher = "aes-128-cbc" }),
+	}
+	for name, data := range corrupted {
+		if err := os.WriteFile(keystore.Path(name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keystore.Load(name, passphrase); err == nil {
+			t.Errorf("a key file with a changed %v loaded", name)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"noobcash/script"
+)
+
+/*
+Outputs can carry a locking script (see the script package) instead of being spent by the key or
+multisig of their address. They pay the script address of the script, so that the UTXOs and the
+balances are still kept by address, and the input that spends them carries the unlocking script.
+The signatures in an unlocking script sign the TransactionID, the same as the signature of the
+sender, who still signs the transaction and gets its change.
+*/
+
+var errScriptAddress = errors.New("doesn't pay the address of its locking script")
+
+// An output with a locking script must pay the address of the script, and only such an output may
+func checkLockingScript(transactionOutput TransactionOutput) error {
+	if len(transactionOutput.LockingScript) == 0 {
+		if transactionOutput.RecipientAddress.Type == ScriptAddress {
+			return errScriptAddress
+		}
+		return nil
+	}
+
+	if len(transactionOutput.LockingScript) > script.MaxScriptSize {
+		return script.ErrScriptSize
+	}
+	if transactionOutput.RecipientAddress != scriptAddress(transactionOutput.LockingScript) {
+		return errScriptAddress
+	}
+	return nil
+}
+
+/*
+Run the unlocking script of the input against the locking script of the output it spends. The
+relative timelocks count the blocks from the one the output was mined in, so outputs of
+transactions that are still in the mempool can't be spent by a script that has one.
+*/
+func (node *Node) checkUnlockingScript(signedTransaction SignedTransaction, transactionInput TransactionInput, transactionOutput TransactionOutput, minedAt func([32]byte) (uint, bool), height uint) error {
+	outputHeight, confirmed := minedAt(transactionOutput.TransactionID)
+
+	context := script.Context{
+		Digest:       signedTransaction.TransactionID[:],
+		Height:       uint64(height),
+		Confirmed:    confirmed,
+		OutputHeight: uint64(outputHeight),
+	}
+	return script.Verify(transactionInput.UnlockingScript, transactionOutput.LockingScript, context)
+}
+
+/*
+script pay|list|spend lock coins with a script and spend them. The words of a script are the ones
+of script.Assemble. In an unlocking script, <sig> stands for this node's signature of the
+spending transaction and <pubkey> for this node's public key, as scripts push them.
+*/
+func (node *Node) scriptCommand(fields []string) {
+	var err error
+
+	switch {
+	case len(fields) >= 4 && fields[1] == "pay":
+		amount, parseErr := strconv.ParseUint(fields[2], 10, 32)
+		fee, feeErr := strconv.ParseUint(fields[3], 10, 32)
+		if parseErr != nil || feeErr != nil {
+			err = errors.New("amount and fee must be numbers")
+			break
+		}
+		err = node.payToScript(uint(amount), uint(fee), strings.Join(fields[4:], " "))
+
+	case len(fields) == 3 && fields[1] == "list":
+		err = node.listScriptOutputs(fields[2])
+
+	case len(fields) >= 4 && fields[1] == "spend":
+		var outputID [32]byte
+		decoded, decodeErr := hex.DecodeString(fields[2])
+		fee, feeErr := strconv.ParseUint(fields[3], 10, 32)
+		if decodeErr != nil || len(decoded) != len(outputID) || feeErr != nil {
+			err = errors.New("the output ID must be 64 hex digits and the fee a number")
+			break
+		}
+		copy(outputID[:], decoded)
+		err = node.spendScriptOutput(outputID, uint(fee), node.unlockWithWords(fields[4:]))
+
+	default:
+		node.help()
+	}
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (node *Node) payToScript(amount uint, fee uint, words string) error {
+	lockingScript, err := script.Assemble(words)
+	if err != nil {
+		return err
+	}
+	if len(lockingScript) == 0 {
+		return errors.New("the locking script is empty")
+	}
+
+	address := scriptAddress(lockingScript)
+	if !node.sendFunds([]Payment{{Address: address, Amount: amount, LockingScript: lockingScript}}, fee) {
+		return errors.New("payToScript: the transaction could not be made")
+	}
+
+	fmt.Println("\nCoins locked at script address", address)
+	return nil
+}
+
+func (node *Node) listScriptOutputs(encodedAddress string) error {
+	address, err := parseAddress(encodedAddress)
+	if err != nil {
+		return err
+	}
+	if address.Type != ScriptAddress {
+		return errors.New("not a script address")
+	}
+
+	node.blockchainLock.Lock()
+	transactionOutputs := node.UTXOsCommitted.OutputsOf(address)
+	node.blockchainLock.Unlock()
+
+	fmt.Println("")
+	for _, transactionOutput := range transactionOutputs {
+		fmt.Printf("%x %v %v\n", transactionOutput.ID, transactionOutput.Amount, script.Disassemble(transactionOutput.LockingScript))
+	}
+	return nil
+}
+
+// An unlocking script made of the words, with <sig> and <pubkey> filled in
+func (node *Node) unlockWithWords(words []string) func(TransactionOutput, []byte) ([]byte, error) {
+	return func(spentOutput TransactionOutput, spenderSignature []byte) ([]byte, error) {
+		unlockingWords := make([]string, len(words))
+		for i, word := range words {
+			switch word {
+			case "<sig>":
+				unlockingWords[i] = "0x" + hex.EncodeToString(spenderSignature)
+			case "<pubkey>":
+				unlockingWords[i] = "0x" + hex.EncodeToString(script.EncodePublicKey(node.publicKey))
+			default:
+				unlockingWords[i] = word
+			}
+		}
+		return script.Assemble(strings.Join(unlockingWords, " "))
+	}
+}
+
+/*
+Spend a committed output with a locking script to our wallet, leaving fee coins to the miner. The
+unlocking script can only be made once the TransactionID is known, since our signature of it is
+what unlock gets along with the spent output.
+*/
+func (node *Node) spendScriptOutput(outputID [32]byte, fee uint, unlock func(TransactionOutput, []byte) ([]byte, error)) error {
+	node.mineLock.Lock()
+	defer node.mineLock.Unlock()
+
+	node.blockchainLock.Lock()
+	spentOutput, ok := node.UTXOsCommitted.Get(outputID)
+	node.blockchainLock.Unlock()
+
+	if !ok || len(spentOutput.LockingScript) == 0 {
+		return errors.New("spendScriptOutput: no unspent output with a locking script has that ID")
+	}
+	if spentOutput.Amount <= fee {
+		return errors.New("spendScriptOutput: the fee takes all of the coins")
+	}
+
+	transaction := Transaction{
+		SenderAddress:      node.publicKey,
+		Fee:                fee,
+		TransactionInputs:  []TransactionInput{{PreviousOutputID: outputID}},
+		TransactionOutputs: []TransactionOutput{{RecipientAddress: node.walletAddress, Amount: spentOutput.Amount - fee}},
+	}
+	transaction.setIDs()
+
+	spenderSignature, err := node.privateKey.Sign(transaction.TransactionID[:])
+	if err != nil {
+		return err
+	}
+	transaction.TransactionInputs[0].UnlockingScript, err = unlock(spentOutput, spenderSignature)
+	if err != nil {
+		return err
+	}
+
+	signedTransaction, err := node.signTransaction(transaction)
+	if err != nil {
+		return err
+	}
+	if rejectError := node.admitTransaction(signedTransaction); rejectError != nil {
+		return fmt.Errorf("spendScriptOutput: transaction rejected - %w", rejectError)
+	}
+	node.broadcastTransaction(signedTransaction)
+
+	fmt.Printf("\nTransaction %x sent\n", signedTransaction.TransactionID)
+	return nil
+}
@@ -2,66 +2,84 @@ package main
 
 import (
 	"bufio"
-	"flag"
 	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 )
 
-const NUM_NODES = 5
-const DIFFICULTY = 4 // number of hex digits to be zero on the start of a block's hash
-const CAPACITY = 1
-
 func main() {
 	if len(os.Args) < 2 {
 		fmt.Println("Missing arguments. Run './noobcash.elf -h' for help")
 		return
 	}
 
-	var node Node
+	if os.Args[1] == "wallet" {
+		walletCommand(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "script" {
+		scriptCommand(os.Args[2:])
+		return
+	}
+	if os.Args[1] == "rpc" {
+		rpcCommand(os.Args[2:])
+		return
+	}
 
-	localAddress := flag.String("localAddress", "localhost:50000", "This node's ip:port")
-	bootstrapAddress := flag.String("bootstrapAddress", "localhost:50000", "Bootstrap node's ip:port")
-	transactionFile := flag.String("transactionFile", "none", "File containing this node's transactions")
-	isBootstrap := flag.Bool("isBootstrap", false, "True if this node is bootstrap")
+	var node Node
 
-	flag.Parse()
+	config, explicitParams, err := parseConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	node.createNode(*localAddress)
+	node.createNode(config, explicitParams)
 
 	go node.collectTransactions()
 
-	if *isBootstrap {
+	if config.HTTPAddress != "" {
+		go node.serveAPI(config.HTTPAddress)
+	}
+	if config.RPCAddress != "" {
+		go node.serveRPC(config.RPCAddress)
+	}
+	if config.RPCSocket != "" {
+		go node.serveRPCSocket(config.RPCSocket)
+	}
+
+	// Stopping the node with Ctrl-C lets the other nodes know that we left
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		node.leave()
+		os.Exit(0)
+	}()
+
+	if config.IsBootstrap {
 		node.id = "id0"
-		go node.startBootstrap(*localAddress)
+		node.paramsReceived = true
+		go node.startBootstrap(config.LocalAddress)
 	} else {
-		go node.startOrdinaryNode(*localAddress)
-		go node.connectionStart("id0", *bootstrapAddress)
+		go node.startOrdinaryNode(config.LocalAddress)
+		go node.connectionStart("id0", config.BootstrapAddress)
 	}
 
-	for {
-		node.blockchainLock.Lock()
-		if len(node.blockchain) > 0 {
-			node.blockchainLock.Unlock()
-			break
-		}
-		node.blockchainLock.Unlock()
-	}
+	node.waitForChain(1)
 
 	startTime := time.Now().Unix()
 
-	for len(node.blockchain) < NUM_NODES {
-		continue
-	}
+	node.waitForChain(node.params.NumNodes)
 
 	fmt.Println("All clients have 100 coins")
 
-	if *transactionFile != "none" {
+	if config.TransactionFile != "none" {
 
-		file, err := os.Open(*transactionFile)
+		file, err := os.Open(config.TransactionFile)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -77,19 +95,13 @@ func main() {
 				break
 			}
 
-			if _, ok := node.nodeDataMap[fields[0]]; !ok {
-				fmt.Println("No node with id", fields[0])
-				break
-			}
-
-			parsed, err := strconv.ParseUint(fields[1], 10, 32)
+			payments, fee, err := node.parsePayments(fields)
 			if err != nil {
-				log.Println("cli: ParseUint", err)
+				fmt.Println(err)
 				break
 			}
-			amount := uint(parsed)
 
-			node.transaction(fields[0], amount)
+			node.transaction(payments, fee)
 		}
 
 		if err := scanner.Err(); err != nil {
@@ -102,17 +114,14 @@ func main() {
 		fmt.Println("Blocks:", len(node.blockchain))
 		fmt.Println("Time:", endTime-startTime, "sec")
 		fmt.Println("Average block time:", blockTime, "sec")
-		fmt.Println("Throughput:", float32(CAPACITY)/blockTime)
+		fmt.Println("Throughput:", float32(node.params.Capacity)/blockTime)
 		fmt.Println("##########################################################")
 		fmt.Println("")
 		fmt.Println("")
 	}
 
-	node.test()
-
 	for {
-		reader := bufio.NewReader(os.Stdin)
-		line, _ := reader.ReadString('\n')
+		line, _ := stdin.ReadString('\n')
 		fields := strings.Fields(line)
 		node.cli(fields)
 	}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+/*
+The mempool holds the transactions that are waiting to be mined. It is indexed by TransactionID,
+by the outputs its transactions spend and by the outputs they create, so that:
+
+  - a transaction that spends an output another transaction of the pool already spends is
+    rejected as a double spend when it arrives
+  - a transaction can spend the change of another transaction of the pool, and the two are then
+    mined together as a package, parent first
+  - the block builder picks the packages that pay the highest fee per byte without copying the
+    pool
+
+When the pool is full, a new transaction pushes out the transaction with the lowest fee rate (and
+everything that spends its outputs), if it pays more per byte than that one. The transactions it
+spends the outputs of are never pushed out for it, or it would be left spending nothing.
+*/
+const maxMempoolSize = 5000
+
+var errAlreadyKnown = &RejectError{RejectDuplicate, "transaction already known"}
+var errDoubleSpend = &RejectError{RejectDoubleSpend, "transaction spends an output that a transaction in the mempool already spends"}
+var errMempoolFull = &RejectError{RejectMempoolFull, "mempool full and the transaction's fee rate is too low"}
+
+type Mempool struct {
+	lock    sync.Mutex
+	maxSize int
+
+	transactions map[[32]byte]*mempoolEntry
+	spentBy      map[[32]byte][32]byte // output ID -> ID of the transaction in the pool that spends it
+	createdBy    map[[32]byte][32]byte // output ID -> ID of the transaction in the pool that created it
+
+	arrivals uint64        // counts the transactions added, to keep them in the order they came
+	changed  chan struct{} // signalled whenever transactions are added or removed
+}
+
+type mempoolEntry struct {
+	transaction SignedTransaction
+	size        uint64 // bytes of the canonical body and the signatures
+	arrival     uint64
+}
+
+func NewMempool(maxSize int) *Mempool {
+	return &Mempool{
+		maxSize:      maxSize,
+		transactions: make(map[[32]byte]*mempoolEntry),
+		spentBy:      make(map[[32]byte][32]byte),
+		createdBy:    make(map[[32]byte][32]byte),
+		changed:      make(chan struct{}, 1),
+	}
+}
+
+func transactionSize(signedTransaction SignedTransaction) uint64 {
+	transaction := signedTransaction.unsigned()
+	size := len(transaction.canonicalBody()) + len(signedTransaction.Signature)
+	for _, cosignature := range signedTransaction.Cosignatures {
+		size += 4 + len(cosignature.Signature)
+	}
+	for _, transactionInput := range signedTransaction.TransactionInputs {
+		size += len(transactionInput.UnlockingScript)
+	}
+	return uint64(size)
+}
+
+// Whether a fee of feeA for sizeA bytes is a lower rate than feeB for sizeB bytes
+func lowerFeeRate(feeA uint64, sizeA uint64, feeB uint64, sizeB uint64) bool {
+	return feeA*sizeB < feeB*sizeA
+}
+
+func (mempool *Mempool) Size() int {
+	mempool.lock.Lock()
+	defer mempool.lock.Unlock()
+
+	return len(mempool.transactions)
+}
+
+func (mempool *Mempool) Contains(transactionID [32]byte) bool {
+	mempool.lock.Lock()
+	defer mempool.lock.Unlock()
+
+	_, ok := mempool.transactions[transactionID]
+	return ok
+}
+
+// The output, if a transaction of the pool creates it, so that a new transaction can spend it
+func (mempool *Mempool) Creates(outputID [32]byte) (TransactionOutput, bool) {
+	mempool.lock.Lock()
+	defer mempool.lock.Unlock()
+
+	transactionID, ok := mempool.createdBy[outputID]
+	if !ok {
+		return TransactionOutput{}, false
+	}
+	for _, transactionOutput := range mempool.transactions[transactionID].transaction.TransactionOutputs {
+		if transactionOutput.ID == outputID {
+			return transactionOutput, true
+		}
+	}
+	return TransactionOutput{}, false
+}
+
+// Whether a transaction of the pool already spends the output
+func (mempool *Mempool) Spends(outputID [32]byte) bool {
+	mempool.lock.Lock()
+	defer mempool.lock.Unlock()
+
+	_, ok := mempool.spentBy[outputID]
+	return ok
+}
+
+func (mempool *Mempool) Add(signedTransaction SignedTransaction) *RejectError {
+	mempool.lock.Lock()
+	defer mempool.lock.Unlock()
+
+	if _, ok := mempool.transactions[signedTransaction.TransactionID]; ok {
+		return errAlreadyKnown
+	}
+	for _, transactionInput := range signedTransaction.TransactionInputs {
+		if _, ok := mempool.spentBy[transactionInput.PreviousOutputID]; ok {
+			return errDoubleSpend
+		}
+	}
+
+	entry := &mempoolEntry{
+		transaction: signedTransaction,
+		size:        transactionSize(signedTransaction),
+		arrival:     mempool.arrivals,
+	}
+
+	if len(mempool.transactions) >= mempool.maxSize {
+		// The transactions the new one spends from can't make room for it
+		ancestors := make(map[[32]byte]bool)
+		for _, ancestor := range mempool.ancestorPackage(entry, nil) {
+			ancestors[ancestor.transaction.TransactionID] = true
+		}
+
+		lowest := mempool.lowestFeeRate(ancestors)
+		if lowest == nil || !lowerFeeRate(uint64(lowest.transaction.Fee), lowest.size, uint64(entry.transaction.Fee), entry.size) {
+			return errMempoolFull
+		}
+		mempool.removeWithDescendants(lowest.transaction.TransactionID)
+	}
+
+	mempool.arrivals++
+	mempool.transactions[signedTransaction.TransactionID] = entry
+	for _, transactionInput := range signedTransaction.TransactionInputs {
+		mempool.spentBy[transactionInput.PreviousOutputID] = signedTransaction.TransactionID
+	}
+	for _, transactionOutput := range signedTransaction.TransactionOutputs {
+		mempool.createdBy[transactionOutput.ID] = signedTransaction.TransactionID
+	}
+
+	mempool.notify()
+	return nil
+}
+
+/*
+Take out the transactions of a block that was added to the main chain, along with the ones that
+spend the same outputs, which can never be mined now, and everything that spends theirs.
+*/
+func (mempool *Mempool) RemoveConfirmed(transactions []SignedTransaction) {
+	mempool.lock.Lock()
+	defer mempool.lock.Unlock()
+
+	for _, transaction := range transactions {
+		mempool.remove(transaction.TransactionID)
+
+		for _, transactionInput := range transaction.TransactionInputs {
+			if conflictID, ok := mempool.spentBy[transactionInput.PreviousOutputID]; ok {
+				mempool.removeWithDescendants(conflictID)
+			}
+		}
+	}
+	mempool.notify()
+}
+
+// Take out a transaction that can't be mined anymore, and everything that spends its outputs
+func (mempool *Mempool) Remove(transactionID [32]byte) {
+	mempool.lock.Lock()
+	defer mempool.lock.Unlock()
+
+	mempool.removeWithDescendants(transactionID)
+	mempool.notify()
+}
+
+// The transactions of the pool in the order they arrived
+func (mempool *Mempool) Transactions() []SignedTransaction {
+	mempool.lock.Lock()
+	entries := make([]*mempoolEntry, 0, len(mempool.transactions))
+	for _, entry := range mempool.transactions {
+		entries = append(entries, entry)
+	}
+	mempool.lock.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].arrival < entries[j].arrival })
+
+	transactions := make([]SignedTransaction, len(entries))
+	for i, entry := range entries {
+		transactions[i] = entry.transaction
+	}
+	return transactions
+}
+
+func (mempool *Mempool) Changed() <-chan struct{} {
+	return mempool.changed
+}
+
+/*
+Pick up to capacity transactions for a block. Each time the package with the highest fee rate is
+taken, where the package of a transaction is the transaction together with the ancestors in the
+pool that haven't been picked yet. The transactions of a package are checked with valid in order,
+parents first, and a transaction that fails is left out along with everything that depends on it.
+*/
+func (mempool *Mempool) Select(capacity int, valid func(SignedTransaction) bool) []SignedTransaction {
+	mempool.lock.Lock()
+	defer mempool.lock.Unlock()
+
+	selected := make(map[[32]byte]bool)
+	failed := make(map[[32]byte]bool)
+	transactions := make([]SignedTransaction, 0, capacity)
+
+	for len(transactions) < capacity {
+		var best []*mempoolEntry
+		var bestFee, bestSize uint64
+
+		for transactionID, entry := range mempool.transactions {
+			if selected[transactionID] || failed[transactionID] {
+				continue
+			}
+
+			ancestors := mempool.ancestorPackage(entry, selected)
+			if len(ancestors) > capacity-len(transactions) {
+				continue
+			}
+
+			var fee, size uint64
+			dependsOnFailed := false
+			for _, ancestor := range ancestors {
+				fee += uint64(ancestor.transaction.Fee)
+				size += ancestor.size
+				dependsOnFailed = dependsOnFailed || failed[ancestor.transaction.TransactionID]
+			}
+			if dependsOnFailed {
+				continue
+			}
+
+			// Equal rates go to the package that arrived first
+			if best == nil || lowerFeeRate(bestFee, bestSize, fee, size) ||
+				(!lowerFeeRate(fee, size, bestFee, bestSize) && entry.arrival < best[len(best)-1].arrival) {
+				best, bestFee, bestSize = ancestors, fee, size
+			}
+		}
+
+		if best == nil {
+			break
+		}
+
+		for _, entry := range best {
+			if !valid(entry.transaction) {
+				failed[entry.transaction.TransactionID] = true
+				break
+			}
+			selected[entry.transaction.TransactionID] = true
+			transactions = append(transactions, entry.transaction)
+		}
+	}
+
+	return transactions
+}
+
+// The entry with its ancestors in the pool that aren't in skip, parents before children
+func (mempool *Mempool) ancestorPackage(entry *mempoolEntry, skip map[[32]byte]bool) []*mempoolEntry {
+	ancestors := make([]*mempoolEntry, 0, 1)
+	visited := make(map[[32]byte]bool)
+
+	var visit func(entry *mempoolEntry)
+	visit = func(entry *mempoolEntry) {
+		transactionID := entry.transaction.TransactionID
+		if visited[transactionID] || skip[transactionID] {
+			return
+		}
+		visited[transactionID] = true
+
+		for _, transactionInput := range entry.transaction.TransactionInputs {
+			if parentID, ok := mempool.createdBy[transactionInput.PreviousOutputID]; ok {
+				visit(mempool.transactions[parentID])
+			}
+		}
+		ancestors = append(ancestors, entry)
+	}
+
+	visit(entry)
+	return ancestors
+}
+
+// The entry with the lowest fee rate that isn't in skip, nil if there is none
+func (mempool *Mempool) lowestFeeRate(skip map[[32]byte]bool) *mempoolEntry {
+	var lowest *mempoolEntry
+	for transactionID, entry := range mempool.transactions {
+		if skip[transactionID] {
+			continue
+		}
+		if lowest == nil || lowerFeeRate(uint64(entry.transaction.Fee), entry.size, uint64(lowest.transaction.Fee), lowest.size) {
+			lowest = entry
+		}
+	}
+	return lowest
+}
+
+func (mempool *Mempool) remove(transactionID [32]byte) bool {
+	entry, ok := mempool.transactions[transactionID]
+	if !ok {
+		return false
+	}
+
+	delete(mempool.transactions, transactionID)
+	for _, transactionInput := range entry.transaction.TransactionInputs {
+		delete(mempool.spentBy, transactionInput.PreviousOutputID)
+	}
+	for _, transactionOutput := range entry.transaction.TransactionOutputs {
+		delete(mempool.createdBy, transactionOutput.ID)
+	}
+	return true
+}
+
+func (mempool *Mempool) removeWithDescendants(transactionID [32]byte) {
+	entry, ok := mempool.transactions[transactionID]
+	if !ok {
+		return
+	}
+
+	mempool.remove(transactionID)
+	for _, transactionOutput := range entry.transaction.TransactionOutputs {
+		if childID, ok := mempool.spentBy[transactionOutput.ID]; ok {
+			mempool.removeWithDescendants(childID)
+		}
+	}
+}
+
+func (mempool *Mempool) notify() {
+	select {
+	case mempool.changed <- struct{}{}:
+	default:
+	}
+}
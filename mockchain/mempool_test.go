@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+/*
+A transaction with one input and one output, so that all of them have the same size and their
+fee rates go by their fees. Only the ids matter to the mempool, the signatures aren't checked.
+*/
+func testSpend(fee uint, previousOutputID [32]byte) SignedTransaction {
+	transaction := Transaction{
+		Fee:                fee,
+		TransactionInputs:  []TransactionInput{{PreviousOutputID: previousOutputID}},
+		TransactionOutputs: []TransactionOutput{{Amount: 10}},
+	}
+	transaction.setIDs()
+	return SignedTransaction{
+		Fee:                transaction.Fee,
+		TransactionID:      transaction.TransactionID,
+		TransactionInputs:  transaction.TransactionInputs,
+		TransactionOutputs: transaction.TransactionOutputs,
+	}
+}
+
+// A transaction spending an output that isn't in the pool
+func testTransaction(fee uint, name string) SignedTransaction {
+	return testSpend(fee, sha256.Sum256([]byte(name)))
+}
+
+func testChild(fee uint, parent SignedTransaction) SignedTransaction {
+	return testSpend(fee, parent.TransactionOutputs[0].ID)
+}
+
+func addAll(t *testing.T, mempool *Mempool, transactions ...SignedTransaction) {
+	for _, transaction := range transactions {
+		if err := mempool.Add(transaction); err != nil {
+			t.Fatalf("transaction %x: %v", transaction.TransactionID, err)
+		}
+	}
+}
+
+func transactionIDsOf(transactions []SignedTransaction) map[[32]byte]int {
+	positions := make(map[[32]byte]int)
+	for i, transaction := range transactions {
+		positions[transaction.TransactionID] = i
+	}
+	return positions
+}
+
+func allValid(SignedTransaction) bool { return true }
+
+// Packages go by their fee rate, parents before children, and equal rates by arrival
+func TestMempoolSelectOrder(t *testing.T) {
+	mempool := NewMempool(maxMempoolSize)
+	low := testTransaction(1, "low")
+	high := testTransaction(5, "high")
+	same := testTransaction(5, "same")
+	parent := testTransaction(1, "parent")
+	child := testChild(20, parent)
+	addAll(t, mempool, low, high, same, parent, child)
+
+	selected := mempool.Select(5, allValid)
+	want := [][32]byte{parent.TransactionID, child.TransactionID, high.TransactionID, same.TransactionID, low.TransactionID}
+	if len(selected) != len(want) {
+		t.Fatalf("selected %v transactions", len(selected))
+	}
+	for i, transactionID := range want {
+		if selected[i].TransactionID != transactionID {
+			t.Errorf("transaction %v is %x, want %x", i, selected[i].TransactionID, transactionID)
+		}
+	}
+
+	// A package that doesn't fit in what is left of the block is passed over
+	if selected := mempool.Select(1, allValid); len(selected) != 1 || selected[0].TransactionID != high.TransactionID {
+		t.Errorf("with room for one, selected %v", transactionIDsOf(selected))
+	}
+
+	// A child isn't picked without its parent
+	invalidParent := func(transaction SignedTransaction) bool { return transaction.TransactionID != parent.TransactionID }
+	if _, ok := transactionIDsOf(mempool.Select(5, invalidParent))[child.TransactionID]; ok {
+		t.Error("the child of an invalid parent was selected")
+	}
+}
+
+// Mined transactions leave the pool, and so do the ones that spend the same outputs and their children
+func TestMempoolRemoveConfirmed(t *testing.T) {
+	mempool := NewMempool(maxMempoolSize)
+	mined := testTransaction(1, "mined")
+	conflicting := testTransaction(2, "conflicting")
+	conflictingChild := testChild(3, conflicting)
+	unrelated := testTransaction(4, "unrelated")
+	addAll(t, mempool, mined, conflicting, conflictingChild, unrelated)
+
+	// The block spends the output conflicting spends, in another transaction
+	doubleSpend := testSpend(9, conflicting.TransactionInputs[0].PreviousOutputID)
+	mempool.RemoveConfirmed([]SignedTransaction{mined, doubleSpend})
+
+	for _, transaction := range []SignedTransaction{mined, conflicting, conflictingChild} {
+		if mempool.Contains(transaction.TransactionID) {
+			t.Errorf("transaction %x is still in the pool", transaction.TransactionID)
+		}
+	}
+	if !mempool.Contains(unrelated.TransactionID) || mempool.Size() != 1 {
+		t.Errorf("the pool has %v transactions", mempool.Size())
+	}
+	if mempool.Spends(conflicting.TransactionInputs[0].PreviousOutputID) {
+		t.Error("the output of the removed transaction is still spent in the pool")
+	}
+}
+
+// A full pool makes room by pushing out the lowest fee rate, never one the new transaction spends from
+func TestMempoolEviction(t *testing.T) {
+	mempool := NewMempool(2)
+	parent := testTransaction(1, "parent")
+	other := testTransaction(2, "other")
+	addAll(t, mempool, parent, other)
+
+	if err := mempool.Add(testTransaction(1, "too low")); err != errMempoolFull {
+		t.Errorf("a transaction paying no more than the lowest got %v", err)
+	}
+
+	child := testChild(10, parent)
+	addAll(t, mempool, child)
+	if !mempool.Contains(parent.TransactionID) || !mempool.Contains(child.TransactionID) || mempool.Contains(other.TransactionID) {
+		t.Errorf("the pool has %v", transactionIDsOf(mempool.Transactions()))
+	}
+
+	// With nothing but its ancestors to push out, the transaction doesn't get in
+	if err := mempool.Add(testChild(20, child)); err != errMempoolFull {
+		t.Errorf("a transaction that could only push out its ancestors got %v", err)
+	}
+
+	// Pushing out a transaction takes its children with it
+	addAll(t, mempool, testTransaction(30, "rich"))
+	if mempool.Contains(parent.TransactionID) || mempool.Contains(child.TransactionID) || mempool.Size() != 1 {
+		t.Errorf("the pool has %v", transactionIDsOf(mempool.Transactions()))
+	}
+}
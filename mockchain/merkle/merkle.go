@@ -0,0 +1,120 @@
+/*
+Package merkle builds the Merkle tree of the transactions of a block and checks inclusion
+proofs against its root. It has no other dependencies, so light clients that only keep block
+headers can use it to check that a transaction is part of a block.
+
+Leaves and inner nodes are hashed with different prefixes, so that an inner node can never be
+passed off as a leaf:
+
+	leaf  = SHA-256(0x00 || TransactionID)
+	inner = SHA-256(0x01 || left || right)
+
+When a level has an odd number of nodes, the last one moves up to the next level unchanged.
+The root of a block without transactions is SHA-256 of the empty string.
+*/
+package merkle
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+const (
+	leafPrefix  = 0x00
+	innerPrefix = 0x01
+)
+
+// One step from a leaf towards the root: the sibling to hash with, and on which side it is
+type ProofStep struct {
+	Sibling [32]byte
+	Left    bool // the sibling is the left child
+}
+
+type Proof struct {
+	Index int // position of the leaf in the block
+	Steps []ProofStep
+}
+
+func LeafHash(leaf [32]byte) [32]byte {
+	buffer := make([]byte, 0, 1+32)
+	buffer = append(buffer, leafPrefix)
+	buffer = append(buffer, leaf[:]...)
+	return sha256.Sum256(buffer)
+}
+
+func innerHash(left [32]byte, right [32]byte) [32]byte {
+	buffer := make([]byte, 0, 1+32+32)
+	buffer = append(buffer, innerPrefix)
+	buffer = append(buffer, left[:]...)
+	buffer = append(buffer, right[:]...)
+	return sha256.Sum256(buffer)
+}
+
+func nextLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 < len(level) {
+			next = append(next, innerHash(level[i], level[i+1]))
+		} else {
+			next = append(next, level[i])
+		}
+	}
+	return next
+}
+
+func leafLevel(leaves [][32]byte) [][32]byte {
+	level := make([][32]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = LeafHash(leaf)
+	}
+	return level
+}
+
+func Root(leaves [][32]byte) [32]byte {
+	if len(leaves) == 0 {
+		return sha256.Sum256(nil)
+	}
+
+	level := leafLevel(leaves)
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+// The proof that the leaf at the given index is part of the tree of the leaves
+func BuildProof(leaves [][32]byte, index int) (Proof, error) {
+	if index < 0 || index >= len(leaves) {
+		return Proof{}, errors.New("merkle: leaf index out of range")
+	}
+
+	proof := Proof{Index: index, Steps: make([]ProofStep, 0)}
+
+	level := leafLevel(leaves)
+	position := index
+	for len(level) > 1 {
+		if position%2 == 1 {
+			proof.Steps = append(proof.Steps, ProofStep{Sibling: level[position-1], Left: true})
+		} else if position+1 < len(level) {
+			proof.Steps = append(proof.Steps, ProofStep{Sibling: level[position+1], Left: false})
+		}
+
+		level = nextLevel(level)
+		position /= 2
+	}
+
+	return proof, nil
+}
+
+// Check that the leaf is part of the tree with the given root
+func Verify(leaf [32]byte, proof Proof, root [32]byte) bool {
+	hash := LeafHash(leaf)
+	for _, step := range proof.Steps {
+		if step.Left {
+			hash = innerHash(step.Sibling, hash)
+		} else {
+			hash = innerHash(hash, step.Sibling)
+		}
+	}
+	return hash == root
+}
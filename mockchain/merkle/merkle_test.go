@@ -0,0 +1,115 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func testLeaves(count int) [][32]byte {
+	leaves := make([][32]byte, count)
+	for i := range leaves {
+		leaves[i] = sha256.Sum256([]byte{byte(i)})
+	}
+	return leaves
+}
+
+func TestEmptyRoot(t *testing.T) {
+	if Root(nil) != sha256.Sum256(nil) {
+		t.Error("the root of no leaves isn't SHA-256 of the empty string")
+	}
+}
+
+// A single leaf is its own tree: the root is the leaf hash and the proof has no steps
+func TestSingleLeaf(t *testing.T) {
+	leaves := testLeaves(1)
+	root := Root(leaves)
+	if root != LeafHash(leaves[0]) {
+		t.Error("the root of one leaf isn't its leaf hash")
+	}
+	if root == leaves[0] {
+		t.Error("the leaf went into the root without the leaf prefix")
+	}
+
+	proof, err := BuildProof(leaves, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Steps) != 0 || !Verify(leaves[0], proof, root) {
+		t.Errorf("bad proof of the single leaf: %+v", proof)
+	}
+}
+
+// With three leaves the third has no sibling and moves up unchanged
+func TestOddNodePromotion(t *testing.T) {
+	leaves := testLeaves(3)
+	want := innerHash(innerHash(LeafHash(leaves[0]), LeafHash(leaves[1])), LeafHash(leaves[2]))
+	if root := Root(leaves); root != want {
+		t.Errorf("root %x, want %x", root, want)
+	}
+
+	// The promoted leaf has one step less in its proof
+	proof, err := BuildProof(leaves, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proof.Steps) != 1 || !proof.Steps[0].Left {
+		t.Errorf("proof of the promoted leaf: %+v", proof)
+	}
+
+	// Duplicating the last leaf, as some trees do to fill a level, gives another root
+	if Root(append(leaves, leaves[2])) == Root(leaves) {
+		t.Error("a duplicated last leaf gives the same root")
+	}
+}
+
+/*
+The prefixes keep the two kinds of nodes apart: an inner node passed off as a leaf doesn't hash
+to the same value, so a tree can't be shortened by presenting its inner nodes as leaves.
+*/
+func TestDomainSeparation(t *testing.T) {
+	leaves := testLeaves(4)
+	left := innerHash(LeafHash(leaves[0]), LeafHash(leaves[1]))
+	right := innerHash(LeafHash(leaves[2]), LeafHash(leaves[3]))
+
+	if Root([][32]byte{left, right}) == Root(leaves) {
+		t.Error("the inner nodes as leaves give the root of the full tree")
+	}
+
+	leftLeaf, rightLeaf := LeafHash(leaves[0]), LeafHash(leaves[1])
+	if unprefixed := sha256.Sum256(append(leftLeaf[:], rightLeaf[:]...)); unprefixed == left {
+		t.Error("an inner node hashes the same without its prefix")
+	}
+
+	proof, err := BuildProof([][32]byte{left, right}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Verify(left, proof, Root(leaves)) {
+		t.Error("an inner node verifies as a leaf of the full tree")
+	}
+}
+
+// Every leaf of trees of every shape up to a few levels proves against the root, and only there
+func TestProofs(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		leaves := testLeaves(count)
+		root := Root(leaves)
+
+		for index := range leaves {
+			proof, err := BuildProof(leaves, index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !Verify(leaves[index], proof, root) {
+				t.Errorf("leaf %v of %v doesn't verify", index, count)
+			}
+			if Verify(leaves[(index+1)%count], proof, root) && count > 1 {
+				t.Errorf("the proof of leaf %v of %v verifies another leaf", index, count)
+			}
+		}
+
+		if _, err := BuildProof(leaves, count); err == nil {
+			t.Errorf("a proof of leaf %v of %v was built", count, count)
+		}
+	}
+}
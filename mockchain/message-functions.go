@@ -1,318 +1,312 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"crypto/sha256"
 	"log"
-	"net"
 )
 
-func sendMessage(connection net.Conn, message Message) {
-	messageJSON, _ := json.Marshal(message)
+/*
+Encode the message data with the codec agreed on with the peer and queue it. If the binary
+codec can't encode something we fall back to JSON, which every node understands.
+*/
+func sendMessage(peer *Peer, messageType MessageType, messageData interface{}) {
+	codec := peer.getCodec()
 
-	connection.Write(messageJSON)
-	connection.Write([]byte("\n"))
+	data, err := marshalWithCodec(codec, messageData)
+	if err != nil && codec != JSONCodec {
+		codec = JSONCodec
+		data, err = marshalWithCodec(codec, messageData)
+	}
+	if err != nil {
+		log.Println("sendMessage:", err)
+		return
+	}
+
+	peer.send(Message{MessageType: messageType, MessageData: data, codec: codec})
 }
 
-func (node *Node) sendWelcomeMessage(connection net.Conn, id string) {
+func (message Message) decode(messageData interface{}) error {
+	return unmarshalWithCodec(message.codec, message.MessageData, messageData)
+}
 
-	node.nodeDataMap[id] = new(NodeData)
+func (node *Node) sendWelcomeMessage(peer *Peer, id string) {
 
-	welcomeMessage := WelcomeMessage{id}
+	node.reserveNodeID(id)
 
-	welcomeMessageJSON, err := json.Marshal(welcomeMessage)
-	if err != nil {
-		log.Fatal("sendWelcomeMessage:", err)
-	}
+	node.blockchainLock.Lock()
+	chainLength := uint(len(node.blockchain))
+	node.blockchainLock.Unlock()
 
-	message := Message{
-		WelcomeMessageType,
-		welcomeMessageJSON,
-	}
+	welcomeMessage := WelcomeMessage{ID: id, Params: node.params, Codecs: node.codecs, ChainLength: chainLength}
 
-	sendMessage(connection, message)
+	sendMessage(peer, WelcomeMessageType, welcomeMessage)
 }
 
-func (node *Node) sendNewConnectionMessage(connection net.Conn) {
+func (node *Node) sendNewConnectionMessage(peer *Peer) {
 
-	newConnectionMessage := NewConnectionMessage{node.id}
+	newConnectionMessage := NewConnectionMessage{ID: node.id, Codecs: node.codecs}
 
-	newConnectionMessageJSON, err := json.Marshal(newConnectionMessage)
-	if err != nil {
-		log.Fatal("sendNewConnectionMessage:", err)
-	}
-
-	message := Message{
-		NewConnectionMessageType,
-		newConnectionMessageJSON,
-	}
-
-	sendMessage(connection, message)
+	sendMessage(peer, NewConnectionMessageType, newConnectionMessage)
 }
 
-func (node *Node) sendMyInfoMessage(connection net.Conn) {
+func (node *Node) sendMyInfoMessage(peer *Peer) {
 
 	myInfoMessage := MyInfoMessage{
 		ID:        node.id,
 		PublicKey: node.publicKey,
-		Address:   node.address}
-
-	myInfoMessageJSON, err := json.Marshal(myInfoMessage)
-	if err != nil {
-		log.Fatal("sendMyInfoMessage:", err)
-	}
-
-	message := Message{
-		MessageType: MyInfoMessageType,
-		MessageData: myInfoMessageJSON,
-	}
+		Address:   node.address,
+		Codecs:    node.codecs}
 
-	sendMessage(connection, message)
+	sendMessage(peer, MyInfoMessageType, myInfoMessage)
 }
 
-func (node *Node) sendNodeDataMessage(connection net.Conn) {
-
-	nodeDataList := make(map[string]NodeData)
-	for id, nodeData := range node.nodeDataMap {
-		nodeDataList[id] = NodeData{Address: nodeData.Address, PublicKey: nodeData.PublicKey}
-	}
+func (node *Node) sendNodeDataMessage(peer *Peer) {
 
 	nodeDataListMessage := NodeDataMessage{
-		nodeDataList,
+		node.nodeDataSnapshot(),
 	}
 
-	nodeDataListMessageJSON, err := json.Marshal(nodeDataListMessage)
-	if err != nil {
-		return
-	}
-
-	message := Message{
-		MessageType: NodeDataMessageType,
-		MessageData: nodeDataListMessageJSON,
-	}
-
-	sendMessage(connection, message)
+	sendMessage(peer, NodeDataMessageType, nodeDataListMessage)
 }
 
-func (node *Node) sendTransactionMessage(connection net.Conn, signedTranscation SignedTransaction) {
+func (node *Node) sendTransactionMessage(peer *Peer, signedTranscation SignedTransaction) {
 
 	transactionMessage := TransactionMessage{signedTranscation}
 
-	transactionMessageJSON, err := json.Marshal(transactionMessage)
-	if err != nil {
-		return
-	}
-
-	message := Message{
-		MessageType: TransactionMessageType,
-		MessageData: transactionMessageJSON,
-	}
-
-	sendMessage(connection, message)
+	sendMessage(peer, TransactionMessageType, transactionMessage)
 }
 
-func (node *Node) sendBlockMessage(connections net.Conn, hashedBlock HashedBlock) {
+func (node *Node) sendBlockMessage(peer *Peer, hashedBlock HashedBlock) {
 	blockMessage := BlockMessage{
 		hashedBlock,
 	}
 
-	blockMessageJSON, err := json.Marshal(blockMessage)
-	if err != nil {
-		return
-	}
-
-	message := Message{
-		MessageType: BlockMessageType,
-		MessageData: blockMessageJSON,
-	}
-
-	sendMessage(connections, message)
+	sendMessage(peer, BlockMessageType, blockMessage)
 }
 
-func (node *Node) sendResolveRequestMessage(connection net.Conn, resolveRequestMessage ResolveRequestMessage) {
-	resolveRequestMessageJSON, err := json.Marshal(resolveRequestMessage)
-	if err != nil {
-		return
-	}
-
-	message := Message{
-		MessageType: ResolveRequestMessageType,
-		MessageData: resolveRequestMessageJSON,
-	}
-
-	sendMessage(connection, message)
+func (node *Node) sendResolveRequestMessage(peer *Peer, resolveRequestMessage ResolveRequestMessage) {
+	sendMessage(peer, ResolveRequestMessageType, resolveRequestMessage)
 }
 
-func (node *Node) sendResolveResponseMessage(connection net.Conn, resolveResponseMessage ResolveResponseMessage) {
-	resolveResponseMessageJSON, err := json.Marshal(resolveResponseMessage)
-	if err != nil {
-		return
-	}
-
-	message := Message{
-		MessageType: ResolveResponseMessageType,
-		MessageData: resolveResponseMessageJSON,
-	}
-
-	sendMessage(connection, message)
+func (node *Node) sendResolveResponseMessage(peer *Peer, resolveResponseMessage ResolveResponseMessage) {
+	sendMessage(peer, ResolveResponseMessageType, resolveResponseMessage)
 }
 
-func receiveMessage(connection net.Conn) (Message, error) {
-	messageBytes, err := bufio.NewReader(connection).ReadString('\n')
-	if err != nil {
-		return Message{}, err
-	}
-
-	var message Message
-	err = json.Unmarshal([]byte(messageBytes), &message)
-	if err != nil {
-		return Message{}, err
-	}
-
-	return message, nil
+func (node *Node) sendLeaveMessage(peer *Peer) {
+	sendMessage(peer, LeaveMessageType, LeaveMessage{ID: node.id})
 }
 
-func (node *Node) receiveNeighborsMessage(neighborsMessageBytes []byte) {
+func (node *Node) receiveNeighborsMessage(message Message) error {
 
 	var neighborsMessage NodeDataMessage
-	err := json.Unmarshal(neighborsMessageBytes, &neighborsMessage)
+	err := message.decode(&neighborsMessage)
 	if err != nil {
-		return
+		return err
 	}
 
+	/*
+		The bootstrap node sends the whole list every time a node joins, so entries we already
+		have are updated too: a node that left may have come back.
+	*/
 	for id, nodeData := range neighborsMessage.Neighbors {
 
-		if _, ok := node.nodeDataMap[id]; ok {
+		if id == node.id || nodeData.PublicKey.IsZero() {
 			continue
 
-		} else {
-			node.nodeDataMap[id] = &NodeData{Address: nodeData.Address, PublicKey: nodeData.PublicKey}
+		} else if !node.learnNodeData(id, nodeData) {
+			log.Println("receiveNeighborsMessage: refusing a new public key for", id)
 		}
 	}
 
 	node.establishConnections()
+	return nil
 }
 
-func (node *Node) receiveMyInfoMessage(myInfoMessageBytes []byte) string {
+// Returns the id of the node, or "" if we didn't take its info
+func (node *Node) receiveMyInfoMessage(message Message, peer *Peer) (string, error) {
 
 	var myInfoMessage MyInfoMessage
-	err := json.Unmarshal(myInfoMessageBytes, &myInfoMessage)
+	err := message.decode(&myInfoMessage)
 	if err != nil {
-		return ""
+		return "", err
 	}
 
-	node.nodeDataMap[myInfoMessage.ID].PublicKey = myInfoMessage.PublicKey
-	node.nodeDataMap[myInfoMessage.ID].Address = myInfoMessage.Address
+	// A node only speaks for itself, under the id we gave it
+	if myInfoMessage.ID != peer.getID() {
+		log.Println("receiveMyInfoMessage:", peer.getID(), "sent the info of", myInfoMessage.ID)
+		return "", nil
+	}
+
+	peer.setCodec(chooseCodec(node.codecs, myInfoMessage.Codecs))
+
+	if !node.learnNodeData(myInfoMessage.ID, NodeData{
+		PublicKey: myInfoMessage.PublicKey,
+		Address:   myInfoMessage.Address,
+	}) {
+		log.Println("receiveMyInfoMessage: refusing a new public key for", myInfoMessage.ID)
+		return "", nil
+	}
 
-	return myInfoMessage.ID
+	return myInfoMessage.ID, nil
 }
 
-func (node *Node) receiveWelcomeMessage(welcomeMessageBytes []byte) {
+// Returns the length of the bootstrap node's chain
+func (node *Node) receiveWelcomeMessage(message Message, peer *Peer) (uint, error) {
 
 	var welcomeMessage WelcomeMessage
-	err := json.Unmarshal(welcomeMessageBytes, &welcomeMessage)
+	err := message.decode(&welcomeMessage)
 	if err != nil {
-		return
+		return 0, err
+	}
+
+	peer.setCodec(chooseCodec(node.codecs, welcomeMessage.Codecs))
+
+	// Joining nodes must agree with the bootstrap node on the chain params before accepting the genesis block
+	if node.explicitParams && !node.params.equal(welcomeMessage.Params) {
+		log.Fatalf("receiveWelcomeMessage: chain params %+v don't match the bootstrap's %+v", node.params, welcomeMessage.Params)
+	}
+	if err := welcomeMessage.Params.validate(); err != nil {
+		log.Fatal("receiveWelcomeMessage: ", err)
 	}
+	node.params = welcomeMessage.Params
+	node.paramsReceived = true
 
 	node.id = welcomeMessage.ID
 
-	node.nodeDataMap[node.id] = &NodeData{
+	node.setNodeData(node.id, NodeData{
 		PublicKey: node.publicKey,
 		Address:   node.address,
-	}
+	})
+
+	return welcomeMessage.ChainLength, nil
 }
 
-func (node *Node) receiveTransactionMessage(transactionMessageBytes []byte) SignedTransaction {
+func (node *Node) receiveTransactionMessage(message Message) (SignedTransaction, error) {
 	var transactionMessage TransactionMessage
-	err := json.Unmarshal(transactionMessageBytes, &transactionMessage)
+	err := message.decode(&transactionMessage)
 	if err != nil {
-		return SignedTransaction{}
+		return SignedTransaction{}, err
 	}
 
-	return transactionMessage.Transaction
+	return transactionMessage.Transaction, nil
 }
 
-func (node *Node) receiveBlockMessage(blockMessageBytes []byte) HashedBlock {
+func (node *Node) receiveBlockMessage(message Message) (HashedBlock, error) {
 	var blockMessage BlockMessage
-	err := json.Unmarshal(blockMessageBytes, &blockMessage)
+	err := message.decode(&blockMessage)
 	if err != nil {
-		return HashedBlock{}
+		return HashedBlock{}, err
 	}
 
-	return blockMessage.Block
+	return blockMessage.Block, nil
 }
 
-func (node *Node) receiveResolveRequestMessage(receivedResolveRequestMessageBytes []byte) ResolveResponseMessage {
+/*
+Reply with the blocks of our main chain that come after the last hash we have in common with
+the node that asks. It decides for itself whether they make a better chain than its own.
+*/
+func (node *Node) receiveResolveRequestMessage(message Message) (ResolveResponseMessage, error) {
 	var resolveRequestMessage ResolveRequestMessage
 
-	err := json.Unmarshal(receivedResolveRequestMessageBytes, &resolveRequestMessage)
+	err := message.decode(&resolveRequestMessage)
 
 	if err != nil {
-		return ResolveResponseMessage{}
+		return ResolveResponseMessage{}, err
 	}
 
 	node.blockchainLock.Lock()
+	defer node.blockchainLock.Unlock()
 
-	if resolveRequestMessage.ChainSize < uint(len(node.blockchain)) {
-		blocks := make([]HashedBlock, 0)
-
-		var i int
-		for i = range resolveRequestMessage.Hashes {
-			if resolveRequestMessage.Hashes[i] != node.blockchain[i].Hash {
-				break
-			}
+	common := 0
+	for common < len(resolveRequestMessage.Hashes) && common < len(node.blockchain) {
+		if resolveRequestMessage.Hashes[common] != node.blockchain[common].Hash {
+			break
 		}
-		for ; i < len(node.blockchain); i++ {
-			blocks = append(blocks, node.blockchain[i])
-		}
-
-		resolveResponseMessage := ResolveResponseMessage{
-			Blocks: blocks,
-		}
-
-		node.blockchainLock.Unlock()
-		return resolveResponseMessage
+		common++
 	}
 
-	node.blockchainLock.Unlock()
-	return ResolveResponseMessage{}
+	blocks := make([]HashedBlock, len(node.blockchain)-common)
+	copy(blocks, node.blockchain[common:])
+
+	return ResolveResponseMessage{
+		Blocks: blocks,
+	}, nil
 }
 
-func (node *Node) receiveResolveResponseMessage(receiveResolveResponseMessageBytes []byte) {
+func (node *Node) receiveResolveResponseMessage(message Message) error {
 	var resolveResponseMessage ResolveResponseMessage
-	err := json.Unmarshal(receiveResolveResponseMessageBytes, &resolveResponseMessage)
+	err := message.decode(&resolveResponseMessage)
 	if err != nil {
-		return
+		return err
 	}
 
-	if len(resolveResponseMessage.Blocks) == 0 {
-		return
+	node.blockchainLock.Lock()
+	defer node.blockchainLock.Unlock()
+
+	for _, block := range resolveResponseMessage.Blocks {
+		if node.validateBlock(block) {
+			log.Println("Block with index", block.Index, "validated")
+		}
+	}
+	return nil
+}
+
+func (node *Node) receiveNewConnectionMessage(message Message, peer *Peer) (string, error) {
+	var newConnectionMessage NewConnectionMessage
+	err := message.decode(&newConnectionMessage)
+	if err != nil {
+		return "", err
 	}
 
-	firstIndex := resolveResponseMessage.Blocks[0].Index
-	lastIndex := resolveResponseMessage.Blocks[len(resolveResponseMessage.Blocks)-1].Index
+	peer.setCodec(chooseCodec(node.codecs, newConnectionMessage.Codecs))
 
-	if lastIndex >= uint(len(node.blockchain)) {
-		temp := make([]HashedBlock, firstIndex)
-		copy(temp[0:firstIndex], node.blockchain)
+	return newConnectionMessage.ID, nil
+}
 
-		temp = append(temp, resolveResponseMessage.Blocks...)
+// What a node signs to prove that it holds the key of id. The id keeps the answer from being used for another one.
+func challengeDigest(id string, nonce [32]byte) []byte {
+	buffer := append([]byte("noobcash rejoin "+id+" "), nonce[:]...)
+	digest := sha256.Sum256(buffer)
+	return digest[:]
+}
 
-		node.blockchain = make([]HashedBlock, lastIndex+1)
-		copy(node.blockchain, temp)
+/*
+Only the bootstrap node may ask us to sign a challenge. Any other peer could be passing on the
+challenge it got for our id, to take our place.
+*/
+func (node *Node) receiveChallengeMessage(message Message, peer *Peer) error {
+	var challengeMessage ChallengeMessage
+	err := message.decode(&challengeMessage)
+	if err != nil {
+		return err
+	}
+	if peer.getID() != "id0" {
+		return nil
+	}
 
-		node.validateChain()
+	signature, err := node.privateKey.Sign(challengeDigest(node.id, challengeMessage.Nonce))
+	if err != nil {
+		log.Println("receiveChallengeMessage:", err)
+		return nil
 	}
 
+	sendMessage(peer, ChallengeResponseMessageType, ChallengeResponseMessage{Signature: signature})
+	peer.rejoining.Store(true)
+	return nil
 }
 
-func receiveNewConnectionMessage(newConnectionMessageBytes []byte) string {
-	var newConnectionMessage NewConnectionMessage
-	err := json.Unmarshal(newConnectionMessageBytes, &newConnectionMessage)
+// A node that leaves tells its peers, so that they stop counting on it but keep its public key
+func (node *Node) receiveLeaveMessage(message Message, peer *Peer) error {
+	var leaveMessage LeaveMessage
+	err := message.decode(&leaveMessage)
 	if err != nil {
-		return ""
+		return err
+	}
+	if leaveMessage.ID != peer.getID() {
+		return nil
 	}
 
-	return newConnectionMessage.ID
+	node.markDeparted(leaveMessage.ID)
+	log.Println("Node", leaveMessage.ID, "left the network")
+	return nil
 }
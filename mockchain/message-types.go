@@ -1,6 +1,6 @@
 package main
 
-import "crypto/rsa"
+import "noobcash/signature"
 
 type MessageType int
 
@@ -14,18 +14,28 @@ const (
 	BlockMessageType
 	ResolveRequestMessageType
 	ResolveResponseMessageType
+	LeaveMessageType
+	PingMessageType
+	PongMessageType
+	RejectMessageType
+	ChallengeMessageType
+	ChallengeResponseMessageType
 )
 
 type NullMessage struct{}
 
 type WelcomeMessage struct {
-	ID string
+	ID          string
+	Params      ChainParams
+	Codecs      []Codec
+	ChainLength uint // blocks the bootstrap node has, a node joining late has to fetch them
 }
 
 type MyInfoMessage struct {
 	ID        string
-	PublicKey rsa.PublicKey
+	PublicKey signature.PublicKey
 	Address   string
+	Codecs    []Codec
 }
 
 type NodeDataMessage struct {
@@ -33,7 +43,8 @@ type NodeDataMessage struct {
 }
 
 type NewConnectionMessage struct {
-	ID string
+	ID     string
+	Codecs []Codec
 }
 
 type TransactionMessage struct {
@@ -53,7 +64,38 @@ type ResolveResponseMessage struct {
 	Blocks []HashedBlock
 }
 
+type LeaveMessage struct {
+	ID string
+}
+
+// A pong carries the time of the ping it answers, so the sender can measure the round trip
+type PingMessage struct {
+	Time int64
+}
+
+type PongMessage struct {
+	Time int64
+}
+
+// Tells the peer that sent us a transaction why we didn't accept it
+type RejectMessage struct {
+	TransactionID [32]byte
+	Code          RejectCode
+	Reason        string
+}
+
+// The bootstrap node asks a node that rejoins with an old id to sign a nonce with the key of the id
+type ChallengeMessage struct {
+	Nonce [32]byte
+}
+
+type ChallengeResponseMessage struct {
+	Signature []byte
+}
+
 type Message struct {
 	MessageType MessageType
 	MessageData []byte
+
+	codec Codec // codec of MessageData, taken from the frame the message arrived in
 }
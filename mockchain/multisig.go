@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"noobcash/signature"
+)
+
+/*
+A multisig address holds coins that can only be spent with the approval of Required of the keys
+of a MultisigPolicy, for a treasury shared by several nodes. The keys are kept sorted by their
+canonical encoding, so the same keys and threshold always give the same address, whatever order
+the cosigners list them in.
+
+A transaction that spends from a multisig address carries the policy in place of a sender key,
+and instead of one signature it has the Cosignatures of the keys that approved it. Every input
+must be paid to the address of the policy, and the change goes back to it. The cosigners pass
+the transaction around as a file until enough of them have signed (see psbt.go).
+*/
+const maxMultisigKeys = 15
+
+type MultisigPolicy struct {
+	Required   uint32
+	PublicKeys []signature.PublicKey
+}
+
+type Cosignature struct {
+	KeyIndex  uint32 // which key of the policy made the signature
+	Signature []byte
+}
+
+func newMultisigPolicy(required uint32, publicKeys []signature.PublicKey) (MultisigPolicy, error) {
+	sorted := make([]signature.PublicKey, len(publicKeys))
+	copy(sorted, publicKeys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(appendPublicKey(nil, sorted[i]), appendPublicKey(nil, sorted[j])) < 0
+	})
+
+	policy := MultisigPolicy{Required: required, PublicKeys: sorted}
+	return policy, policy.validate()
+}
+
+// The number of keys is in range, they are distinct and sorted, and Required of them can be found
+func (policy *MultisigPolicy) validate() error {
+	if len(policy.PublicKeys) == 0 || len(policy.PublicKeys) > maxMultisigKeys {
+		return fmt.Errorf("a multisig needs between 1 and %v keys", maxMultisigKeys)
+	}
+	if policy.Required == 0 || policy.Required > uint32(len(policy.PublicKeys)) {
+		return fmt.Errorf("a multisig of %v keys needs between 1 and %v signatures", len(policy.PublicKeys), len(policy.PublicKeys))
+	}
+
+	for i, publicKey := range policy.PublicKeys {
+		if publicKey.IsZero() {
+			return errors.New("a multisig key is missing")
+		}
+		if i > 0 && bytes.Compare(appendPublicKey(nil, policy.PublicKeys[i-1]), appendPublicKey(nil, publicKey)) >= 0 {
+			return errors.New("the multisig keys must be distinct and sorted")
+		}
+	}
+	return nil
+}
+
+func (policy *MultisigPolicy) address() Address {
+	return hashAddress(MultisigAddress, appendMultisigPolicy(nil, *policy))
+}
+
+// The index of the key in the policy, or -1 if it isn't one of them
+func (policy *MultisigPolicy) keyIndex(publicKey signature.PublicKey) int {
+	for i, policyKey := range policy.PublicKeys {
+		if policyKey.Equal(publicKey) {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+Check the cosignatures of a transaction that spends from the policy: every one must be a valid
+signature of the TransactionID by a different key of the policy, and there must be at least
+Required of them. The keys that signed are returned, for the caller to check who they are.
+*/
+func (policy *MultisigPolicy) verifyCosignatures(transactionID [32]byte, cosignatures []Cosignature) ([]signature.PublicKey, error) {
+	signers := make([]signature.PublicKey, 0, len(cosignatures))
+	signed := make(map[uint32]bool, len(cosignatures))
+
+	for _, cosignature := range cosignatures {
+		if cosignature.KeyIndex >= uint32(len(policy.PublicKeys)) || signed[cosignature.KeyIndex] {
+			return nil, fmt.Errorf("cosignature of key %v is out of range or repeated", cosignature.KeyIndex)
+		}
+		signed[cosignature.KeyIndex] = true
+
+		publicKey := policy.PublicKeys[cosignature.KeyIndex]
+		if !publicKey.Verify(transactionID[:], cosignature.Signature) {
+			return nil, fmt.Errorf("cosignature of key %v is invalid", cosignature.KeyIndex)
+		}
+		signers = append(signers, publicKey)
+	}
+
+	if uint32(len(signers)) < policy.Required {
+		return nil, fmt.Errorf("%v of the %v cosignatures needed", len(signers), policy.Required)
+	}
+	return signers, nil
+}
+
+// The checks of checkTransaction that take the place of the sender's signature for a multisig spend
+func (node *Node) checkCosignatures(signedTransaction SignedTransaction) *RejectError {
+	if !signedTransaction.SenderAddress.IsZero() || len(signedTransaction.Signature) != 0 {
+		return &RejectError{RejectMalformed, "a multisig spend has no sender key or signature of its own"}
+	}
+	if err := signedTransaction.Multisig.validate(); err != nil {
+		return &RejectError{RejectMalformed, err.Error()}
+	}
+
+	// The ids must be the ones derived from the body, otherwise they could be changed after signing
+	transaction := signedTransaction.unsigned()
+	if !transaction.idsValid() {
+		return &RejectError{RejectInvalidSignature, "invalid signature"}
+	}
+
+	signers, err := signedTransaction.Multisig.verifyCosignatures(transaction.TransactionID, signedTransaction.Cosignatures)
+	if err != nil {
+		return &RejectError{RejectInvalidSignature, err.Error()}
+	}
+
+	for _, signer := range signers {
+		if node.getId(signer) == "" {
+			return &RejectError{RejectUnknownNode, "a cosigner is not a node of the network"}
+		}
+	}
+	return nil
+}
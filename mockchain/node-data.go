@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+
+	"noobcash/signature"
+)
+
+/*
+Nodes can join and leave while the network is running, so nodeDataMap is written by the
+goroutines that handle messages while others are reading it. All access goes through these
+helpers, which hand out copies of the entries.
+
+A node that has left is kept in the map with Departed set. Its public key is still needed to
+validate the transactions it took part in, and its id is never given to anybody else.
+*/
+
+func (node *Node) getNodeData(id string) (NodeData, bool) {
+	node.nodeDataLock.Lock()
+	defer node.nodeDataLock.Unlock()
+
+	nodeData, ok := node.nodeDataMap[id]
+	if !ok {
+		return NodeData{}, false
+	}
+	return *nodeData, true
+}
+
+func (node *Node) setNodeData(id string, nodeData NodeData) {
+	node.nodeDataLock.Lock()
+	defer node.nodeDataLock.Unlock()
+
+	node.putNodeData(id, nodeData)
+}
+
+/*
+Take what another node tells us about a node. Once we know the public key of an id, nobody gets
+to change it: the coins of the id are locked to it. Returns false if the key was refused.
+*/
+func (node *Node) learnNodeData(id string, nodeData NodeData) bool {
+	node.nodeDataLock.Lock()
+	defer node.nodeDataLock.Unlock()
+
+	if known, ok := node.nodeDataMap[id]; ok && !known.PublicKey.IsZero() && !known.PublicKey.Equal(nodeData.PublicKey) {
+		return false
+	}
+	node.putNodeData(id, nodeData)
+	return true
+}
+
+// The caller must hold nodeDataLock. An address stops naming the id once the id has another key.
+func (node *Node) putNodeData(id string, nodeData NodeData) {
+	if known, ok := node.nodeDataMap[id]; ok && !known.PublicKey.IsZero() && !known.PublicKey.Equal(nodeData.PublicKey) {
+		if address := addressOf(known.PublicKey); node.idByAddress[address] == id {
+			delete(node.idByAddress, address)
+		}
+	}
+
+	node.nodeDataMap[id] = &nodeData
+	if !nodeData.PublicKey.IsZero() {
+		node.idByAddress[addressOf(nodeData.PublicKey)] = id
+	}
+}
+
+// Reserve an id for a node we have welcomed but whose public key we don't know yet
+func (node *Node) reserveNodeID(id string) {
+	node.nodeDataLock.Lock()
+	defer node.nodeDataLock.Unlock()
+
+	if _, ok := node.nodeDataMap[id]; !ok {
+		node.nodeDataMap[id] = new(NodeData)
+	}
+}
+
+func (node *Node) markDeparted(id string) {
+	node.nodeDataLock.Lock()
+	defer node.nodeDataLock.Unlock()
+
+	if nodeData, ok := node.nodeDataMap[id]; ok {
+		nodeData.Departed = true
+	}
+}
+
+func (node *Node) hasDeparted(id string) bool {
+	nodeData, _ := node.getNodeData(id)
+	return nodeData.Departed
+}
+
+// A copy of nodeDataMap, safe to range over while nodes come and go
+func (node *Node) nodeDataSnapshot() map[string]NodeData {
+	node.nodeDataLock.Lock()
+	defer node.nodeDataLock.Unlock()
+
+	snapshot := make(map[string]NodeData, len(node.nodeDataMap))
+	for id, nodeData := range node.nodeDataMap {
+		snapshot[id] = *nodeData
+	}
+	return snapshot
+}
+
+func (node *Node) nodeCount() int {
+	node.nodeDataLock.Lock()
+	defer node.nodeDataLock.Unlock()
+
+	return len(node.nodeDataMap)
+}
+
+// The ids of all the nodes we know of, in the order they joined
+func (node *Node) nodeIDs() []string {
+	node.nodeDataLock.Lock()
+	ids := make([]string, 0, len(node.nodeDataMap))
+	for id := range node.nodeDataMap {
+		ids = append(ids, id)
+	}
+	node.nodeDataLock.Unlock()
+
+	sort.Slice(ids, func(i, j int) bool {
+		return idNumber(ids[i]) < idNumber(ids[j])
+	})
+	return ids
+}
+
+// The number in an id of the form "idK", or -1 for anything else
+func idNumber(id string) int {
+	if len(id) < 3 || id[:2] != "id" {
+		return -1
+	}
+	number, err := strconv.Atoi(id[2:])
+	if err != nil {
+		return -1
+	}
+	return number
+}
+
+// The address of the node with the given id, once we know its public key
+func (node *Node) addressOfID(id string) (Address, bool) {
+	nodeData, ok := node.getNodeData(id)
+	if !ok || nodeData.PublicKey.IsZero() {
+		return Address{}, false
+	}
+	return addressOf(nodeData.PublicKey), true
+}
+
+func (node *Node) getId(publicKey signature.PublicKey) string {
+	if publicKey.IsZero() {
+		return ""
+	}
+	return node.idOfAddress(addressOf(publicKey))
+}
+
+// The id of the node an address belongs to, or "" if it isn't the address of a node we know
+func (node *Node) idOfAddress(address Address) string {
+	node.nodeDataLock.Lock()
+	defer node.nodeDataLock.Unlock()
+
+	return node.idByAddress[address]
+}
+
+// How an address is shown: the id of its node if it has one, the address itself otherwise
+func (node *Node) addressName(address Address) string {
+	if id := node.idOfAddress(address); id != "" {
+		return id
+	}
+	return address.String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"noobcash/signature"
+)
+
+func testPublicKey(t *testing.T) signature.PublicKey {
+	privateKey, err := signature.GenerateKey(signature.Ed25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey.Public()
+}
+
+// Another node can tell us where a node is, but not give its id another key
+func TestNodeDataKeepsKnownKey(t *testing.T) {
+	var node Node
+	node.nodeDataMap = make(map[string]*NodeData)
+	node.idByAddress = make(map[Address]string)
+
+	node.reserveNodeID("id1")
+	publicKey := testPublicKey(t)
+	if !node.learnNodeData("id1", NodeData{PublicKey: publicKey, Address: "127.0.0.1:5001"}) {
+		t.Fatal("the key of a reserved id was refused")
+	}
+	if !node.learnNodeData("id1", NodeData{PublicKey: publicKey, Address: "127.0.0.1:5002"}) {
+		t.Fatal("the same key with a new address was refused")
+	}
+
+	impostor := testPublicKey(t)
+	if node.learnNodeData("id1", NodeData{PublicKey: impostor, Address: "127.0.0.1:6000"}) {
+		t.Fatal("the known key of id1 was replaced")
+	}
+	if nodeData, _ := node.getNodeData("id1"); !nodeData.PublicKey.Equal(publicKey) || nodeData.Address != "127.0.0.1:5002" {
+		t.Errorf("id1 is %+v", nodeData)
+	}
+	if id := node.getId(impostor); id != "" {
+		t.Errorf("the refused key belongs to %v", id)
+	}
+
+	// Setting the key ourselves, the old address stops naming the id
+	node.setNodeData("id1", NodeData{PublicKey: impostor})
+	if id := node.getId(publicKey); id != "" {
+		t.Errorf("the old key still belongs to %v", id)
+	}
+	if id := node.getId(impostor); id != "id1" {
+		t.Errorf("the new key belongs to %q", id)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"noobcash/signature"
+)
+
+/*
+Everything besides the blocks that a node needs to pick up where it left off: its id, the chain
+params it agreed on, the public keys of the other nodes (needed to validate the stored
+transactions again) and the multisig addresses it keeps track of.
+*/
+type NodeState struct {
+	ID        string
+	Params    ChainParams
+	Neighbors map[string]NodeData
+	Multisigs []MultisigPolicy
+}
+
+// The key of a data directory made before there were signature schemes is an RSA key, and stays one
+func (node *Node) loadWallet(scheme signature.Scheme) error {
+	path := filepath.Join(node.dataDir, "wallet.pem")
+
+	walletPEM, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		node.generateWallet(scheme)
+		return os.WriteFile(path, node.privateKey.MarshalPEM(), 0600)
+
+	} else if err != nil {
+		return err
+	}
+
+	privateKey, err := signature.ParsePEM(walletPEM)
+	if err != nil {
+		return fmt.Errorf("loadWallet: %v: %w", path, err)
+	}
+
+	node.setWalletKey(privateKey)
+	return nil
+}
+
+func (node *Node) saveNodeState() error {
+	state := NodeState{
+		ID:        node.id,
+		Params:    node.params,
+		Neighbors: make(map[string]NodeData),
+	}
+	for id, nodeData := range node.nodeDataSnapshot() {
+		// Nodes we have welcomed but haven't told us their key yet
+		if nodeData.PublicKey.IsZero() {
+			continue
+		}
+		state.Neighbors[id] = nodeData
+	}
+	state.Multisigs = node.multisigPolicies()
+
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so that a crash never leaves a half written state behind
+	path := filepath.Join(node.dataDir, "node.json")
+	if err := os.WriteFile(path+".tmp", stateJSON, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+/*
+Restore the node from its data directory. The stored blocks are replayed through validateBlock,
+which rebuilds UTXOsCommitted exactly the way it was built when the blocks were first received.
+*/
+func (node *Node) loadNodeState(scheme signature.Scheme) error {
+	if err := os.MkdirAll(node.dataDir, 0700); err != nil {
+		return err
+	}
+
+	// A key file given with -wallet takes the place of the data directory's key
+	if node.publicKey.IsZero() {
+		if err := node.loadWallet(scheme); err != nil {
+			return err
+		}
+	}
+
+	store, err := openBlockStore(node.dataDir)
+	if err != nil {
+		return err
+	}
+	node.blockStore = store
+
+	stateJSON, err := os.ReadFile(filepath.Join(node.dataDir, "node.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		// First run with this data directory, nothing to restore
+		return store.Truncate(0)
+	} else if err != nil {
+		return err
+	}
+
+	var state NodeState
+	if err := json.Unmarshal(stateJSON, &state); err != nil {
+		return err
+	}
+
+	if node.explicitParams && !node.params.equal(state.Params) {
+		return errors.New("loadNodeState: chain params don't match the ones stored in " + node.dataDir)
+	}
+
+	node.id = state.ID
+	node.params = state.Params
+	node.paramsReceived = true
+
+	for id, nodeData := range state.Neighbors {
+		node.setNodeData(id, nodeData)
+	}
+	node.setNodeData(node.id, NodeData{PublicKey: node.publicKey, Address: node.address})
+
+	for _, policy := range state.Multisigs {
+		node.multisigs[policy.address()] = policy
+	}
+
+	blocks, err := store.Load()
+	if err != nil {
+		log.Println("loadNodeState: Load->", err)
+	}
+
+	for _, block := range blocks {
+		if !node.validateBlock(block) {
+			log.Println("loadNodeState: stored block", block.Index, "is not valid, dropping the rest of the chain")
+			break
+		}
+	}
+	if err := store.Truncate(len(node.blockchain)); err != nil {
+		return err
+	}
+
+	node.rebuildWallet()
+
+	log.Println("loadNodeState: restored", len(node.blockchain), "blocks as", node.id)
+	return nil
+}
+
+/*
+Write a block that was just added to the blockchain to the block store. When the chain is
+validated again from scratch the same blocks come through here a second time, so we only
+write the ones that differ from what is already stored.
+*/
+func (node *Node) persistBlock(block HashedBlock) {
+	if node.blockStore == nil {
+		return
+	}
+
+	if hash, ok := node.blockStore.Hash(int(block.Index)); ok {
+		if hash == block.Hash {
+			return
+		}
+		if err := node.blockStore.Truncate(int(block.Index)); err != nil {
+			log.Println("persistBlock: Truncate->", err)
+			return
+		}
+	}
+
+	if err := node.blockStore.Append(block); err != nil {
+		log.Println("persistBlock: Append->", err)
+		return
+	}
+
+	if err := node.saveNodeState(); err != nil {
+		log.Println("persistBlock: saveNodeState->", err)
+	}
+}
+
+/*
+Our own unspent outputs, taken from the committed UTXOs after replaying the chain or switching to
+another branch, less the coins our transactions in the mempool spend and plus their change. The
+change goes in before any coins are taken out, since a transaction of ours can spend the change of
+one that came back to the mempool after it.
+*/
+func (node *Node) rebuildWallet() {
+	wallet := NewStack()
+	for _, UTXO := range node.UTXOsCommitted.OutputsOf(node.walletAddress) {
+		if UTXO.Amount > 0 {
+			wallet.Push(UTXO)
+		}
+	}
+
+	pending := make([]SignedTransaction, 0)
+	for _, transaction := range node.mempool.Transactions() {
+		if node.publicKey.Equal(transaction.SenderAddress) {
+			pending = append(pending, transaction)
+		}
+	}
+	for _, transaction := range pending {
+		for _, transactionOutput := range transaction.TransactionOutputs {
+			if transactionOutput.RecipientAddress == node.walletAddress && !wallet.Contains(transactionOutput.ID) {
+				wallet.Push(transactionOutput)
+			}
+		}
+	}
+	for _, transaction := range pending {
+		for _, transactionInput := range transaction.TransactionInputs {
+			wallet.Remove(transactionInput.PreviousOutputID)
+		}
+	}
+
+	node.myUTXOs.Copy(wallet)
+}
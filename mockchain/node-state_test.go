@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+// A bootstrap node with a data directory and a chain of only the genesis block
+func testBootstrapNode(t *testing.T) (Config, *Node) {
+	config := defaultConfig()
+	config.DataDir = t.TempDir()
+	config.Params.NumNodes = 2
+	config.Params.Difficulty = 0
+	config.Params.Genesis = map[string]uint{"id0": 200}
+
+	node := &Node{}
+	node.createNode(config, true)
+	node.id = "id0"
+	node.paramsReceived = true
+	node.setNodeData(node.id, NodeData{PublicKey: node.publicKey, Address: node.address})
+
+	acceptBlock(t, node, node.createGenesisBlock())
+	return config, node
+}
+
+// The same with one mined block on top of the genesis block
+func testNodeWithChain(t *testing.T) (Config, *Node) {
+	config, node := testBootstrapNode(t)
+	acceptBlock(t, node, node.mineBlock(nil, 1, node.blockchain[0].Hash))
+	return config, node
+}
+
+func acceptBlock(t *testing.T, node *Node, block HashedBlock) {
+	node.blockchainLock.Lock()
+	valid := node.validateBlock(block)
+	node.blockchainLock.Unlock()
+	if !valid {
+		t.Fatalf("block %v is not valid", block.Index)
+	}
+}
+
+func walletTotal(node *Node) uint {
+	total := uint(0)
+	for _, UTXO := range node.myUTXOs.All() {
+		total += UTXO.Amount
+	}
+	return total
+}
+
+// A restarted node finds its coins again in the chain it replays from its data directory
+func TestRestartKeepsWallet(t *testing.T) {
+	config, node := testNodeWithChain(t)
+	balance := walletTotal(node)
+	if balance != 200+config.Params.BlockReward {
+		t.Fatalf("the wallet has %v coins before the restart", balance)
+	}
+	node.blockStore.Close()
+
+	var restarted Node
+	restarted.createNode(config, true)
+	defer restarted.blockStore.Close()
+
+	if len(restarted.blockchain) != 2 {
+		t.Fatalf("restored %v blocks", len(restarted.blockchain))
+	}
+	if restarted.walletAddress != node.walletAddress {
+		t.Fatal("the restarted node has another wallet address")
+	}
+	if got := walletTotal(&restarted); got != balance {
+		t.Errorf("the wallet has %v coins after the restart, %v before", got, balance)
+	}
+}
@@ -1,44 +1,63 @@
 package main
 
 import (
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"math/big"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"noobcash/keystore"
+	"noobcash/signature"
 )
 
 type Node struct {
 	// Node Data
-	id         string
-	privateKey rsa.PrivateKey
-	publicKey  rsa.PublicKey
-	address    string
-
-	nodeDataMap   map[string]*NodeData
-	connectionMap map[string]net.Conn
-
-	blockchain     []HashedBlock
+	id            string
+	privateKey    *signature.PrivateKey
+	publicKey     signature.PublicKey
+	walletAddress Address // the address of publicKey, where our coins are paid
+	address       string
+
+	params         ChainParams
+	explicitParams bool // params were given by the user and must match the bootstrap's
+	paramsReceived bool // params have been agreed on with the bootstrap node
+
+	nodeDataMap    map[string]*NodeData
+	idByAddress    map[Address]string // the id of the node each address belongs to
+	nodeDataLock   sync.Mutex
+	connectionMap  map[string]*Peer
+	dialing        map[string]bool // connections we are opening, not in connectionMap yet
+	connectionLock sync.Mutex
+	leaving        atomic.Bool // we are leaving the network, so lost connections aren't dialed again
+	codecs         []Codec     // message codecs we support, most preferred first
+
+	dataDir    string
+	blockStore *BlockStore
+
+	apiToken string // what clients of the API must send to spend our coins, empty to not let them
+
+	blockchain     []HashedBlock // the main chain of blockTree
+	blockTree      *BlockTree
 	blockchainLock sync.Mutex
+	chainGrown     *sync.Cond // signalled on blockchainLock whenever a block joins the main chain
 	mineLock       sync.Mutex
 
-	resolvingConflict bool
+	UTXOsSoftValidated *UTXOSet
+	UTXOsCommitted     *UTXOSet
+
+	myUTXOs      UTXOStack
+	coinSelector CoinSelector // how the coins to spend are picked, guarded by mineLock
 
-	UTXOsValidated     map[[32]byte]TransactionOutput
-	UTXOsSoftValidated map[[32]byte]TransactionOutput
-	UTXOsCommitted     map[[32]byte]TransactionOutput
+	multisigs    map[Address]MultisigPolicy // the multisig addresses we keep track of
+	multisigLock sync.Mutex
 
-	myUTXOs UTXOStack
+	mempool           *Mempool
+	chainTransactions map[[32]byte]uint // ids of the transactions on the main chain, with the Index of their block
 
-	transactionQueue TransactionQueue
-	usedTransactions map[[32]byte]bool
+	events *EventHub // what changed, for the event stream
 
 	broadcast             chan bool
 	broadcastLock         sync.Mutex
@@ -49,45 +68,77 @@ type Node struct {
 }
 
 type NodeData struct {
-	PublicKey rsa.PublicKey
+	PublicKey signature.PublicKey
 	Address   string
+	Departed  bool // the node has left the network
 }
 
-func (node *Node) createNode(localAddress string) {
+func (node *Node) createNode(config Config, explicitParams bool) {
 
-	node.generateWallet()
-	node.address = localAddress
+	node.address = config.LocalAddress
+	node.dataDir = config.DataDir
+	node.apiToken = config.APIToken
+
+	node.params = config.Params
+	node.explicitParams = explicitParams
 
 	node.nodeDataMap = make(map[string]*NodeData)
-	node.connectionMap = make(map[string]net.Conn)
+	node.idByAddress = make(map[Address]string)
+	node.connectionMap = make(map[string]*Peer)
+	node.dialing = make(map[string]bool)
+
+	preferredCodec, _ := parseCodec(config.Codec)
+	node.codecs = supportedCodecs(preferredCodec)
 
 	node.blockchain = make([]HashedBlock, 0)
+	node.blockTree = newBlockTree()
 	node.blockchainLock = sync.Mutex{}
 	node.mineLock = sync.Mutex{}
 
-	node.resolvingConflict = false
-
-	node.UTXOsValidated = make(map[[32]byte]TransactionOutput)
-	node.UTXOsSoftValidated = make(map[[32]byte]TransactionOutput)
-	node.UTXOsCommitted = make(map[[32]byte]TransactionOutput)
+	node.UTXOsSoftValidated = NewUTXOSet()
+	node.UTXOsCommitted = NewUTXOSet()
 
 	node.myUTXOs = *NewStack()
+	node.coinSelector, _ = parseCoinSelector(config.CoinSelection)
+	node.multisigs = make(map[Address]MultisigPolicy)
 
-	node.transactionQueue = *NewTransactionQueue()
-	node.usedTransactions = make(map[[32]byte]bool)
+	node.mempool = NewMempool(maxMempoolSize)
+	node.chainTransactions = make(map[[32]byte]uint)
+	node.events = NewEventHub()
+	node.chainGrown = sync.NewCond(&node.blockchainLock)
 
 	node.broadcastLock = sync.Mutex{}
 	node.broadcast = make(chan bool)
+
+	if config.Wallet != "" {
+		privateKey, err := keystore.LoadFile(config.Wallet, readPassphrase("Passphrase for "+config.Wallet+": "))
+		if err != nil {
+			log.Fatal("createNode: ", err)
+		}
+		node.setWalletKey(privateKey)
+	}
+
+	// The scheme of a new key, an existing one keeps its own
+	scheme, _ := signature.ParseScheme(config.SignatureScheme)
+
+	if node.dataDir == "" {
+		if config.Wallet == "" {
+			node.generateWallet(scheme)
+		}
+	} else if err := node.loadNodeState(scheme); err != nil {
+		log.Fatal("createNode: ", err)
+	}
 }
 
 func (node *Node) startBootstrap(localAddress string) {
-	node.nodeDataMap[node.id] = &NodeData{
+	node.setNodeData(node.id, NodeData{
 		PublicKey: node.publicKey,
 		Address:   node.address,
-	}
+	})
 	listener, _ := net.Listen("tcp", localAddress)
 	defer listener.Close()
 	go node.acceptConnectionsBootstrap(listener)
+	go node.heartbeat()
 
 	node.broadcastMessages()
 }
@@ -96,37 +147,42 @@ func (node *Node) startOrdinaryNode(localAddress string) {
 	listener, _ := net.Listen("tcp", localAddress)
 	defer listener.Close()
 	go node.acceptConnections(listener)
+	go node.heartbeat()
 	node.broadcastMessages()
 }
 
 func (node *Node) createGenesisBlock() HashedBlock {
-	var transactionID, bootstrapTransactionOutputID, magicTransactionOutputID [32]byte
+	transactions := make([]SignedTransaction, 0, len(node.params.Genesis))
 
-	transactionID = generateRandom32Byte()
-	bootstrapTransactionOutputID = generateRandom32Byte()
-	magicTransactionOutputID = generateRandom32Byte()
+	// One transaction for every node id in the genesis allocation of the chain params
+	for _, id := range node.params.genesisIDs() {
+		amount := node.params.Genesis[id]
 
-	bootstrapTransactionOutput := TransactionOutput{
-		ID:               bootstrapTransactionOutputID,
-		TransactionID:    transactionID,
-		RecipientAddress: node.publicKey,
-		Amount:           100 * NUM_NODES,
-	}
-	magicTransactionOutput := TransactionOutput{
-		ID:               magicTransactionOutputID,
-		TransactionID:    transactionID,
-		RecipientAddress: rsa.PublicKey{N: big.NewInt(0), E: 1},
-		Amount:           0,
-	}
+		// The public key of a node is only known once its MyInfoMessage has arrived
+		nodeData, _ := node.getNodeData(id)
+		for nodeData.PublicKey.IsZero() {
+			time.Sleep(time.Millisecond * 100)
+			nodeData, _ = node.getNodeData(id)
+		}
+		recipientTransactionOutput := TransactionOutput{
+			RecipientAddress: addressOf(nodeData.PublicKey),
+			Amount:           amount,
+		}
 
-	transaction := SignedTransaction{
-		SenderAddress:      rsa.PublicKey{N: big.NewInt(0), E: 1},
-		ReceiverAddress:    node.publicKey,
-		Amount:             100 * NUM_NODES,
-		TransactionID:      transactionID,
-		TransactionInputs:  []TransactionInput{},
-		TransactionOutputs: [2]TransactionOutput{bootstrapTransactionOutput, magicTransactionOutput},
-		Signature:          []byte{0},
+		transaction := Transaction{
+			SenderAddress:      zeroPublicKey(),
+			TransactionInputs:  []TransactionInput{},
+			TransactionOutputs: []TransactionOutput{recipientTransactionOutput},
+		}
+		transaction.setIDs()
+
+		transactions = append(transactions, SignedTransaction{
+			SenderAddress:      transaction.SenderAddress,
+			TransactionID:      transaction.TransactionID,
+			TransactionInputs:  transaction.TransactionInputs,
+			TransactionOutputs: transaction.TransactionOutputs,
+			Signature:          []byte{0},
+		})
 	}
 
 	var nonce, previousHash [32]byte
@@ -138,138 +194,166 @@ func (node *Node) createGenesisBlock() HashedBlock {
 	previousHash[31] = 1
 
 	block := Block{
-		Index:        0,
-		Timestamp:    time.Now().Unix(),
-		PreviousHash: previousHash,
-		Transactions: [CAPACITY]SignedTransaction{transaction},
-		Nonce:        nonce,
+		BlockHeader: BlockHeader{
+			Index:        0,
+			Timestamp:    time.Now().UnixMilli(),
+			PreviousHash: previousHash,
+			MerkleRoot:   merkleRoot(transactions),
+			WitnessRoot:  witnessRoot(transactions),
+			Nonce:        nonce,
+			Target:       targetFromDifficulty(node.params.Difficulty),
+		},
+		Transactions: transactions,
 	}
 
-	blockJSON, _ := json.Marshal(block)
-
-	hash := sha256.Sum256(blockJSON)
+	hash := block.BlockHeader.hash()
 
 	hashedBlock := HashedBlock{
-		Index:        block.Index,
-		Timestamp:    block.Timestamp,
-		PreviousHash: block.PreviousHash,
+		BlockHeader:  block.BlockHeader,
 		Transactions: block.Transactions,
-		Nonce:        block.Nonce,
 		Hash:         hash,
 	}
 
 	return hashedBlock
 }
 
-func (node *Node) generateWallet() {
-	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
-	node.privateKey = *privateKey
-	node.publicKey = privateKey.PublicKey
+func (node *Node) generateWallet(scheme signature.Scheme) {
+	privateKey, err := signature.GenerateKey(scheme)
+	if err != nil {
+		log.Fatal("generateWallet: ", err)
+	}
+	node.setWalletKey(privateKey)
 }
 
-func (node *Node) createTransaction(receiverID string, amount uint) (Transaction, error) {
-
-	if amount <= 0 {
-		return Transaction{}, errors.New("InvalidTransactionAmount")
-	}
-	if _, ok := node.nodeDataMap[receiverID]; !ok {
-		return Transaction{}, errors.New("InvalidReceiverID")
-	}
+/*
+The wallet address has to be known as soon as the key is, since replaying the stored chain
+already picks our coins out of the UTXOs by it.
+*/
+func (node *Node) setWalletKey(privateKey *signature.PrivateKey) {
+	node.privateKey = privateKey
+	node.publicKey = privateKey.Public()
+	node.walletAddress = addressOf(node.publicKey)
+}
 
-	var totalCredits uint = 0
-	var UTXOs []TransactionOutput
+// One of the payments of a transaction
+type Payment struct {
+	Address       Address
+	Amount        uint
+	LockingScript []byte // for a payment to a script address, the script
+}
 
-	/*
-		We need to go over our wallet to see if we have enough funds to do the transaction. If not, we have to
-		return an empty transaction and re-add the UTXOs that we have checked so far.
-	*/
-	for totalCredits < amount {
-		UTXO, err := node.myUTXOs.Pop()
+// The outputs of the payments of a transaction from the given address, and what they add up to
+func (node *Node) paymentOutputs(payments []Payment, from Address) ([]TransactionOutput, uint, error) {
+	var totalAmount uint = 0
+	transactionOutputs := make([]TransactionOutput, 0, len(payments)+1)
 
-		if err != nil {
-			log.Println("createTransaction: Funds not enough to create transaction with", amount, "to", receiverID)
-			// On god mhn ksexasete pote to _, efaga 25 lepta debugging giati nomiza oti h Pop() epestrefe int
-			// enw htan to index gia to range. Mia xara htan h C, hkseran ti ekanan 50 xronia twra
-			for _, UTXO := range UTXOs {
-				node.myUTXOs.Push(UTXO)
-			}
-			return Transaction{}, errors.New("not enough coins")
+	for _, payment := range payments {
+		if payment.Amount <= 0 {
+			return nil, 0, errors.New("InvalidTransactionAmount")
+		}
+		if payment.Address == from {
+			return nil, 0, errors.New("InvalidReceiverAddress")
+		}
+		// Coins sent to a node that has left would be stuck until it comes back
+		if id := node.idOfAddress(payment.Address); id != "" && node.hasDeparted(id) {
+			log.Println("paymentOutputs:", id, "has left the network")
+			return nil, 0, errors.New("ReceiverDeparted")
 		}
 
-		totalCredits += UTXO.Amount
-		UTXOs = append(UTXOs, UTXO)
+		totalAmount += payment.Amount
+		transactionOutputs = append(transactionOutputs, TransactionOutput{
+			RecipientAddress: payment.Address,
+			Amount:           payment.Amount,
+			LockingScript:    payment.LockingScript,
+		})
 	}
 
-	recipientPublicKey := node.nodeDataMap[receiverID].PublicKey
+	return transactionOutputs, totalAmount, nil
+}
 
-	var transactionID, senderTransactionOutput, receiverTransactionOutput [32]byte
+/*
+Create a transaction that makes the payments and leaves fee coins to the miner of the block it
+ends up in. The coins to spend are picked by coinSelector, and the change goes back to us as the
+last output. Without payments, the transaction only moves the picked coins into one output.
+*/
+func (node *Node) createTransaction(payments []Payment, fee uint, coinSelector CoinSelector) (Transaction, error) {
 
-	transactionID = generateRandom32Byte()
-	senderTransactionOutput = generateRandom32Byte()
-	receiverTransactionOutput = generateRandom32Byte()
+	transactionOutputs, totalAmount, err := node.paymentOutputs(payments, node.walletAddress)
+	if err != nil {
+		return Transaction{}, err
+	}
 
+	/*
+		We need to go over our wallet to see if we have enough funds to do the transaction. The coins
+		only leave the wallet once the transaction is admitted to the mempool (see walletApply), so
+		nothing has to be put back if it can't be made or is turned away.
+	*/
+	UTXOs, err := coinSelector.Select(node.myUTXOs.All(), totalAmount+fee)
+	if err != nil {
+		log.Println("createTransaction:", coinSelector.Name(), "couldn't pick coins for", totalAmount, "and fee", fee, "-", err)
+		return Transaction{}, err
+	}
+
+	var totalCredits uint = 0
 	var transactionInputs []TransactionInput
 	for _, UTXO := range UTXOs {
-		transactionInputs = append(transactionInputs, TransactionInput{UTXO.ID})
+		totalCredits += UTXO.Amount
+		transactionInputs = append(transactionInputs, TransactionInput{PreviousOutputID: UTXO.ID})
 	}
 
-	transactionOutputs := [2]TransactionOutput{
-		{
-			ID:               senderTransactionOutput,
-			TransactionID:    transactionID,
-			RecipientAddress: node.publicKey,
-			Amount:           totalCredits - amount},
-		{
-			ID:               receiverTransactionOutput,
-			TransactionID:    transactionID,
-			RecipientAddress: recipientPublicKey,
-			Amount:           amount}}
+	if change := totalCredits - totalAmount - fee; change > 0 {
+		transactionOutputs = append(transactionOutputs, TransactionOutput{
+			RecipientAddress: node.walletAddress,
+			Amount:           change,
+		})
+	}
+	if len(transactionOutputs) == 0 {
+		return Transaction{}, errors.New("NoOutputs")
+	}
 
-	if !node.resolvingConflict {
-		node.myUTXOs.Push(transactionOutputs[0])
+	fmt.Println("\nCoins picked by", coinSelector.Name()+":")
+	for _, UTXO := range UTXOs {
+		fmt.Printf("\t%x %v\n", UTXO.ID, UTXO.Amount)
 	}
 
+	// The transaction and output IDs are derived from the contents of the transaction
 	transaction := Transaction{
 		SenderAddress:      node.publicKey,
-		ReceiverAddress:    recipientPublicKey,
-		Amount:             amount,
-		TransactionID:      transactionID,
+		Fee:                fee,
 		TransactionInputs:  transactionInputs,
 		TransactionOutputs: transactionOutputs,
 	}
+	transaction.setIDs()
+
 	return transaction, nil
 }
 
 /*
 We need to sign the transaction with our private key. Whoever receives this transaction
-must verify it's signature using the sender's public key.
+must verify it's signature using the sender's public key. The TransactionID is the hash
+of the canonical transaction body (see hashing.go), so that is what gets signed, with the
+signature scheme of our key.
 */
-func (node *Node) signTransaction(transaction Transaction) SignedTransaction {
-	transactionJSON, err := json.Marshal(transaction)
-	if err != nil {
-		log.Println("signTransaction: Marshal->", err)
-		return SignedTransaction{}
-	}
+func (node *Node) signTransaction(transaction Transaction) (SignedTransaction, error) {
+	transactionHash := transaction.hash()
 
-	transactionJSONHash := sha256.Sum256(transactionJSON)
-
-	signature, _ := rsa.SignPKCS1v15(rand.Reader, &node.privateKey, crypto.SHA256, transactionJSONHash[:])
+	transactionSignature, err := node.privateKey.Sign(transactionHash[:])
 	if err != nil {
-		log.Println("Setup: Sign", err)
-		return SignedTransaction{}
+		log.Println("signTransaction: Sign->", err)
+		return SignedTransaction{}, err
 	}
 
 	signedTransaction := SignedTransaction{
 		SenderAddress:      transaction.SenderAddress,
-		ReceiverAddress:    transaction.ReceiverAddress,
-		Amount:             transaction.Amount,
+		Multisig:           transaction.Multisig,
+		Fee:                transaction.Fee,
 		TransactionID:      transaction.TransactionID,
 		TransactionInputs:  transaction.TransactionInputs,
 		TransactionOutputs: transaction.TransactionOutputs,
-		Signature:          signature,
+		Signature:          transactionSignature,
 	}
 
-	return signedTransaction
+	return signedTransaction, nil
 }
 
 /*
@@ -287,88 +371,108 @@ func (node *Node) broadcastTransaction(transaction SignedTransaction) {
 Verify the signature of the sender using his public key
 */
 func (node *Node) verifySignature(signedTransaction SignedTransaction) bool {
-	transaction := Transaction{
-		SenderAddress:      signedTransaction.SenderAddress,
-		ReceiverAddress:    signedTransaction.ReceiverAddress,
-		Amount:             signedTransaction.Amount,
-		TransactionID:      signedTransaction.TransactionID,
-		TransactionInputs:  signedTransaction.TransactionInputs,
-		TransactionOutputs: signedTransaction.TransactionOutputs,
-	}
+	transaction := signedTransaction.unsigned()
 
-	transactionJSON, err := json.Marshal(transaction)
-	if err != nil {
-		log.Fatal("verifySignature: Marshal->", err)
-	}
-
-	transactionJSONHash := sha256.Sum256(transactionJSON)
-
-	err = rsa.VerifyPKCS1v15(&transaction.SenderAddress, crypto.SHA256, transactionJSONHash[:], signedTransaction.Signature)
-	if err != nil {
+	// The ids must be the ones derived from the body, otherwise they could be changed after signing
+	if !transaction.idsValid() {
 		return false
-	} else {
-		return true
 	}
+
+	return transaction.SenderAddress.Verify(transaction.TransactionID[:], signedTransaction.Signature)
 }
 
 /*
 Check the validity of the transaction. We need to make sure that:
- 1. Signature of the sender is valid
- 2. Transaction Inputs have not been already spent. This check is important to
-    eliminmate "double spending".
- 3. Sender and Receiver are memebers of the blockchain network
- 4. Transaction Output members exist and are the correct sender and receiver
- 5. Amount that is being sent actually exists in the Sender's UTXO Wallet
+ 1. Signature of the sender is valid, or for a multisig spend enough of the cosignatures
+ 2. Transaction Inputs have not been already spent and belong to the sender. This check is
+    important to eliminmate "double spending". Inputs that spend an output with a locking
+    script don't have to belong to the sender, their unlocking script must satisfy it instead
+ 3. Sender (every cosigner of a multisig spend) is a member of the blockchain network. The
+    outputs may pay any address
+ 4. Every output gives a positive amount of coins, and an output with a locking script pays
+    the address of the script
+ 5. Amount that is being sent actually exists in the Sender's UTXO Wallet, and what is left over
+    is the fee of the transaction
+
+The outputs the transaction spends are looked up with UTXO, so the same checks work against the
+committed UTXOs, the soft validated ones, the committed ones and the mempool, or those of a
+branch. minedAt gives the Index of the block a transaction was mined in, for the relative
+timelocks of scripts, and height is the Index of the block the transaction goes in, which the
+absolute ones are checked against.
 */
-func (node *Node) validateTransaction(signedTransaction SignedTransaction) bool {
-	if !node.verifySignature(signedTransaction) {
-		return false
+func (node *Node) checkTransaction(signedTransaction SignedTransaction, UTXO func([32]byte) (TransactionOutput, bool), minedAt func([32]byte) (uint, bool), height uint) *RejectError {
+	if len(signedTransaction.TransactionOutputs) == 0 {
+		return &RejectError{RejectMalformed, "missing outputs"}
 	}
 
-	//Check if Sender and Receiver are found in the network
-	foundSender, foundReceiver := false, false
+	if signedTransaction.Multisig != nil {
+		if rejectError := node.checkCosignatures(signedTransaction); rejectError != nil {
+			return rejectError
+		}
 
-	for _, nodeData := range node.nodeDataMap {
-		if equal(nodeData.PublicKey, signedTransaction.SenderAddress) {
-			foundSender = true
-		} else if equal(nodeData.PublicKey, signedTransaction.ReceiverAddress) {
-			foundReceiver = true
+	} else {
+		if signedTransaction.SenderAddress.IsZero() || len(signedTransaction.Signature) == 0 || len(signedTransaction.Cosignatures) != 0 {
+			return &RejectError{RejectMalformed, "missing sender or signature"}
+		}
+
+		if !node.verifySignature(signedTransaction) {
+			return &RejectError{RejectInvalidSignature, "invalid signature"}
+		}
+
+		//Check if the Sender is found in the network
+		if node.getId(signedTransaction.SenderAddress) == "" {
+			return &RejectError{RejectUnknownNode, "the sender is not a node of the network"}
 		}
-	}
-	if !foundSender || !foundReceiver {
-		return false
 	}
 
 	// Check if sender actually has the amount needed to do the transaction
+	senderAddress := signedTransaction.senderAddress()
 	inputSum := uint(0)
+	spent := make(map[[32]byte]bool, len(signedTransaction.TransactionInputs))
 	for _, transactionInput := range signedTransaction.TransactionInputs {
-		if transactionOutput, ok := node.UTXOsCommitted[transactionInput.PreviousOutputID]; !ok {
-			return false
-		} else {
-			inputSum += transactionOutput.Amount
+		if spent[transactionInput.PreviousOutputID] {
+			return &RejectError{RejectMalformed, fmt.Sprintf("input %x is spent twice", transactionInput.PreviousOutputID)}
 		}
-	}
-	// Amounts should match
-	outputSum := signedTransaction.TransactionOutputs[0].Amount + signedTransaction.TransactionOutputs[1].Amount
-	if inputSum != outputSum {
-		return false
+		spent[transactionInput.PreviousOutputID] = true
+
+		transactionOutput, ok := UTXO(transactionInput.PreviousOutputID)
+		if !ok {
+			return &RejectError{RejectMissingInputs, fmt.Sprintf("input %x is spent or unknown", transactionInput.PreviousOutputID)}
+		}
+		if len(transactionOutput.LockingScript) > 0 {
+			if err := node.checkUnlockingScript(signedTransaction, transactionInput, transactionOutput, minedAt, height); err != nil {
+				return &RejectError{RejectScriptFailed, fmt.Sprintf("input %x: %v", transactionInput.PreviousOutputID, err)}
+			}
+		} else if len(transactionInput.UnlockingScript) > 0 {
+			return &RejectError{RejectMalformed, fmt.Sprintf("input %x has an unlocking script but its output has no locking script", transactionInput.PreviousOutputID)}
+		} else if transactionOutput.RecipientAddress != senderAddress {
+			return &RejectError{RejectMissingInputs, fmt.Sprintf("input %x doesn't belong to the sender", transactionInput.PreviousOutputID)}
+		}
+		inputSum += transactionOutput.Amount
 	}
 
-	// Check if UTXO's sender and receiver match
-	outputsOK := false
-	if equal(signedTransaction.TransactionOutputs[0].RecipientAddress, signedTransaction.ReceiverAddress) {
-		if equal(signedTransaction.TransactionOutputs[1].RecipientAddress, signedTransaction.SenderAddress) {
-			outputsOK = true
+	// Check that every output pays a positive amount, without the sum wrapping around
+	outputSum := signedTransaction.Fee
+	for index, transactionOutput := range signedTransaction.TransactionOutputs {
+		if transactionOutput.Amount == 0 || outputSum+transactionOutput.Amount < outputSum {
+			return &RejectError{RejectInvalidOutputs, fmt.Sprintf("output %v has an invalid amount", index)}
 		}
-	} else if equal(signedTransaction.TransactionOutputs[0].RecipientAddress, signedTransaction.SenderAddress) {
-		if equal(signedTransaction.TransactionOutputs[1].RecipientAddress, signedTransaction.ReceiverAddress) {
-			outputsOK = true
+		if rejectError := checkLockingScript(transactionOutput); rejectError != nil {
+			return &RejectError{RejectInvalidOutputs, fmt.Sprintf("output %v %v", index, rejectError)}
 		}
+		outputSum += transactionOutput.Amount
 	}
-	if !outputsOK {
-		return false
+
+	// Whatever the outputs don't spend is the fee, and it must be the one the transaction declares
+	if inputSum != outputSum {
+		return &RejectError{RejectInvalidAmounts, fmt.Sprintf("inputs add up to %v but outputs and fee to %v", inputSum, outputSum)}
 	}
 
+	return nil
+}
+
+// Apply a transaction that was checked to the committed UTXOs
+func (node *Node) applyTransaction(signedTransaction SignedTransaction) {
 	/*
 		Update UTXOs. This Update must be done by everyone so that each node has a correct
 		view on everyone's UTXOs
@@ -376,25 +480,22 @@ func (node *Node) validateTransaction(signedTransaction SignedTransaction) bool
 
 	// Delete only the ones found in the TransactionInput list :)
 	for _, transactionInput := range signedTransaction.TransactionInputs {
-		delete(node.UTXOsCommitted, transactionInput.PreviousOutputID)
+		node.UTXOsCommitted.Remove(transactionInput.PreviousOutputID)
 	}
 
-	senderTransactionOutput := &signedTransaction.TransactionOutputs[0]
-	receiverTransactionOutput := &signedTransaction.TransactionOutputs[1]
-
-	/*
-		Add only the receiver transaction output to the stack. The sender transaction output has already been added
-		In the transaction generation process
-	*/
-	if equal(node.publicKey, receiverTransactionOutput.RecipientAddress) && !node.resolvingConflict {
-		node.myUTXOs.Push(*receiverTransactionOutput)
+	// Commit the UTXOs created by the transaction
+	for _, transactionOutput := range signedTransaction.TransactionOutputs {
+		node.UTXOsCommitted.Add(transactionOutput)
 	}
 
-	// Commit the UTXOs created by the transaction
-	node.UTXOsCommitted[senderTransactionOutput.ID] = *senderTransactionOutput
-	node.UTXOsCommitted[receiverTransactionOutput.ID] = *receiverTransactionOutput
+	// Our own transactions are in the wallet since they were admitted, unless a reorg undid them
+	node.walletApply(signedTransaction)
+}
 
-	return true
+// The Index of the block of the main chain the transaction was mined in
+func (node *Node) minedAt(transactionID [32]byte) (uint, bool) {
+	index, ok := node.chainTransactions[transactionID]
+	return index, ok
 }
 
 /*
@@ -403,52 +504,8 @@ that are affiliated with this transaction are not commited to the global UTXO co
 This is only done to make sure that the transactions that are added to the blockToBeMined are valid.
 */
 func (node *Node) softValidateTransaction(signedTransaction SignedTransaction) bool {
-	if !node.verifySignature(signedTransaction) {
-		return false
-	}
-
-	//Check if Sender and Receiver are found in the network
-	foundSender := false
-	foundReceiver := false
-	for _, nodeData := range node.nodeDataMap {
-		if equal(nodeData.PublicKey, signedTransaction.SenderAddress) {
-			foundSender = true
-		} else if equal(nodeData.PublicKey, signedTransaction.ReceiverAddress) {
-			foundReceiver = true
-		}
-	}
-	if !foundSender || !foundReceiver {
-		return false
-	}
-
-	// Check if sender actually has the amount needed to do the transaction
-	inputSum := uint(0)
-	for _, transactionInput := range signedTransaction.TransactionInputs {
-		if transactionOutput, ok := node.UTXOsSoftValidated[transactionInput.PreviousOutputID]; !ok {
-			return false
-		} else {
-			inputSum += transactionOutput.Amount
-		}
-	}
-
-	// Amounts should match
-	outputSum := signedTransaction.TransactionOutputs[0].Amount + signedTransaction.TransactionOutputs[1].Amount
-	if inputSum != outputSum {
-		return false
-	}
-
-	// Check if UTXO's sender and receiver match
-	outputsOK := false
-	if equal(signedTransaction.TransactionOutputs[0].RecipientAddress, signedTransaction.ReceiverAddress) {
-		if equal(signedTransaction.TransactionOutputs[1].RecipientAddress, signedTransaction.SenderAddress) {
-			outputsOK = true
-		}
-	} else if equal(signedTransaction.TransactionOutputs[0].RecipientAddress, signedTransaction.SenderAddress) {
-		if equal(signedTransaction.TransactionOutputs[1].RecipientAddress, signedTransaction.ReceiverAddress) {
-			outputsOK = true
-		}
-	}
-	if !outputsOK {
+	// The block being mined goes after the tip
+	if node.checkTransaction(signedTransaction, node.UTXOsSoftValidated.Get, node.minedAt, uint(len(node.blockchain))) != nil {
 		return false
 	}
 
@@ -459,32 +516,47 @@ func (node *Node) softValidateTransaction(signedTransaction SignedTransaction) b
 
 	// Remove all the UTXOs that had matching TransactionInput IDs
 	for _, transactionInput := range signedTransaction.TransactionInputs {
-		delete(node.UTXOsSoftValidated, transactionInput.PreviousOutputID)
+		node.UTXOsSoftValidated.Remove(transactionInput.PreviousOutputID)
 	}
 
-	senderTransactionOutput := &signedTransaction.TransactionOutputs[0]
-	receiverTransactionOutput := &signedTransaction.TransactionOutputs[1]
-
-	node.UTXOsSoftValidated[senderTransactionOutput.ID] = *senderTransactionOutput
-	node.UTXOsSoftValidated[receiverTransactionOutput.ID] = *receiverTransactionOutput
+	for _, transactionOutput := range signedTransaction.TransactionOutputs {
+		node.UTXOsSoftValidated.Add(transactionOutput)
+	}
 
 	return true
 }
 
-func (node *Node) mineBlock(signedTransaction []SignedTransaction, chainLength uint) HashedBlock {
+// Mine a block on top of the tip the transactions were picked for. It is given up once the tip changes.
+func (node *Node) mineBlock(signedTransaction []SignedTransaction, chainLength uint, tipHash [32]byte) HashedBlock {
 	fmt.Println("\nMining block", chainLength)
 
-	var transactions [CAPACITY]SignedTransaction
-	copy(transactions[:], signedTransaction)
+	// The coinbase pays us the block reward and the fees of the transactions
+	transactions := make([]SignedTransaction, 0, len(signedTransaction)+1)
+	transactions = append(transactions, node.createCoinbase(chainLength, blockFees(signedTransaction)))
+	transactions = append(transactions, signedTransaction...)
 
 	/*
 		Create a basis block using the transactions that we have validated and are using to mine the
 		block. Nonce is <nil> in this case and will be generating various values to test the hash.
+		The target is the one the chain expects after our tip.
 	*/
+	node.blockchainLock.Lock()
+	if node.tipChanged(chainLength, tipHash) {
+		node.blockchainLock.Unlock()
+		return HashedBlock{}
+	}
+	parent := node.blockTree.blocks[tipHash]
+	node.blockchainLock.Unlock()
+
 	block := Block{
-		Index:        chainLength,
-		Timestamp:    time.Now().Unix(),
-		PreviousHash: node.blockchain[chainLength-1].Hash,
+		BlockHeader: BlockHeader{
+			Index:        chainLength,
+			Timestamp:    node.blockTimestamp(parent),
+			PreviousHash: parent.block.Hash,
+			MerkleRoot:   merkleRoot(transactions),
+			WitnessRoot:  witnessRoot(transactions),
+			Target:       node.nextTarget(parent),
+		},
 		Transactions: transactions,
 	}
 
@@ -501,62 +573,46 @@ func (node *Node) mineBlock(signedTransaction []SignedTransaction, chainLength u
 				first.
 			*/
 			node.blockchainLock.Lock()
-			if chainLength < uint(len(node.blockchain)) {
+			if node.tipChanged(chainLength, block.PreviousHash) {
 				node.blockchainLock.Unlock()
 				return HashedBlock{}
 			}
 			node.blockchainLock.Unlock()
+
+			// Retargeting goes by the timestamps, so they should say when the block was found
+			block.Timestamp = node.blockTimestamp(parent)
 		}
 
 		/*
-			Generate a random nonce number, hash the block and check if it
-			matches our difficulty rules.
+			Generate a random nonce number, hash the block header and check if it
+			is under the target. The transactions are only part of the
+			header through the Merkle and witness roots, so they don't need to be hashed again.
 		*/
 		block.Nonce = generateRandom32Byte()
 
-		blockJSON, err := json.Marshal(block)
-		if err != nil {
-			log.Println("Error while marshaling block")
-			continue
-		}
-		hash := sha256.Sum256(blockJSON)
-
-		mined := true
-
-		/*
-			While trying to do the project we run up to this problem:
-			The difficulty of the block is the amount of 0s that need to
-			be found in the beginning of the SHA256 hash. That means that if
-			the difficulty is 5, then the 5 MSBs of the hash must be 0. This turned
-			out to be very easy for the node to calculate and caused some synchronization
-			issues. Thus, we changed it from DIFFICULTY bits to DIFFICULTY hexadecimals
-			(or nibbles, 4-bits).
-		*/
-		for _, oneByte := range hash[:DIFFICULTY/2] {
-			if oneByte != 0 {
-				mined = false
-				break
-			}
-		}
-		if DIFFICULTY%2 == 1 && hash[DIFFICULTY/2] > 15 {
-			mined = false
-		}
+		hash := block.BlockHeader.hash()
 
-		if mined {
+		if hashMeetsTarget(hash, block.Target) {
 			log.Printf("Node %v found block!", node.id)
 
 			return HashedBlock{
-				Index:        block.Index,
-				Timestamp:    block.Timestamp,
-				PreviousHash: block.PreviousHash,
+				BlockHeader:  block.BlockHeader,
 				Transactions: block.Transactions,
-				Nonce:        block.Nonce,
 				Hash:         hash,
 			}
 		}
 	}
 }
 
+// The current time, unless the clock is behind the median time of the blocks before
+func (node *Node) blockTimestamp(parent *treeBlock) int64 {
+	timestamp := time.Now().UnixMilli()
+	if median := medianTimePast(parent); timestamp <= median {
+		timestamp = median + 1
+	}
+	return timestamp
+}
+
 func (node *Node) broadcastBlock(block HashedBlock) {
 	if len(node.blockchain) > int(block.Index) {
 		log.Println("broadcastBlock: Aborting broadcast - new block added to chain")
@@ -574,125 +630,186 @@ func (node *Node) broadcastBlock(block HashedBlock) {
 	node.broadcast <- true
 }
 
+/*
+Add a block to the block tree. The block becomes part of the main chain if its branch has more
+work than the main chain, otherwise it is kept on a side branch. A block whose parent we don't
+have is put aside until the parent arrives, and we ask the other nodes for the blocks we miss.
+Returns true if the block was valid and new to us.
+*/
 func (node *Node) validateBlock(hashedBlock HashedBlock) bool {
 
-	currentChainLength := uint(len(node.blockchain))
+	// The genesis block is accepted as long as it gives out the coins agreed on in the chain params
+	if node.blockTree.tip == nil && hashedBlock.Index == 0 {
+		if !node.validateGenesisAllocation(hashedBlock) {
+			log.Println("validateBlock: genesis block does not match the chain params")
+			return false
+		}
 
-	// Automatically validate genesis block, its accepted as valid every time
-	if currentChainLength == 0 && hashedBlock.Index == 0 {
 		for _, transaction := range hashedBlock.Transactions {
+			node.chainTransactions[transaction.TransactionID] = 0
 
-			if transaction.Amount > 0 {
-				transactionOutput1 := &transaction.TransactionOutputs[0]
-				transactionOutput2 := &transaction.TransactionOutputs[1]
-
-				// only store in own unspent tokens if bootstrap
-				if node.broadcastType != ResolveRequestMessageType {
-					if equal(node.publicKey, transactionOutput1.RecipientAddress) && !node.resolvingConflict {
-						node.myUTXOs.Push(*transactionOutput1)
-					} else if equal(node.publicKey, transactionOutput2.RecipientAddress) && !node.resolvingConflict {
-						node.myUTXOs.Push(*transactionOutput2)
-					}
+			transactionOutput := transaction.TransactionOutputs[0]
+			if transactionOutput.Amount > 0 {
+				if transactionOutput.RecipientAddress == node.walletAddress {
+					node.myUTXOs.Push(transactionOutput)
 				}
-				node.UTXOsCommitted[transactionOutput1.ID] = *transactionOutput1
-				node.UTXOsCommitted[transactionOutput2.ID] = *transactionOutput2
+				node.UTXOsCommitted.Add(transactionOutput)
 			}
 		}
+		node.blockTree.tip = node.blockTree.add(hashedBlock, nil)
 		node.blockchain = append(node.blockchain, hashedBlock)
+		node.persistBlock(hashedBlock)
+		node.events.Publish(Event{Type: BlockEvent, Data: hashedBlock})
+		node.chainGrown.Broadcast()
 
+		node.adoptOrphans(hashedBlock.Hash)
 		return true
+	}
 
-	} else if currentChainLength > hashedBlock.Index {
-		// Reject older blocks
+	if _, ok := node.blockTree.blocks[hashedBlock.Hash]; ok {
 		log.Println("Older block received")
 		return false
-	} else if currentChainLength < hashedBlock.Index {
-		// Resolve conflict if there is a gap in the blockchain
-		log.Println("Possible gap in chain - calling resolveConflict()")
-		node.resolveConflict(hashedBlock.Index)
-		return false
 	}
 
-	// If the block is the correct one, check its validity by comparing the hashes and the difficulty rule
-	block := Block{
-		Index:        hashedBlock.Index,
-		Timestamp:    hashedBlock.Timestamp,
-		PreviousHash: hashedBlock.PreviousHash,
-		Transactions: hashedBlock.Transactions,
-		Nonce:        hashedBlock.Nonce,
+	// There is only one genesis block
+	if hashedBlock.Index == 0 {
+		return false
 	}
 
-	blockJSON, _ := json.Marshal(block)
+	// Capacity doesn't count the coinbase
+	if len(hashedBlock.Transactions) > node.params.Capacity+1 {
+		log.Println("validateBlock: block has more than", node.params.Capacity, "transactions")
+		return false
+	}
+	if !node.validateCoinbase(hashedBlock) {
+		log.Println("validateBlock: block", hashedBlock.Index, "has an invalid coinbase")
+		return false
+	}
 
-	hash := sha256.Sum256(blockJSON)
-	if hash != hashedBlock.Hash {
+	// Check the validity of the block by comparing the hashes and the proof of work
+	if merkleRoot(hashedBlock.Transactions) != hashedBlock.MerkleRoot || witnessRoot(hashedBlock.Transactions) != hashedBlock.WitnessRoot {
 		return false
 	}
 
-	for _, oneByte := range hash[:DIFFICULTY/2] {
-		if oneByte != 0 {
-			return false
-		}
+	hash := hashedBlock.BlockHeader.hash()
+	if hash != hashedBlock.Hash || !hashMeetsTarget(hash, hashedBlock.Target) {
+		return false
 	}
 
-	if DIFFICULTY%2 == 1 && hash[DIFFICULTY/2] > 15 {
+	parent, ok := node.blockTree.blocks[hashedBlock.PreviousHash]
+	if !ok {
+		log.Println("Parent of block", hashedBlock.Index, "not known - calling resolveConflict")
+		node.blockTree.addOrphan(hashedBlock)
+		node.resolveConflict()
+		return false
+	}
+	if hashedBlock.Index != parent.block.Index+1 {
 		return false
 	}
 
-	// We possess an invalid blockchain, receive the largest one from someone else
-	if hashedBlock.Index > 0 && hashedBlock.PreviousHash != node.blockchain[hashedBlock.Index-1].Hash {
-		log.Println("Previous hash not matching the one in the blockchain - calling resolveConflict")
-		node.resolveConflict(hashedBlock.Index)
+	// The target is set by the chain, and the timestamps it is set by must move forward
+	if hashedBlock.Target != node.nextTarget(parent) {
+		log.Println("validateBlock: block", hashedBlock.Index, "doesn't have the expected target")
+		return false
+	}
+	if hashedBlock.Timestamp <= medianTimePast(parent) || hashedBlock.Timestamp > time.Now().Add(maxFutureBlockTime).UnixMilli() {
+		log.Println("validateBlock: block", hashedBlock.Index, "has an invalid timestamp")
 		return false
 	}
+	if hashedBlock.Target != parent.block.Target {
+		log.Printf("validateBlock: new target at block %v: %x", hashedBlock.Index, hashedBlock.Target)
+	}
 
-	// The block is accepted - we need to add it to the blockchain
-	node.blockchain = append(node.blockchain, hashedBlock)
-	for index, transaction := range hashedBlock.Transactions {
-		valid := node.validateTransaction(transaction)
-		if !valid {
-			log.Println("validateBlock: transaction", index, "invalid")
+	added := node.blockTree.add(hashedBlock, parent)
+	if added.work.Cmp(node.blockTree.tip.work) > 0 {
+		if err := node.reorganize(added); err != nil {
+			log.Println("validateBlock: block", hashedBlock.Index, "is invalid -", err)
+			return false
 		}
 	}
 
+	node.adoptOrphans(hashedBlock.Hash)
 	return true
 }
 
-func (node *Node) validateChain() bool {
+// Add the blocks that were waiting for this one
+func (node *Node) adoptOrphans(parentHash [32]byte) {
+	for _, orphan := range node.blockTree.takeOrphans(parentHash) {
+		if node.validateBlock(orphan) {
+			log.Println("Block with index", orphan.Index, "validated")
+		}
+	}
+}
 
-	/*
-		Check if every block of the blockchain is valid
-		This is only called when we receive a blockchain
-		while calling resolve conflict.
-	*/
+/*
+The genesis block must contain one transaction for every node id of the genesis allocation,
+in the order given by genesisIDs(). Recipients can only be checked for the nodes whose public
+key we already know.
+*/
+func (node *Node) validateGenesisAllocation(hashedBlock HashedBlock) bool {
+	if !node.paramsReceived {
+		return false
+	}
 
-	blockchainCopy := make([]HashedBlock, len(node.blockchain))
-	copy(blockchainCopy, node.blockchain)
-	node.blockchain = make([]HashedBlock, 0)
+	ids := node.params.genesisIDs()
+	if len(hashedBlock.Transactions) != len(ids) {
+		return false
+	}
+
+	if merkleRoot(hashedBlock.Transactions) != hashedBlock.MerkleRoot || witnessRoot(hashedBlock.Transactions) != hashedBlock.WitnessRoot || hashedBlock.BlockHeader.hash() != hashedBlock.Hash {
+		return false
+	}
+
+	if hashedBlock.Target != targetFromDifficulty(node.params.Difficulty) {
+		return false
+	}
+
+	for i, id := range ids {
+		transaction := hashedBlock.Transactions[i]
+
+		// A single output with the coins of the node
+		if len(transaction.TransactionOutputs) != 1 || transaction.TransactionOutputs[0].Amount != node.params.Genesis[id] {
+			return false
+		}
 
-	node.UTXOsSoftValidated = make(map[[32]byte]TransactionOutput)
-	node.UTXOsSoftValidated = make(map[[32]byte]TransactionOutput)
-	node.UTXOsCommitted = make(map[[32]byte]TransactionOutput)
-	node.usedTransactions = make(map[[32]byte]bool)
+		unsignedTransaction := transaction.unsigned()
+		if !unsignedTransaction.idsValid() {
+			return false
+		}
 
-	valid := true
-	for _, block := range blockchainCopy {
-		valid = node.validateBlock(block)
-		if !valid {
-			break
+		if nodeData, ok := node.getNodeData(id); ok && !nodeData.PublicKey.IsZero() {
+			if addressOf(nodeData.PublicKey) != transaction.TransactionOutputs[0].RecipientAddress {
+				return false
+			}
 		}
 	}
 
-	if valid {
-		log.Println("validateChain: blockchain is valid")
-	} else {
-		log.Println("validateChain: blockchain is not valid")
+	return true
+}
+
+/*
+Ask a single node for the blocks that come after our chain. The reply goes through the usual
+ResolveResponseMessage path, which only sends the blocks after the last hash we have in common.
+*/
+func (node *Node) requestMissingBlocks(peer *Peer) {
+	node.blockchainLock.Lock()
+	hashes := make([][32]byte, 0, len(node.blockchain))
+	for _, block := range node.blockchain {
+		hashes = append(hashes, block.Hash)
 	}
+	node.blockchainLock.Unlock()
 
-	return valid
+	node.sendResolveRequestMessage(peer, ResolveRequestMessage{
+		ChainSize: uint(len(hashes)),
+		Hashes:    hashes,
+	})
 }
 
-func (node *Node) resolveConflict(index uint) {
+/*
+Ask every node for the blocks after the main chain, when we have received a block whose parent
+we don't know. The blocks in the replies go into the block tree like any other block.
+*/
+func (node *Node) resolveConflict() {
 	hashes := make([][32]byte, 0)
 	for _, block := range node.blockchain {
 		hashes = append(hashes, block.Hash)
@@ -707,70 +824,34 @@ func (node *Node) resolveConflict(index uint) {
 	}
 	node.broadcastType = ResolveRequestMessageType
 	node.broadcast <- true
-	for int(index) > len(node.blockchain) {
-		continue
-	}
 }
 
 /*
 This is state in the program where we wait to receive the necessary *capacity* transactions
-to start mining the block.
+to start mining the block. The mempool picks the ones that pay the most, and we wait for it to
+change instead of checking it over and over. Nothing can be mined before the genesis block,
+which the bootstrap node only makes once every node has joined, so we wait for it first.
 */
 func (node *Node) collectTransactions() {
-	for {
-		node.blockchainLock.Lock()
-		if len(node.blockchain) > 0 {
-			node.blockchainLock.Unlock()
-			break
-		}
-		node.blockchainLock.Unlock()
-	}
-
-	node.updateUncommitted()
+	node.waitForChain(1)
 
 	for {
+		node.blockchainLock.Lock()
 		chainLength := uint(len(node.blockchain))
-		collectedTransactions := []SignedTransaction{}
+		tipHash := node.blockchain[chainLength-1].Hash
 
-		allTransactions := *NewTransactionQueue()
-		allTransactions.Copy(&node.transactionQueue)
-
-		/*
-			Collect enough valid transactions to start mining the block
-		*/
-		skip := false
-		for len(collectedTransactions) < CAPACITY {
-
-			if node.broadcastType == ResolveRequestMessageType {
-				time.Sleep(time.Second * 4)
-				skip = true
-				break
-			}
-
-			// Keep popping transactions until we have reached the CAPACITY amount
-			transaction, err := allTransactions.Pop()
-
-			if err != nil {
-				allTransactions.Copy(&node.transactionQueue)
-				continue
-			}
-
-			/*
-				Check if the transaction has already been used to mine another block.
-				If not, then use it in the current block
-			*/
-			alreadyUsed, exists := node.usedTransactions[transaction.TransactionID]
-			if !exists || !alreadyUsed {
-				node.blockchainLock.Lock()
-				transactionOK := node.softValidateTransaction(transaction)
-				node.blockchainLock.Unlock()
-				if !transactionOK {
-					continue
-				}
+		// Every transaction is checked against the UTXOs of the tip and the transactions picked before it
+		node.updateUncommitted()
+		collectedTransactions := node.mempool.Select(node.params.Capacity, node.softValidateTransaction)
+		node.blockchainLock.Unlock()
 
-				node.usedTransactions[transaction.TransactionID] = true
-				collectedTransactions = append(collectedTransactions, transaction)
+		if len(collectedTransactions) < node.params.Capacity {
+			// A new block doesn't always change the mempool, so look again every now and then
+			select {
+			case <-node.mempool.Changed():
+			case <-time.After(time.Second):
 			}
+			continue
 		}
 
 		/*
@@ -781,52 +862,39 @@ func (node *Node) collectTransactions() {
 
 		startTime := time.Now().Unix()
 
-		if skip {
-			skip = false
-		} else {
-			node.mineLock.Lock()
-			block := node.mineBlock(collectedTransactions, chainLength)
-			node.mineLock.Unlock()
-
-			node.blockchainLock.Lock()
-			if chainLength == uint(len(node.blockchain)) {
-				node.broadcastBlock(block)
-			}
-			node.blockchainLock.Unlock()
+		node.mineLock.Lock()
+		block := node.mineBlock(collectedTransactions, chainLength, tipHash)
+		node.mineLock.Unlock()
 
-			stopTime := time.Now().Unix()
-			blockTime := stopTime - startTime
-			fmt.Println(">>Block time:", blockTime)
-			fmt.Println("")
+		node.blockchainLock.Lock()
+		if !node.tipChanged(chainLength, tipHash) {
+			node.broadcastBlock(block)
 		}
+		node.blockchainLock.Unlock()
 
-		node.updateUncommitted()
+		stopTime := time.Now().Unix()
+		blockTime := stopTime - startTime
+		fmt.Println(">>Block time:", blockTime)
+		fmt.Println("")
 	}
 }
 
-func (node *Node) updateUncommitted() {
+// Block until the main chain has at least length blocks
+func (node *Node) waitForChain(length int) {
+	node.blockchainLock.Lock()
+	defer node.blockchainLock.Unlock()
 
-	node.UTXOsSoftValidated = make(map[[32]byte]TransactionOutput)
-	for transactionID, UTXOs := range node.UTXOsCommitted {
-		node.UTXOsSoftValidated[transactionID] = UTXOs
+	for len(node.blockchain) < length {
+		node.chainGrown.Wait()
 	}
+}
 
-	node.usedTransactions = make(map[[32]byte]bool)
-
-	node.blockchainLock.Lock()
-	for _, block := range node.blockchain {
-		for _, transaction := range block.Transactions {
-			node.usedTransactions[transaction.TransactionID] = true
-		}
-	}
-	node.blockchainLock.Unlock()
+// Whether the main chain is no longer the one with the given length and tip
+func (node *Node) tipChanged(chainLength uint, tipHash [32]byte) bool {
+	return uint(len(node.blockchain)) != chainLength || node.blockchain[chainLength-1].Hash != tipHash
 }
 
-func (node *Node) getId(publicKey rsa.PublicKey) string {
-	for id, nodeData := range node.nodeDataMap {
-		if equal(nodeData.PublicKey, publicKey) {
-			return id
-		}
-	}
-	return ""
+// Start the soft validated UTXOs over from the committed ones. The caller must hold blockchainLock.
+func (node *Node) updateUncommitted() {
+	node.UTXOsSoftValidated = node.UTXOsCommitted.Copy()
 }
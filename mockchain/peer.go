@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+Messages travel over TCP in frames:
+
+	+---------+--------+----------------+-----------------+
+	| version | codec  | length         | payload         |
+	| 1 byte  | 1 byte | 4 bytes, BE    | length bytes    |
+	+---------+--------+----------------+-----------------+
+
+The payload is a Message, encoded with the codec of the frame. The MessageData inside it is
+encoded with the same codec. A frame with an unknown version, an unknown codec or a length over
+maxFrameSize can't be recovered from, so the connection gets closed.
+*/
+const protocolVersion = 2
+const frameHeaderSize = 1 + 1 + 4
+const maxFrameSize = 32 << 20 // resolve responses carry whole chains
+
+const sendQueueSize = 256
+
+var errFrameTooLarge = errors.New("frame larger than maxFrameSize")
+
+func writeFrame(writer io.Writer, message Message) error {
+	payload, err := marshalWithCodec(message.codec, message)
+	if err != nil {
+		return err
+	}
+	if len(payload) > maxFrameSize {
+		return errFrameTooLarge
+	}
+
+	frame := make([]byte, frameHeaderSize+len(payload))
+	frame[0] = protocolVersion
+	frame[1] = byte(message.codec)
+	binary.BigEndian.PutUint32(frame[2:frameHeaderSize], uint32(len(payload)))
+	copy(frame[frameHeaderSize:], payload)
+
+	_, err = writer.Write(frame)
+	return err
+}
+
+func readFrame(reader io.Reader) (Message, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return Message{}, err
+	}
+
+	if header[0] != protocolVersion {
+		return Message{}, fmt.Errorf("unsupported protocol version %v", header[0])
+	}
+
+	codec := Codec(header[1])
+	if codec != JSONCodec && codec != BinaryCodec {
+		return Message{}, fmt.Errorf("unsupported codec %v", header[1])
+	}
+
+	length := binary.BigEndian.Uint32(header[2:])
+	if length > maxFrameSize {
+		return Message{}, errFrameTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return Message{}, err
+	}
+
+	var message Message
+	if err := unmarshalWithCodec(codec, payload, &message); err != nil {
+		return Message{}, err
+	}
+	message.codec = codec
+	return message, nil
+}
+
+/*
+A Peer is one connection to another node. It owns a single buffered reader, used only by the
+goroutine that monitors the connection, and a writer goroutine that drains the send queue, so
+that messages from different goroutines are never interleaved on the wire.
+*/
+type Peer struct {
+	id         atomic.Pointer[string] // set by addPeer, the writer goroutine may already be running by then
+	connection net.Conn
+	reader     *bufio.Reader
+	codec      atomic.Uint32 // codec of the messages we send, JSON until the handshake says otherwise
+
+	connectedAt time.Time
+	lastSeen    atomic.Int64 // when we last got a frame from the peer, in Unix nanoseconds
+	rtt         atomic.Int64 // round trip time of the last ping, 0 until the first pong
+	rejoining   atomic.Bool  // we answered the challenge of the bootstrap node and wait to be welcomed back
+	welcomed    atomic.Bool  // the bootstrap node has welcomed us on this connection
+
+	sendQueue chan Message
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPeer(connection net.Conn) *Peer {
+	peer := &Peer{
+		connection:  connection,
+		reader:      bufio.NewReader(connection),
+		connectedAt: time.Now(),
+		sendQueue:   make(chan Message, sendQueueSize),
+		closed:      make(chan struct{}),
+	}
+	peer.lastSeen.Store(peer.connectedAt.UnixNano())
+
+	go peer.writeMessages()
+
+	return peer
+}
+
+func (peer *Peer) receive() (Message, error) {
+	message, err := readFrame(peer.reader)
+	if err == nil {
+		peer.lastSeen.Store(time.Now().UnixNano())
+	}
+	return message, err
+}
+
+// The id of the node at the other end, empty until we know it
+func (peer *Peer) getID() string {
+	if id := peer.id.Load(); id != nil {
+		return *id
+	}
+	return ""
+}
+
+func (peer *Peer) setID(id string) {
+	peer.id.Store(&id)
+}
+
+func (peer *Peer) getLastSeen() time.Time {
+	return time.Unix(0, peer.lastSeen.Load())
+}
+
+func (peer *Peer) getRTT() time.Duration {
+	return time.Duration(peer.rtt.Load())
+}
+
+func (peer *Peer) getCodec() Codec {
+	return Codec(peer.codec.Load())
+}
+
+func (peer *Peer) setCodec(codec Codec) {
+	peer.codec.Store(uint32(codec))
+}
+
+// Queue a message to be sent. Messages to a closed peer are dropped.
+func (peer *Peer) send(message Message) {
+	select {
+	case peer.sendQueue <- message:
+	case <-peer.closed:
+	}
+}
+
+func (peer *Peer) writeMessages() {
+	for {
+		select {
+		case message := <-peer.sendQueue:
+			if err := writeFrame(peer.connection, message); err != nil {
+				log.Println("writeMessages:", peer.getID(), err)
+				peer.close()
+				return
+			}
+
+			// Nothing may follow a leave message, the other side stops reading after it
+			if message.MessageType == LeaveMessageType {
+				peer.close()
+				return
+			}
+
+		case <-peer.closed:
+			return
+		}
+	}
+}
+
+func (peer *Peer) close() {
+	peer.closeOnce.Do(func() {
+		close(peer.closed)
+		peer.connection.Close()
+	})
+}
+
+func (peer *Peer) isClosed() bool {
+	select {
+	case <-peer.closed:
+		return true
+	default:
+		return false
+	}
+}
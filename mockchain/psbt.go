@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"noobcash/signature"
+)
+
+/*
+Spending from a multisig address takes a few steps, one cosigner at a time, with the partially
+signed transaction kept in a JSON file that the cosigners pass around:
+
+	multisig add <m> <id> ...          every cosigner registers the address, made of the keys of the given nodes
+	psbt create <file> <address> ...   one of them makes the transaction, with no signatures yet
+	psbt sign <file>                   each cosigner adds its signature to their copy
+	psbt combine <file> <file> ...     the signatures of several copies are merged into the first one
+	psbt send <file>                   once m keys have signed, the transaction goes to the network
+
+A file is simply the SignedTransaction, with the Cosignatures collected so far.
+*/
+
+// The policies of the multisig addresses we keep track of, ordered by address
+func (node *Node) multisigPolicies() []MultisigPolicy {
+	node.multisigLock.Lock()
+	defer node.multisigLock.Unlock()
+
+	policies := make([]MultisigPolicy, 0, len(node.multisigs))
+	for _, policy := range node.multisigs {
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].address().String() < policies[j].address().String()
+	})
+	return policies
+}
+
+func (node *Node) multisigPolicy(address Address) (MultisigPolicy, bool) {
+	node.multisigLock.Lock()
+	defer node.multisigLock.Unlock()
+
+	policy, ok := node.multisigs[address]
+	return policy, ok
+}
+
+// Keep track of the multisig address of the keys of the given nodes
+func (node *Node) addMultisig(required uint32, ids []string) (MultisigPolicy, error) {
+	publicKeys := make([]signature.PublicKey, 0, len(ids))
+	for _, id := range ids {
+		nodeData, ok := node.getNodeData(id)
+		if !ok || nodeData.PublicKey.IsZero() {
+			return MultisigPolicy{}, fmt.Errorf("No client with id %v", id)
+		}
+		publicKeys = append(publicKeys, nodeData.PublicKey)
+	}
+
+	policy, err := newMultisigPolicy(required, publicKeys)
+	if err != nil {
+		return MultisigPolicy{}, err
+	}
+
+	node.multisigLock.Lock()
+	node.multisigs[policy.address()] = policy
+	node.multisigLock.Unlock()
+
+	// The state file is written under blockchainLock when blocks are added
+	if node.dataDir != "" {
+		node.blockchainLock.Lock()
+		err := node.saveNodeState()
+		node.blockchainLock.Unlock()
+		if err != nil {
+			log.Println("addMultisig: saveNodeState->", err)
+		}
+	}
+
+	return policy, nil
+}
+
+// The ids of the nodes of a policy's keys
+func (node *Node) cosignerIDs(policy MultisigPolicy) string {
+	ids := make([]string, len(policy.PublicKeys))
+	for i, publicKey := range policy.PublicKeys {
+		ids[i] = node.addressName(addressOf(publicKey))
+	}
+	return strings.Join(ids, ", ")
+}
+
+/*
+Make a transaction that pays from a multisig address, with the change going back to it. The
+coins are picked with the wallet's coin selection from the committed outputs of the address that
+no transaction in the mempool spends yet.
+*/
+func (node *Node) createMultisigTransaction(policy MultisigPolicy, payments []Payment, fee uint) (SignedTransaction, error) {
+	multisigAddress := policy.address()
+
+	transactionOutputs, totalAmount, err := node.paymentOutputs(payments, multisigAddress)
+	if err != nil {
+		return SignedTransaction{}, err
+	}
+
+	node.blockchainLock.Lock()
+	available := make([]TransactionOutput, 0)
+	for _, UTXO := range node.UTXOsCommitted.OutputsOf(multisigAddress) {
+		if !node.mempool.Spends(UTXO.ID) {
+			available = append(available, UTXO)
+		}
+	}
+	node.blockchainLock.Unlock()
+
+	node.mineLock.Lock()
+	coinSelector := node.coinSelector
+	node.mineLock.Unlock()
+
+	UTXOs, err := coinSelector.Select(available, totalAmount+fee)
+	if err != nil {
+		return SignedTransaction{}, err
+	}
+
+	var totalCredits uint = 0
+	transactionInputs := make([]TransactionInput, 0, len(UTXOs))
+	for _, UTXO := range UTXOs {
+		totalCredits += UTXO.Amount
+		transactionInputs = append(transactionInputs, TransactionInput{PreviousOutputID: UTXO.ID})
+	}
+
+	if change := totalCredits - totalAmount - fee; change > 0 {
+		transactionOutputs = append(transactionOutputs, TransactionOutput{
+			RecipientAddress: multisigAddress,
+			Amount:           change,
+		})
+	}
+
+	transaction := Transaction{
+		Multisig:           &policy,
+		Fee:                fee,
+		TransactionInputs:  transactionInputs,
+		TransactionOutputs: transactionOutputs,
+	}
+	transaction.setIDs()
+
+	return SignedTransaction{
+		Multisig:           transaction.Multisig,
+		Fee:                transaction.Fee,
+		TransactionID:      transaction.TransactionID,
+		TransactionInputs:  transaction.TransactionInputs,
+		TransactionOutputs: transaction.TransactionOutputs,
+	}, nil
+}
+
+func readPSBT(path string) (SignedTransaction, error) {
+	var signedTransaction SignedTransaction
+
+	psbtJSON, err := os.ReadFile(path)
+	if err != nil {
+		return signedTransaction, err
+	}
+	if err := json.Unmarshal(psbtJSON, &signedTransaction); err != nil {
+		return signedTransaction, err
+	}
+
+	// Anything that will be signed must be a well formed multisig spend
+	if signedTransaction.Multisig == nil {
+		return signedTransaction, errors.New(path + " doesn't spend from a multisig address")
+	}
+	if err := signedTransaction.Multisig.validate(); err != nil {
+		return signedTransaction, err
+	}
+	transaction := signedTransaction.unsigned()
+	if !transaction.idsValid() {
+		return signedTransaction, errors.New(path + " has ids that don't match the transaction")
+	}
+
+	return signedTransaction, nil
+}
+
+func writePSBT(path string, signedTransaction SignedTransaction) error {
+	psbtJSON, err := json.MarshalIndent(signedTransaction, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, psbtJSON, 0600)
+}
+
+// Add or replace the cosignature of one key, keeping them in the order of the keys
+func addCosignature(signedTransaction *SignedTransaction, cosignature Cosignature) {
+	for i := range signedTransaction.Cosignatures {
+		if signedTransaction.Cosignatures[i].KeyIndex == cosignature.KeyIndex {
+			signedTransaction.Cosignatures[i] = cosignature
+			return
+		}
+	}
+
+	signedTransaction.Cosignatures = append(signedTransaction.Cosignatures, cosignature)
+	sort.Slice(signedTransaction.Cosignatures, func(i, j int) bool {
+		return signedTransaction.Cosignatures[i].KeyIndex < signedTransaction.Cosignatures[j].KeyIndex
+	})
+}
+
+func (node *Node) viewPSBT(signedTransaction SignedTransaction) {
+	policy := signedTransaction.Multisig
+
+	fmt.Printf("\nTransaction %x\n", signedTransaction.TransactionID)
+	fmt.Println("From:", policy.address(), policy.Required, "of", len(policy.PublicKeys), "-", node.cosignerIDs(*policy))
+	for _, transactionOutput := range signedTransaction.payments() {
+		fmt.Println("To:", node.addressName(transactionOutput.RecipientAddress), transactionOutput.Amount)
+	}
+	fmt.Println("Fee:", signedTransaction.Fee)
+
+	signers := make([]string, 0, len(signedTransaction.Cosignatures))
+	for _, cosignature := range signedTransaction.Cosignatures {
+		if cosignature.KeyIndex < uint32(len(policy.PublicKeys)) {
+			signers = append(signers, node.addressName(addressOf(policy.PublicKeys[cosignature.KeyIndex])))
+		}
+	}
+	fmt.Printf("Signatures: %v (%v needed) %v\n", len(signers), policy.Required, strings.Join(signers, ", "))
+}
+
+// The psbt and multisig commands of the cli
+func (node *Node) psbtCommand(fields []string) {
+	var err error
+
+	switch {
+	case fields[0] == "multisig" && len(fields) >= 3 && fields[1] == "add":
+		var required uint64
+		required, err = strconv.ParseUint(fields[2], 10, 32)
+		if err != nil || len(fields) < 4 {
+			fmt.Println("multisig add needs the number of signatures and the ids of the cosigners")
+			return
+		}
+		var policy MultisigPolicy
+		if policy, err = node.addMultisig(uint32(required), fields[3:]); err == nil {
+			fmt.Println("\nMultisig address:", policy.address(), policy.Required, "of", len(policy.PublicKeys), "-", node.cosignerIDs(policy))
+		}
+
+	case fields[0] == "multisig" && len(fields) == 2 && fields[1] == "list":
+		fmt.Println("\nMultisig addresses:")
+		for _, policy := range node.multisigPolicies() {
+			node.blockchainLock.Lock()
+			balance := node.UTXOsCommitted.Balance(policy.address())
+			node.blockchainLock.Unlock()
+			fmt.Println(policy.address(), balance, "-", policy.Required, "of", len(policy.PublicKeys), "-", node.cosignerIDs(policy))
+		}
+
+	case fields[0] == "psbt" && len(fields) >= 6 && fields[1] == "create":
+		err = node.createPSBT(fields[2], fields[3], fields[4:])
+
+	case fields[0] == "psbt" && len(fields) == 3 && fields[1] == "sign":
+		err = node.signPSBT(fields[2])
+
+	case fields[0] == "psbt" && len(fields) >= 4 && fields[1] == "combine":
+		err = node.combinePSBT(fields[2], fields[3:])
+
+	case fields[0] == "psbt" && len(fields) == 3 && fields[1] == "send":
+		err = node.sendPSBT(fields[2])
+
+	default:
+		node.help()
+	}
+
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (node *Node) createPSBT(path string, from string, paymentFields []string) error {
+	address, err := parseAddress(from)
+	if err != nil {
+		return fmt.Errorf("Invalid address %v: %w", from, err)
+	}
+	policy, ok := node.multisigPolicy(address)
+	if !ok {
+		return fmt.Errorf("%v isn't a multisig address of this node, add it with multisig add", from)
+	}
+
+	payments, fee, err := node.parsePayments(paymentFields)
+	if err != nil {
+		return err
+	}
+
+	signedTransaction, err := node.createMultisigTransaction(policy, payments, fee)
+	if err != nil {
+		return err
+	}
+	if err := writePSBT(path, signedTransaction); err != nil {
+		return err
+	}
+
+	node.viewPSBT(signedTransaction)
+	fmt.Println("Written to", path)
+	return nil
+}
+
+func (node *Node) signPSBT(path string) error {
+	signedTransaction, err := readPSBT(path)
+	if err != nil {
+		return err
+	}
+
+	keyIndex := signedTransaction.Multisig.keyIndex(node.publicKey)
+	if keyIndex < 0 {
+		return errors.New("this node's key is not one of the keys of the multisig")
+	}
+
+	cosignature, err := node.privateKey.Sign(signedTransaction.TransactionID[:])
+	if err != nil {
+		return err
+	}
+	addCosignature(&signedTransaction, Cosignature{KeyIndex: uint32(keyIndex), Signature: cosignature})
+
+	if err := writePSBT(path, signedTransaction); err != nil {
+		return err
+	}
+
+	node.viewPSBT(signedTransaction)
+	return nil
+}
+
+// Merge the cosignatures of the other copies into the first file
+func (node *Node) combinePSBT(path string, others []string) error {
+	combined, err := readPSBT(path)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range others {
+		signedTransaction, err := readPSBT(other)
+		if err != nil {
+			return err
+		}
+		if signedTransaction.TransactionID != combined.TransactionID {
+			return errors.New(other + " holds a different transaction")
+		}
+		for _, cosignature := range signedTransaction.Cosignatures {
+			addCosignature(&combined, cosignature)
+		}
+	}
+
+	if err := writePSBT(path, combined); err != nil {
+		return err
+	}
+
+	node.viewPSBT(combined)
+	return nil
+}
+
+func (node *Node) sendPSBT(path string) error {
+	signedTransaction, err := readPSBT(path)
+	if err != nil {
+		return err
+	}
+
+	if rejectError := node.admitTransaction(signedTransaction); rejectError != nil {
+		return fmt.Errorf("sendPSBT: transaction rejected - %w", rejectError)
+	}
+	node.broadcastTransaction(signedTransaction)
+
+	fmt.Printf("\nTransaction %x sent\n", signedTransaction.TransactionID)
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"noobcash/rpcclient"
+)
+
+/*
+rpc calls a method of a running node through its JSON-RPC server and prints the result. Each
+param that is valid JSON is passed as it is, and anything else as a string, so that
+rpc getBalance id1 and rpc sendTransaction '[{"receiver": "id1", "amount": 10}]' 1 both work.
+*/
+func rpcCommand(args []string) {
+	flags := flag.NewFlagSet("rpc", flag.ExitOnError)
+	endpoint := flags.String("endpoint", "http://localhost:58090", "URL of the node's -rpcAddress, or the path of its -rpcSocket")
+	token := flags.String("token", os.Getenv("MOCKCHAIN_API_TOKEN"), "The node's -apiToken, which sendTransaction needs over HTTP")
+	flags.Usage = rpcHelp
+	flags.Parse(args)
+
+	if flags.NArg() < 1 {
+		rpcHelp()
+		os.Exit(2)
+	}
+
+	params := make([]json.RawMessage, 0, flags.NArg()-1)
+	for _, arg := range flags.Args()[1:] {
+		if json.Valid([]byte(arg)) {
+			params = append(params, json.RawMessage(arg))
+		} else {
+			quoted, _ := json.Marshal(arg)
+			params = append(params, quoted)
+		}
+	}
+
+	client, err := rpcclient.DialWithToken(*endpoint, *token)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	var result json.RawMessage
+	if err := client.Call(flags.Arg(0), params, &result); err != nil {
+		log.Fatal(err)
+	}
+
+	indented, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(indented))
+}
+
+func rpcHelp() {
+	fmt.Println("usage: noobcash.elf rpc [-endpoint <url|socket>] [-token <token>] <method> [param ...]")
+	fmt.Println("")
+	fmt.Println("-endpoint")
+	fmt.Println("\tthe node's -rpcAddress as a URL, http://localhost:58090 by default, or the path of its -rpcSocket")
+	fmt.Println("-token")
+	fmt.Println("\tthe node's -apiToken, which sendTransaction needs over HTTP, MOCKCHAIN_API_TOKEN by default")
+	fmt.Println("")
+	fmt.Println("The methods are getStatus, getBlocks, getBlock, getBlockByHash, getTransaction, sendTransaction,")
+	fmt.Println("getBalance, listUnspent, getHistory, getPeerInfo and getMempool (see rpc.go)")
+	fmt.Println("\tExample: rpc -endpoint /tmp/node0.sock sendTransaction '[{\"receiver\": \"id1\", \"amount\": 10}]' 1")
+}
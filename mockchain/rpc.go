@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+/*
+Wallets and scripts can drive a node through its JSON-RPC 2.0 server instead of its stdin. The
+server answers POST requests on the HTTP address given with -rpcAddress, and connections to the
+Unix socket given with -rpcSocket, where requests and responses are JSON values one after the
+other. Either takes a single request or a batch of them in an array. The rpcclient package is a
+Go client for it.
+
+The methods take their params by position or by name, and answer with the same JSON as the
+REST API (see api-types.go):
+
+	getStatus
+	getBlocks        [from] [limit]   block headers from the block with Index from down
+	getBlock         index
+	getBlockByHash   hash
+	getTransaction   id
+	sendTransaction  payments [fee]   payments are [{"receiver": "id1", "amount": 10}, ...]
+	getBalance       [address]        the node's own wallet if address is missing
+	listUnspent      [address]
+	getHistory
+	getPeerInfo
+	getMempool
+
+sendTransaction spends the node's coins, so over HTTP it needs the -apiToken of the node as
+"Authorization: Bearer <token>", and is turned off if the node has none. Anyone who can open the
+socket may call it, so the socket is only open to the user running the node. Like the REST API,
+the HTTP server only takes JSON and turns away requests from pages of other origins, which a
+browser would otherwise send for any web page.
+*/
+
+// The error codes of the JSON-RPC 2.0 spec, and ours in the range it leaves to servers
+const (
+	rpcParseError          = -32700
+	rpcInvalidRequest      = -32600
+	rpcMethodNotFound      = -32601
+	rpcInvalidParams       = -32602
+	rpcInternalError       = -32603
+	rpcNotFound            = -32001
+	rpcTransactionRejected = -32002
+	rpcUnavailable         = -32003
+	rpcForbidden           = -32004
+)
+
+const maxRPCRequestSize = 1 << 20
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	ID      json.RawMessage `json:"id"` // missing for a notification, which gets no response
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *rpcError) Error() string {
+	return err.Message
+}
+
+type rpcMethod struct {
+	params  []string // the names of the params, in the order they are given by position
+	spends  bool     // the method spends the node's coins
+	handler func(node *Node, params rpcParams) (any, error)
+}
+
+var rpcMethods = map[string]rpcMethod{
+	"getStatus":       {nil, false, (*Node).rpcGetStatus},
+	"getBlocks":       {[]string{"from", "limit"}, false, (*Node).rpcGetBlocks},
+	"getBlock":        {[]string{"index"}, false, (*Node).rpcGetBlock},
+	"getBlockByHash":  {[]string{"hash"}, false, (*Node).rpcGetBlockByHash},
+	"getTransaction":  {[]string{"id"}, false, (*Node).rpcGetTransaction},
+	"sendTransaction": {[]string{"payments", "fee"}, true, (*Node).rpcSendTransaction},
+	"getBalance":      {[]string{"address"}, false, (*Node).rpcGetBalance},
+	"listUnspent":     {[]string{"address"}, false, (*Node).rpcListUnspent},
+	"getHistory":      {nil, false, (*Node).rpcGetHistory},
+	"getPeerInfo":     {nil, false, (*Node).rpcGetPeerInfo},
+	"getMempool":      {nil, false, (*Node).rpcGetMempool},
+}
+
+// The params of a request by name, whichever way they were given
+type rpcParams map[string]json.RawMessage
+
+// Decode the param into value, leaving value alone if the param is missing and not required
+func (params rpcParams) get(name string, value any, required bool) error {
+	raw, ok := params[name]
+	if !ok || bytes.Equal(raw, []byte("null")) {
+		if required {
+			return apiErrorf(http.StatusBadRequest, "missing param %v", name)
+		}
+		return nil
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		return apiErrorf(http.StatusBadRequest, "invalid param %v: %v", name, err)
+	}
+	return nil
+}
+
+func (params rpcParams) hash(name string) ([32]byte, error) {
+	var encoded string
+	if err := params.get(name, &encoded, true); err != nil {
+		return [32]byte{}, err
+	}
+	hash, err := parseHexHash(encoded)
+	if err != nil {
+		return hash, apiErrorf(http.StatusBadRequest, "%v: %v", name, err)
+	}
+	return hash, nil
+}
+
+func (node *Node) serveRPC(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.Header().Set("Allow", http.MethodPost)
+			http.Error(writer, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := checkSameOrigin(request); err != nil {
+			http.Error(writer, err.Error(), http.StatusForbidden)
+			return
+		}
+		if mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			http.Error(writer, "JSON-RPC requests must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxRPCRequestSize))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		response := node.handleRPC(body, node.checkToken(request))
+		if response == nil {
+			writer.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(response)
+	})
+
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	log.Println("serveRPC: listening on", address)
+	if err := server.ListenAndServe(); err != nil {
+		log.Println("serveRPC:", err)
+	}
+}
+
+func (node *Node) serveRPCSocket(path string) {
+	// A socket left behind by a node that didn't shut down would keep us from listening
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		log.Println("serveRPCSocket:", err)
+		return
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		log.Println("serveRPCSocket:", err)
+		listener.Close()
+		return
+	}
+	log.Println("serveRPCSocket: listening on", path)
+
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			log.Println("serveRPCSocket:", err)
+			return
+		}
+		go node.handleRPCConnection(connection)
+	}
+}
+
+// Answer the requests of the connection in order, until it is closed or sends something that isn't JSON
+func (node *Node) handleRPCConnection(connection net.Conn) {
+	defer connection.Close()
+
+	decoder := json.NewDecoder(connection)
+	for {
+		var request json.RawMessage
+		err := decoder.Decode(&request)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			response, _ := json.Marshal(rpcErrorResponse(nil, rpcParseError, err.Error()))
+			connection.Write(append(response, '\n'))
+			return
+		}
+
+		if response := node.handleRPC(request, nil); response != nil {
+			if _, err := connection.Write(append(response, '\n')); err != nil {
+				return
+			}
+		}
+	}
+}
+
+/*
+The response to a request or a batch, nil if they were all notifications. spending says why the
+requests can't spend our coins, and is nil if they can.
+*/
+func (node *Node) handleRPC(body []byte, spending error) []byte {
+	var response any
+
+	if trimmed := bytes.TrimLeft(body, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			response = rpcErrorResponse(nil, rpcParseError, err.Error())
+		} else if len(batch) == 0 {
+			response = rpcErrorResponse(nil, rpcInvalidRequest, "empty batch")
+		} else {
+			responses := make([]rpcResponse, 0, len(batch))
+			for _, request := range batch {
+				if requestResponse, ok := node.handleRPCRequest(request, spending); ok {
+					responses = append(responses, requestResponse)
+				}
+			}
+			if len(responses) == 0 {
+				return nil
+			}
+			response = responses
+		}
+	} else if !json.Valid(body) {
+		response = rpcErrorResponse(nil, rpcParseError, "the request is not valid JSON")
+	} else {
+		requestResponse, ok := node.handleRPCRequest(body, spending)
+		if !ok {
+			return nil
+		}
+		response = requestResponse
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		log.Println("handleRPC:", err)
+		encoded, _ = json.Marshal(rpcErrorResponse(nil, rpcInternalError, err.Error()))
+	}
+	return encoded
+}
+
+// Run a single request, with false if it was a notification
+func (node *Node) handleRPCRequest(body json.RawMessage, spending error) (rpcResponse, bool) {
+	var request rpcRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return rpcErrorResponse(nil, rpcInvalidRequest, "a request must be an object"), true
+	}
+	if request.JSONRPC != "2.0" || request.Method == "" {
+		return rpcErrorResponse(request.ID, rpcInvalidRequest, `a request must have "jsonrpc": "2.0" and a method`), true
+	}
+	notification := request.ID == nil
+
+	result, err := node.callRPC(request, spending)
+	if notification {
+		return rpcResponse{}, false
+	}
+	if err != nil {
+		return rpcErrorResponse(request.ID, rpcErrorCode(err), err.Error()), true
+	}
+	return rpcResponse{JSONRPC: "2.0", Result: result, ID: request.ID}, true
+}
+
+func (node *Node) callRPC(request rpcRequest, spending error) (any, error) {
+	method, ok := rpcMethods[request.Method]
+	if !ok {
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "no method " + request.Method}
+	}
+	if method.spends && spending != nil {
+		return nil, &rpcError{Code: rpcForbidden, Message: request.Method + ": " + spending.Error()}
+	}
+
+	params := make(rpcParams)
+	trimmed := bytes.TrimSpace(request.Params)
+	switch {
+	case len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")):
+		// no params
+
+	case trimmed[0] == '[':
+		var positional []json.RawMessage
+		if err := json.Unmarshal(trimmed, &positional); err != nil {
+			return nil, apiErrorf(http.StatusBadRequest, "%v", err)
+		}
+		if len(positional) > len(method.params) {
+			return nil, apiErrorf(http.StatusBadRequest, "%v takes at most %d params", request.Method, len(method.params))
+		}
+		for index, param := range positional {
+			params[method.params[index]] = param
+		}
+
+	case trimmed[0] == '{':
+		if err := json.Unmarshal(trimmed, &params); err != nil {
+			return nil, apiErrorf(http.StatusBadRequest, "%v", err)
+		}
+		for name := range params {
+			if !contains(method.params, name) {
+				return nil, apiErrorf(http.StatusBadRequest, "%v takes no param %v", request.Method, name)
+			}
+		}
+
+	default:
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "params must be an array or an object"}
+	}
+
+	node.blockchainLock.Lock()
+	chainLength := len(node.blockchain)
+	node.blockchainLock.Unlock()
+	if chainLength == 0 {
+		return nil, apiErrorf(http.StatusServiceUnavailable, "the node hasn't received the blockchain yet")
+	}
+
+	return method.handler(node, params)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// The code of the error, from the HTTP status of the apiErrors the queries fail with
+func rpcErrorCode(err error) int {
+	var responseError *rpcError
+	if errors.As(err, &responseError) {
+		return responseError.Code
+	}
+
+	var queryError *apiError
+	if !errors.As(err, &queryError) {
+		return rpcInternalError
+	}
+	switch queryError.Status {
+	case http.StatusBadRequest:
+		return rpcInvalidParams
+	case http.StatusNotFound:
+		return rpcNotFound
+	case http.StatusUnprocessableEntity:
+		return rpcTransactionRejected
+	case http.StatusServiceUnavailable:
+		return rpcUnavailable
+	}
+	return rpcInternalError
+}
+
+func rpcErrorResponse(id json.RawMessage, code int, message string) rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: code, Message: message}, ID: id}
+}
+
+func (node *Node) rpcGetStatus(params rpcParams) (any, error) {
+	return node.queryStatus(), nil
+}
+
+func (node *Node) rpcGetBlocks(params rpcParams) (any, error) {
+	from := node.tipIndex()
+	limit := defaultBlockLimit
+	if err := params.get("from", &from, false); err != nil {
+		return nil, err
+	}
+	if err := params.get("limit", &limit, false); err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > maxBlockLimit {
+		return nil, apiErrorf(http.StatusBadRequest, "limit must be a number from 1 to %v", maxBlockLimit)
+	}
+	return node.queryBlocks(from, limit), nil
+}
+
+func (node *Node) rpcGetBlock(params rpcParams) (any, error) {
+	var index uint
+	if err := params.get("index", &index, true); err != nil {
+		return nil, err
+	}
+	return node.queryBlockByIndex(index)
+}
+
+func (node *Node) rpcGetBlockByHash(params rpcParams) (any, error) {
+	hash, err := params.hash("hash")
+	if err != nil {
+		return nil, err
+	}
+	return node.queryBlockByHash(hash)
+}
+
+func (node *Node) rpcGetTransaction(params rpcParams) (any, error) {
+	transactionID, err := params.hash("id")
+	if err != nil {
+		return nil, err
+	}
+	return node.queryTransaction(transactionID)
+}
+
+func (node *Node) rpcSendTransaction(params rpcParams) (any, error) {
+	var request APITransactionRequest
+	if err := params.get("payments", &request.Payments, true); err != nil {
+		return nil, err
+	}
+	if err := params.get("fee", &request.Fee, false); err != nil {
+		return nil, err
+	}
+	return node.sendPayments(request)
+}
+
+func (node *Node) rpcGetBalance(params rpcParams) (any, error) {
+	address, err := node.rpcAddress(params)
+	if err != nil {
+		return nil, err
+	}
+	return node.queryBalance(address), nil
+}
+
+func (node *Node) rpcListUnspent(params rpcParams) (any, error) {
+	address, err := node.rpcAddress(params)
+	if err != nil {
+		return nil, err
+	}
+	return node.queryUTXOs(address), nil
+}
+
+func (node *Node) rpcGetHistory(params rpcParams) (any, error) {
+	return node.queryHistory(), nil
+}
+
+func (node *Node) rpcGetPeerInfo(params rpcParams) (any, error) {
+	return node.queryPeers(), nil
+}
+
+func (node *Node) rpcGetMempool(params rpcParams) (any, error) {
+	return node.queryMempool(), nil
+}
+
+// The address param, the node's own wallet if it is missing
+func (node *Node) rpcAddress(params rpcParams) (Address, error) {
+	var param string
+	if err := params.get("address", &param, false); err != nil {
+		return Address{}, err
+	}
+	if param == "" {
+		return node.walletAddress, nil
+	}
+	return node.queryAddress(param)
+}
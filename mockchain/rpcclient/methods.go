@@ -0,0 +1,185 @@
+package rpcclient
+
+import "time"
+
+/*
+The results of the methods of the node. Hashes and ids are hex, addresses are Base58Check and
+amounts are coins.
+*/
+
+type ChainParams struct {
+	NumNodes         int
+	Difficulty       int
+	Capacity         int
+	Genesis          map[string]uint
+	TargetBlockTime  float64
+	RetargetInterval int
+	BlockReward      uint
+	HalvingInterval  int
+}
+
+type Status struct {
+	ID          string      `json:"id"`
+	Address     string      `json:"address"`
+	Height      uint        `json:"height"`
+	TipHash     string      `json:"tipHash"`
+	MempoolSize int         `json:"mempoolSize"`
+	Peers       int         `json:"peers"`
+	Params      ChainParams `json:"params"`
+}
+
+type BlockSummary struct {
+	Index            uint   `json:"index"`
+	Hash             string `json:"hash"`
+	PreviousHash     string `json:"previousHash"`
+	MerkleRoot       string `json:"merkleRoot"`
+	WitnessRoot      string `json:"witnessRoot"`
+	Nonce            string `json:"nonce"`
+	Target           string `json:"target"`
+	Timestamp        int64  `json:"timestamp"` // milliseconds since the epoch
+	Miner            string `json:"miner"`
+	TransactionCount int    `json:"transactionCount"`
+}
+
+type Block struct {
+	BlockSummary
+	Transactions []Transaction `json:"transactions"`
+}
+
+type Transaction struct {
+	ID            string   `json:"id"`
+	Sender        string   `json:"sender"`
+	SenderID      string   `json:"senderId"`
+	Coinbase      bool     `json:"coinbase"`
+	Fee           uint     `json:"fee"`
+	Inputs        []Input  `json:"inputs"`
+	Outputs       []Output `json:"outputs"`
+	Status        string   `json:"status"` // "confirmed" or "pending"
+	BlockIndex    *uint    `json:"blockIndex"`
+	Confirmations uint     `json:"confirmations"`
+}
+
+type Input struct {
+	PreviousOutputID string `json:"previousOutputId"`
+	UnlockingScript  string `json:"unlockingScript"`
+}
+
+type Output struct {
+	ID            string `json:"id"`
+	TransactionID string `json:"transactionId"`
+	Address       string `json:"address"`
+	Owner         string `json:"owner"`
+	Amount        uint   `json:"amount"`
+	LockingScript string `json:"lockingScript"`
+}
+
+type Balance struct {
+	Address string `json:"address"`
+	ID      string `json:"id"`
+	Balance uint   `json:"balance"`
+	Outputs int    `json:"outputs"`
+}
+
+type HistoryEntry struct {
+	TransactionID string `json:"transactionId"`
+	Direction     string `json:"direction"` // "to" or "from"
+	Counterparty  string `json:"counterparty"`
+	Amount        uint   `json:"amount"`
+	Status        string `json:"status"`
+}
+
+type Peer struct {
+	ID            string     `json:"id"`
+	Address       string     `json:"address"`
+	WalletAddress string     `json:"walletAddress"`
+	State         string     `json:"state"`
+	RTT           float64    `json:"rtt"` // milliseconds
+	LastSeen      *time.Time `json:"lastSeen"`
+}
+
+type Mempool struct {
+	Size         int           `json:"size"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// A payment of sendTransaction. The receiver is the id of a node or an address
+type Payment struct {
+	Receiver string `json:"receiver"`
+	Amount   uint   `json:"amount"`
+}
+
+func (client *Client) GetStatus() (Status, error) {
+	var status Status
+	err := client.Call("getStatus", nil, &status)
+	return status, err
+}
+
+// The headers of up to limit blocks, from the block with Index from down to the genesis block
+func (client *Client) GetBlocks(from uint, limit int) ([]BlockSummary, error) {
+	var blocks []BlockSummary
+	err := client.Call("getBlocks", []any{from, limit}, &blocks)
+	return blocks, err
+}
+
+func (client *Client) GetBlock(index uint) (Block, error) {
+	var block Block
+	err := client.Call("getBlock", []any{index}, &block)
+	return block, err
+}
+
+func (client *Client) GetBlockByHash(hash string) (Block, error) {
+	var block Block
+	err := client.Call("getBlockByHash", []any{hash}, &block)
+	return block, err
+}
+
+func (client *Client) GetTransaction(id string) (Transaction, error) {
+	var transaction Transaction
+	err := client.Call("getTransaction", []any{id}, &transaction)
+	return transaction, err
+}
+
+// Send coins from the wallet of the node, with the transaction once it is in the mempool
+func (client *Client) SendTransaction(payments []Payment, fee uint) (Transaction, error) {
+	var transaction Transaction
+	err := client.Call("sendTransaction", []any{payments, fee}, &transaction)
+	return transaction, err
+}
+
+// The confirmed balance of an address or node id, or of the node's own wallet for ""
+func (client *Client) GetBalance(address string) (Balance, error) {
+	var balance Balance
+	err := client.Call("getBalance", addressParams(address), &balance)
+	return balance, err
+}
+
+func (client *Client) ListUnspent(address string) ([]Output, error) {
+	var outputs []Output
+	err := client.Call("listUnspent", addressParams(address), &outputs)
+	return outputs, err
+}
+
+func (client *Client) GetHistory() ([]HistoryEntry, error) {
+	var history []HistoryEntry
+	err := client.Call("getHistory", nil, &history)
+	return history, err
+}
+
+func (client *Client) GetPeerInfo() ([]Peer, error) {
+	var peers []Peer
+	err := client.Call("getPeerInfo", nil, &peers)
+	return peers, err
+}
+
+func (client *Client) GetMempool() (Mempool, error) {
+	var mempool Mempool
+	err := client.Call("getMempool", nil, &mempool)
+	return mempool, err
+}
+
+func addressParams(address string) []any {
+	if address == "" {
+		return nil
+	}
+	return []any{address}
+}
@@ -0,0 +1,234 @@
+/*
+Package rpcclient calls the JSON-RPC 2.0 server of a noobcash node, over HTTP or over the node's
+Unix socket:
+
+	client, err := rpcclient.Dial("unix:///tmp/node0.sock") // or "http://localhost:58090"
+	if err != nil {
+		...
+	}
+	defer client.Close()
+
+	balance, err := client.GetBalance("id1")
+	transaction, err := client.SendTransaction([]rpcclient.Payment{{Receiver: "id1", Amount: 10}}, 1)
+
+Methods the client has no wrapper for can be called with Call, and several calls can go to the
+node in one round trip with Batch.
+*/
+package rpcclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The error codes of the JSON-RPC 2.0 spec, and the ones of the node
+const (
+	CodeParseError          = -32700
+	CodeInvalidRequest      = -32600
+	CodeMethodNotFound      = -32601
+	CodeInvalidParams       = -32602
+	CodeInternalError       = -32603
+	CodeNotFound            = -32001
+	CodeTransactionRejected = -32002
+	CodeUnavailable         = -32003
+	CodeForbidden           = -32004 // the call spends the node's coins and the token is missing or wrong
+)
+
+var ErrNoResponse = errors.New("rpcclient: the node didn't answer the call")
+
+// An error the node answered a call with
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("rpc error %d: %v", err.Code, err.Message)
+}
+
+// A call of a batch. Result is decoded into once the batch is done, unless Error is set
+type Call struct {
+	Method string
+	Params any // an array for params by position, a struct or map for params by name, nil for none
+	Result any
+	Error  error
+}
+
+type request struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+	ID      uint64 `json:"id"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+	ID     *uint64         `json:"id"`
+}
+
+// Sends the encoded request or batch and returns what the node answered
+type transport interface {
+	roundTrip(body []byte) ([]byte, error)
+	close() error
+}
+
+type Client struct {
+	transport transport
+	nextID    atomic.Uint64
+}
+
+/*
+Connect to a node. The endpoint is the URL of its -rpcAddress, such as http://localhost:58090,
+or the path of its -rpcSocket, optionally written as unix:///path.
+*/
+func Dial(endpoint string) (*Client, error) {
+	return DialWithToken(endpoint, "")
+}
+
+// Connect to a node over HTTP with its -apiToken, which sendTransaction needs there
+func DialWithToken(endpoint string, token string) (*Client, error) {
+	if strings.HasPrefix(endpoint, "http://") || strings.HasPrefix(endpoint, "https://") {
+		return &Client{transport: &httpTransport{url: endpoint, token: token}}, nil
+	}
+
+	connection, err := net.Dial("unix", strings.TrimPrefix(endpoint, "unix://"))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{transport: &socketTransport{connection: connection, reader: bufio.NewReader(connection)}}, nil
+}
+
+func (client *Client) Close() error {
+	return client.transport.close()
+}
+
+// Call the method and decode its result into result, unless that is nil
+func (client *Client) Call(method string, params any, result any) error {
+	call := &Call{Method: method, Params: params, Result: result}
+	if err := client.Batch([]*Call{call}); err != nil {
+		return err
+	}
+	return call.Error
+}
+
+/*
+Send the calls in one batch. The error is for the batch as a whole; each call that the node
+answered with an error has it in its Error.
+*/
+func (client *Client) Batch(calls []*Call) error {
+	if len(calls) == 0 {
+		return nil
+	}
+
+	requests := make([]request, len(calls))
+	callsByID := make(map[uint64]*Call, len(calls))
+	for index, call := range calls {
+		id := client.nextID.Add(1)
+		requests[index] = request{JSONRPC: "2.0", Method: call.Method, Params: call.Params, ID: id}
+		callsByID[id] = call
+		call.Error = ErrNoResponse
+	}
+
+	body, err := json.Marshal(requests)
+	if err != nil {
+		return err
+	}
+	answer, err := client.transport.roundTrip(body)
+	if err != nil {
+		return err
+	}
+
+	var responses []response
+	if err := json.Unmarshal(answer, &responses); err != nil {
+		// A batch the node couldn't read at all is answered with a single error
+		var single response
+		if json.Unmarshal(answer, &single) == nil && single.Error != nil {
+			return single.Error
+		}
+		return fmt.Errorf("rpcclient: invalid response: %w", err)
+	}
+
+	for _, response := range responses {
+		if response.ID == nil {
+			continue
+		}
+		call, ok := callsByID[*response.ID]
+		if !ok {
+			continue
+		}
+		switch {
+		case response.Error != nil:
+			call.Error = response.Error
+		case call.Result != nil:
+			call.Error = json.Unmarshal(response.Result, call.Result)
+		default:
+			call.Error = nil
+		}
+	}
+	return nil
+}
+
+type httpTransport struct {
+	url   string
+	token string
+}
+
+func (transport *httpTransport) roundTrip(body []byte) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodPost, transport.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if transport.token != "" {
+		request.Header.Set("Authorization", "Bearer "+transport.token)
+	}
+
+	answer, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer answer.Body.Close()
+
+	var buffer bytes.Buffer
+	if _, err := buffer.ReadFrom(answer.Body); err != nil {
+		return nil, err
+	}
+	if answer.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rpcclient: %v: %v", answer.Status, strings.TrimSpace(buffer.String()))
+	}
+	return buffer.Bytes(), nil
+}
+
+func (transport *httpTransport) close() error {
+	return nil
+}
+
+// The node answers every request on the socket with one line of JSON, in order
+type socketTransport struct {
+	connection net.Conn
+	reader     *bufio.Reader
+	lock       sync.Mutex
+}
+
+func (transport *socketTransport) roundTrip(body []byte) ([]byte, error) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	if _, err := transport.connection.Write(append(body, '\n')); err != nil {
+		return nil, err
+	}
+	return transport.reader.ReadBytes('\n')
+}
+
+func (transport *socketTransport) close() error {
+	return transport.connection.Close()
+}
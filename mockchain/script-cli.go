@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"noobcash/script"
+)
+
+/*
+script test|asm|disasm work with locking scripts without a node. test runs the test vectors of the
+script language and exits with an error if any of them fails.
+*/
+func scriptCommand(args []string) {
+	switch {
+	case len(args) >= 1 && len(args) <= 2 && args[0] == "test":
+		path := "script/testdata/vectors.json"
+		if len(args) == 2 {
+			path = args[1]
+		}
+
+		vectors, err := script.ReadVectors(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		failed := 0
+		for index, vector := range vectors {
+			if err := vector.Run(); err != nil {
+				fmt.Printf("FAIL %d %v: %v\n", index, vector.Comment, err)
+				failed++
+			}
+		}
+		fmt.Printf("%d of %d vectors passed\n", len(vectors)-failed, len(vectors))
+		if failed > 0 {
+			os.Exit(1)
+		}
+
+	case len(args) >= 2 && args[0] == "asm":
+		assembled, err := script.Assemble(strings.Join(args[1:], " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hex.EncodeToString(assembled))
+
+	case len(args) == 2 && args[0] == "disasm":
+		decoded, err := hex.DecodeString(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(script.Disassemble(decoded))
+
+	default:
+		scriptHelp()
+		os.Exit(2)
+	}
+}
+
+func scriptHelp() {
+	fmt.Println("usage: noobcash.elf script <command>")
+	fmt.Println("")
+	fmt.Println("test [vectorsFile]")
+	fmt.Println("\truns the test vectors of the script language, script/testdata/vectors.json by default")
+	fmt.Println("")
+	fmt.Println("asm <word> [<word> ...]")
+	fmt.Println("\tprints the script in hex")
+	fmt.Println("\tExample: asm OP_SHA256 0xbc07c56cbf4f262b6fbb48bc72cc58040e96ff9127132dc305cb223803f55667 OP_EQUAL")
+	fmt.Println("")
+	fmt.Println("disasm <hex>")
+	fmt.Println("\tprints the words of the script")
+}
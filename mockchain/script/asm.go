@@ -0,0 +1,153 @@
+package script
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+Scripts are written out as words separated by spaces, the way the CLI and the test vectors take
+them:
+
+	OP_DUP       an opcode by its name
+	0x0123abcd   a push of the bytes in hex
+	1000         a push of the number, with OP_0 to OP_16 for the numbers up to 16
+*/
+
+var opcodeNames = map[byte]string{
+	Op0:                   "OP_0",
+	OpPushData1:           "OP_PUSHDATA1",
+	OpPushData2:           "OP_PUSHDATA2",
+	OpIf:                  "OP_IF",
+	OpNotIf:               "OP_NOTIF",
+	OpElse:                "OP_ELSE",
+	OpEndIf:               "OP_ENDIF",
+	OpVerify:              "OP_VERIFY",
+	OpReturn:              "OP_RETURN",
+	OpDrop:                "OP_DROP",
+	OpDup:                 "OP_DUP",
+	OpSwap:                "OP_SWAP",
+	OpEqual:               "OP_EQUAL",
+	OpEqualVerify:         "OP_EQUALVERIFY",
+	OpSHA256:              "OP_SHA256",
+	OpHash160:             "OP_HASH160",
+	OpCheckSig:            "OP_CHECKSIG",
+	OpCheckSigVerify:      "OP_CHECKSIGVERIFY",
+	OpCheckMultisig:       "OP_CHECKMULTISIG",
+	OpCheckMultisigVerify: "OP_CHECKMULTISIGVERIFY",
+	OpCheckLockTimeVerify: "OP_CHECKLOCKTIMEVERIFY",
+	OpCheckSequenceVerify: "OP_CHECKSEQUENCEVERIFY",
+	OpCheckDeadlineVerify: "OP_CHECKDEADLINEVERIFY",
+}
+
+var opcodesByName = make(map[string]byte)
+
+var ErrSyntax = errors.New("script: invalid word")
+
+func init() {
+	for opcode := Op1; opcode <= Op16; opcode++ {
+		opcodeNames[opcode] = fmt.Sprintf("OP_%d", opcode-Op1+1)
+	}
+	for opcode, name := range opcodeNames {
+		opcodesByName[name] = opcode
+	}
+}
+
+func OpcodeName(opcode byte) string {
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+	if opcode > Op0 && opcode < OpPushData1 {
+		return fmt.Sprintf("OP_DATA_%d", opcode)
+	}
+	return fmt.Sprintf("OP_UNKNOWN_0x%02x", opcode)
+}
+
+// Builds a script one opcode at a time
+type Builder struct {
+	script []byte
+}
+
+func (builder *Builder) AddOp(opcodes ...byte) *Builder {
+	builder.script = append(builder.script, opcodes...)
+	return builder
+}
+
+// Add the shortest push of the data
+func (builder *Builder) AddData(data []byte) *Builder {
+	switch {
+	case len(data) == 0:
+		builder.script = append(builder.script, Op0)
+	case len(data) < int(OpPushData1):
+		builder.script = append(builder.script, byte(len(data)))
+	case len(data) <= 0xff:
+		builder.script = append(builder.script, OpPushData1, byte(len(data)))
+	default:
+		builder.script = binary.BigEndian.AppendUint16(append(builder.script, OpPushData2), uint16(len(data)))
+	}
+	builder.script = append(builder.script, data...)
+	return builder
+}
+
+func (builder *Builder) AddNumber(number uint64) *Builder {
+	if number >= 1 && number <= 16 {
+		return builder.AddOp(Op1 + byte(number) - 1)
+	}
+	return builder.AddData(EncodeNumber(number))
+}
+
+func (builder *Builder) Script() []byte {
+	return builder.script
+}
+
+func Assemble(text string) ([]byte, error) {
+	var builder Builder
+
+	for _, word := range strings.Fields(text) {
+		if opcode, ok := opcodesByName[word]; ok && opcode != OpPushData1 && opcode != OpPushData2 {
+			builder.AddOp(opcode)
+
+		} else if strings.HasPrefix(word, "0x") {
+			data, err := hex.DecodeString(word[2:])
+			if err != nil || len(data) > MaxPushSize {
+				return nil, fmt.Errorf("%w %v", ErrSyntax, word)
+			}
+			builder.AddData(data)
+
+		} else if number, err := strconv.ParseUint(word, 10, 64); err == nil {
+			builder.AddNumber(number)
+
+		} else {
+			return nil, fmt.Errorf("%w %v", ErrSyntax, word)
+		}
+	}
+
+	if len(builder.script) > MaxScriptSize {
+		return nil, ErrScriptSize
+	}
+	return builder.Script(), nil
+}
+
+// The script in the words Assemble takes, as far as it can be read
+func Disassemble(script []byte) string {
+	words := make([]string, 0)
+	for pc := 0; pc < len(script); {
+		opcode, data, next, err := parseOp(script, pc)
+		if err != nil {
+			words = append(words, "[error: "+err.Error()+"]")
+			break
+		}
+
+		if data == nil || opcode == Op0 || (opcode >= Op1 && opcode <= Op16) {
+			words = append(words, OpcodeName(opcode))
+		} else {
+			words = append(words, "0x"+hex.EncodeToString(data))
+		}
+		pc = next
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,601 @@
+/*
+Package script runs the small stack-based language that outputs can be locked with. An output with
+a locking script can be spent by whoever provides an unlocking script that makes it succeed.
+
+The unlocking script of the input runs first. It may only push data. The locking script of the
+output then runs on the stack the unlocking script left behind. The spend is valid if the scripts
+run without an error and leave exactly one item on the stack, which is true. An item is true if
+any of its bytes is not zero.
+
+Scripts are sequences of opcodes, where the pushes carry their data inline:
+
+	0x00        OP_0          push an empty item
+	0x01-0x4b   push the next 1 to 75 bytes
+	0x4c        OP_PUSHDATA1  push the number of bytes given by the next byte
+	0x4d        OP_PUSHDATA2  push the number of bytes given by the next 2 bytes, big-endian
+	0x51-0x60   OP_1-OP_16    push the number 1 to 16
+
+	0x63        OP_IF         run the branch if the popped item is true
+	0x64        OP_NOTIF      run the branch if the popped item is false
+	0x67        OP_ELSE       run the other branch
+	0x68        OP_ENDIF      end the branch
+	0x69        OP_VERIFY     fail unless the popped item is true
+	0x6a        OP_RETURN     fail
+
+	0x75        OP_DROP       pop an item
+	0x76        OP_DUP        push a copy of the top item
+	0x7c        OP_SWAP       swap the two top items
+	0x87        OP_EQUAL      pop two items and push whether they are equal
+	0x88        OP_EQUALVERIFY  OP_EQUAL then OP_VERIFY
+
+	0xa8        OP_SHA256     replace the top item with its SHA-256
+	0xa9        OP_HASH160    replace the top item with the first 20 bytes of its SHA-256
+
+	0xac        OP_CHECKSIG   pop a public key and a signature, push whether the signature is valid
+	0xad        OP_CHECKSIGVERIFY  OP_CHECKSIG then OP_VERIFY
+	0xae        OP_CHECKMULTISIG  pop n, n public keys, m and m signatures, push whether every
+	                          signature is valid for one of the keys, in the order of the keys
+	0xaf        OP_CHECKMULTISIGVERIFY  OP_CHECKMULTISIG then OP_VERIFY
+
+	0xb1        OP_CHECKLOCKTIMEVERIFY  fail unless the spending transaction goes in a block whose
+	                          Index is at least the top item, which stays on the stack
+	0xb2        OP_CHECKSEQUENCEVERIFY  fail unless the spending transaction goes in a block at least
+	                          the top item blocks after the block of the spent output, which stays
+	                          on the stack
+	0xb3        OP_CHECKDEADLINEVERIFY  fail unless the spending transaction goes in a block whose
+	                          Index is below the top item, which stays on the stack
+
+Any other opcode fails the script, even in a branch that doesn't run.
+
+Numbers are unsigned big-endian integers of at most 8 bytes without leading zero bytes, so zero
+is the empty item. Public keys are the scheme byte followed by the key, and signatures are tagged
+with their scheme, as in the signature package. Every signature signs the Digest of the Context,
+the TransactionID of the spending transaction. A signature that fails to verify must be empty,
+so that a failed check can't be turned into a different one by changing the signature.
+
+Scripts that could take long to run are turned away by the limits below.
+*/
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"noobcash/signature"
+)
+
+const (
+	MaxScriptSize      = 2048 // bytes in either script
+	MaxPushSize        = 520  // bytes in a single item
+	MaxStackSize       = 100  // items on the stack
+	MaxOps             = 200  // opcodes other than pushes, in both scripts together
+	MaxMultisigKeys    = 20   // public keys of an OP_CHECKMULTISIG
+	MaxSignatureChecks = 20   // keys checked by the signature opcodes, in both scripts together
+	MaxNumberSize      = 8    // bytes in a number
+)
+
+const (
+	Op0                   byte = 0x00
+	OpPushData1           byte = 0x4c
+	OpPushData2           byte = 0x4d
+	Op1                   byte = 0x51
+	Op16                  byte = 0x60
+	OpIf                  byte = 0x63
+	OpNotIf               byte = 0x64
+	OpElse                byte = 0x67
+	OpEndIf               byte = 0x68
+	OpVerify              byte = 0x69
+	OpReturn              byte = 0x6a
+	OpDrop                byte = 0x75
+	OpDup                 byte = 0x76
+	OpSwap                byte = 0x7c
+	OpEqual               byte = 0x87
+	OpEqualVerify         byte = 0x88
+	OpSHA256              byte = 0xa8
+	OpHash160             byte = 0xa9
+	OpCheckSig            byte = 0xac
+	OpCheckSigVerify      byte = 0xad
+	OpCheckMultisig       byte = 0xae
+	OpCheckMultisigVerify byte = 0xaf
+	OpCheckLockTimeVerify byte = 0xb1
+	OpCheckSequenceVerify byte = 0xb2
+	OpCheckDeadlineVerify byte = 0xb3
+)
+
+var (
+	ErrScriptSize      = errors.New("script: script too large")
+	ErrPushSize        = errors.New("script: item too large")
+	ErrStackSize       = errors.New("script: too many items on the stack")
+	ErrTooManyOps      = errors.New("script: too many opcodes")
+	ErrSignatureChecks = errors.New("script: too many signature checks")
+	ErrTruncated       = errors.New("script: push runs past the end of the script")
+	ErrUnknownOpcode   = errors.New("script: unknown opcode")
+	ErrNotPushOnly     = errors.New("script: unlocking script does more than push data")
+	ErrStackUnderflow  = errors.New("script: not enough items on the stack")
+	ErrUnbalanced      = errors.New("script: unbalanced OP_IF, OP_ELSE and OP_ENDIF")
+	ErrVerify          = errors.New("script: verify failed")
+	ErrReturn          = errors.New("script: OP_RETURN")
+	ErrInvalidNumber   = errors.New("script: invalid number")
+	ErrPublicKey       = errors.New("script: invalid public key")
+	ErrMultisigCount   = errors.New("script: invalid number of keys or signatures")
+	ErrNullFail        = errors.New("script: signature check failed with a non-empty signature")
+	ErrLockTime        = errors.New("script: locked until a later block")
+	ErrSequence        = errors.New("script: spent output is not old enough")
+	ErrDeadline        = errors.New("script: the deadline has passed")
+	ErrFalse           = errors.New("script: finished with false on the stack")
+	ErrCleanStack      = errors.New("script: finished with more than one item on the stack")
+	ErrEmptyStack      = errors.New("script: finished with an empty stack")
+)
+
+// What the scripts of an input are checked against
+type Context struct {
+	Digest       []byte // what the signatures sign, the TransactionID of the spending transaction
+	Height       uint64 // the Index of the block the spending transaction goes in
+	Confirmed    bool   // whether the spent output is in a block of the chain
+	OutputHeight uint64 // the Index of that block
+}
+
+type engine struct {
+	context         Context
+	stack           [][]byte
+	conditions      []bool // whether each of the open OP_IF branches runs
+	ops             int
+	signatureChecks int
+}
+
+// Check that unlocking satisfies locking
+func Verify(unlocking []byte, locking []byte, context Context) error {
+	if len(unlocking) > MaxScriptSize || len(locking) > MaxScriptSize {
+		return ErrScriptSize
+	}
+	if !IsPushOnly(unlocking) {
+		return ErrNotPushOnly
+	}
+
+	vm := engine{context: context}
+	if err := vm.run(unlocking); err != nil {
+		return err
+	}
+	if err := vm.run(locking); err != nil {
+		return err
+	}
+
+	switch {
+	case len(vm.stack) == 0:
+		return ErrEmptyStack
+	case len(vm.stack) > 1:
+		return ErrCleanStack
+	case !isTrue(vm.stack[0]):
+		return ErrFalse
+	}
+	return nil
+}
+
+// Whether the script is well formed and does nothing but push data
+func IsPushOnly(script []byte) bool {
+	for pc := 0; pc < len(script); {
+		_, data, next, err := parseOp(script, pc)
+		if err != nil || data == nil {
+			return false
+		}
+		pc = next
+	}
+	return true
+}
+
+/*
+Read the opcode at pc, along with the data it pushes. next is where the opcode after it starts.
+*/
+func parseOp(script []byte, pc int) (opcode byte, data []byte, next int, err error) {
+	opcode = script[pc]
+	pc++
+
+	length := 0
+	switch {
+	case opcode == Op0:
+		return opcode, []byte{}, pc, nil
+	case opcode < OpPushData1:
+		length = int(opcode)
+	case opcode == OpPushData1:
+		if pc+1 > len(script) {
+			return opcode, nil, pc, ErrTruncated
+		}
+		length = int(script[pc])
+		pc++
+	case opcode == OpPushData2:
+		if pc+2 > len(script) {
+			return opcode, nil, pc, ErrTruncated
+		}
+		length = int(binary.BigEndian.Uint16(script[pc:]))
+		pc += 2
+	case opcode >= Op1 && opcode <= Op16:
+		return opcode, []byte{opcode - Op1 + 1}, pc, nil
+	default:
+		return opcode, nil, pc, nil
+	}
+
+	if pc+length > len(script) {
+		return opcode, nil, pc, ErrTruncated
+	}
+	if length > MaxPushSize {
+		return opcode, nil, pc, ErrPushSize
+	}
+	return opcode, script[pc : pc+length], pc + length, nil
+}
+
+func (vm *engine) run(script []byte) error {
+	for pc := 0; pc < len(script); {
+		opcode, data, next, err := parseOp(script, pc)
+		if err != nil {
+			return fmt.Errorf("%w (at byte %d)", err, pc)
+		}
+
+		if err := vm.step(opcode, data); err != nil {
+			return fmt.Errorf("%w (%v at byte %d)", err, OpcodeName(opcode), pc)
+		}
+		if len(vm.stack) > MaxStackSize {
+			return ErrStackSize
+		}
+		pc = next
+	}
+
+	// A branch must end in the script it started in
+	if len(vm.conditions) != 0 {
+		return ErrUnbalanced
+	}
+	return nil
+}
+
+// Whether the opcode is in a branch that runs
+func (vm *engine) executing() bool {
+	for _, condition := range vm.conditions {
+		if !condition {
+			return false
+		}
+	}
+	return true
+}
+
+func (vm *engine) step(opcode byte, data []byte) error {
+	if data != nil {
+		if vm.executing() {
+			vm.push(data)
+		}
+		return nil
+	}
+
+	vm.ops++
+	if vm.ops > MaxOps {
+		return ErrTooManyOps
+	}
+
+	switch opcode {
+	case OpIf, OpNotIf:
+		condition := false
+		if vm.executing() {
+			item, err := vm.pop()
+			if err != nil {
+				return err
+			}
+			condition = isTrue(item) == (opcode == OpIf)
+		}
+		vm.conditions = append(vm.conditions, condition)
+		return nil
+
+	case OpElse:
+		if len(vm.conditions) == 0 {
+			return ErrUnbalanced
+		}
+		vm.conditions[len(vm.conditions)-1] = !vm.conditions[len(vm.conditions)-1]
+		return nil
+
+	case OpEndIf:
+		if len(vm.conditions) == 0 {
+			return ErrUnbalanced
+		}
+		vm.conditions = vm.conditions[:len(vm.conditions)-1]
+		return nil
+	}
+
+	if _, known := opcodeNames[opcode]; !known {
+		return ErrUnknownOpcode
+	}
+	if !vm.executing() {
+		return nil
+	}
+
+	switch opcode {
+	case OpVerify:
+		return vm.verify()
+
+	case OpReturn:
+		return ErrReturn
+
+	case OpDrop:
+		_, err := vm.pop()
+		return err
+
+	case OpDup:
+		item, err := vm.peek()
+		if err != nil {
+			return err
+		}
+		vm.push(item)
+
+	case OpSwap:
+		if len(vm.stack) < 2 {
+			return ErrStackUnderflow
+		}
+		top := len(vm.stack) - 1
+		vm.stack[top], vm.stack[top-1] = vm.stack[top-1], vm.stack[top]
+
+	case OpEqual, OpEqualVerify:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.pushBool(bytes.Equal(a, b))
+		if opcode == OpEqualVerify {
+			return vm.verify()
+		}
+
+	case OpSHA256, OpHash160:
+		item, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(item)
+		if opcode == OpHash160 {
+			vm.push(hash[:20])
+		} else {
+			vm.push(hash[:])
+		}
+
+	case OpCheckSig, OpCheckSigVerify:
+		if err := vm.checkSig(); err != nil {
+			return err
+		}
+		if opcode == OpCheckSigVerify {
+			return vm.verify()
+		}
+
+	case OpCheckMultisig, OpCheckMultisigVerify:
+		if err := vm.checkMultisig(); err != nil {
+			return err
+		}
+		if opcode == OpCheckMultisigVerify {
+			return vm.verify()
+		}
+
+	case OpCheckLockTimeVerify:
+		height, err := vm.peekNumber()
+		if err != nil {
+			return err
+		}
+		if vm.context.Height < height {
+			return ErrLockTime
+		}
+
+	case OpCheckSequenceVerify:
+		blocks, err := vm.peekNumber()
+		if err != nil {
+			return err
+		}
+		if !vm.context.Confirmed || vm.context.Height < vm.context.OutputHeight || vm.context.Height-vm.context.OutputHeight < blocks {
+			return ErrSequence
+		}
+
+	case OpCheckDeadlineVerify:
+		height, err := vm.peekNumber()
+		if err != nil {
+			return err
+		}
+		if vm.context.Height >= height {
+			return ErrDeadline
+		}
+	}
+	return nil
+}
+
+func (vm *engine) push(item []byte) {
+	vm.stack = append(vm.stack, item)
+}
+
+func (vm *engine) pushBool(value bool) {
+	if value {
+		vm.push([]byte{1})
+	} else {
+		vm.push([]byte{})
+	}
+}
+
+func (vm *engine) peek() ([]byte, error) {
+	if len(vm.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	return vm.stack[len(vm.stack)-1], nil
+}
+
+func (vm *engine) pop() ([]byte, error) {
+	item, err := vm.peek()
+	if err != nil {
+		return nil, err
+	}
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return item, nil
+}
+
+func (vm *engine) peekNumber() (uint64, error) {
+	item, err := vm.peek()
+	if err != nil {
+		return 0, err
+	}
+	return decodeNumber(item)
+}
+
+func (vm *engine) popNumber() (uint64, error) {
+	item, err := vm.pop()
+	if err != nil {
+		return 0, err
+	}
+	return decodeNumber(item)
+}
+
+func (vm *engine) verify() error {
+	item, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if !isTrue(item) {
+		return ErrVerify
+	}
+	return nil
+}
+
+func (vm *engine) countSignatureChecks(n int) error {
+	vm.signatureChecks += n
+	if vm.signatureChecks > MaxSignatureChecks {
+		return ErrSignatureChecks
+	}
+	return nil
+}
+
+func (vm *engine) checkSig() error {
+	encodedKey, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	sig, err := vm.pop()
+	if err != nil {
+		return err
+	}
+	if err := vm.countSignatureChecks(1); err != nil {
+		return err
+	}
+
+	publicKey, err := DecodePublicKey(encodedKey)
+	if err != nil {
+		return err
+	}
+
+	valid := len(sig) != 0 && publicKey.Verify(vm.context.Digest, sig)
+	if !valid && len(sig) != 0 {
+		return ErrNullFail
+	}
+	vm.pushBool(valid)
+	return nil
+}
+
+func (vm *engine) checkMultisig() error {
+	n, err := vm.popNumber()
+	if err != nil {
+		return err
+	}
+	if n > MaxMultisigKeys || int(n) > len(vm.stack) {
+		return ErrMultisigCount
+	}
+	if err := vm.countSignatureChecks(int(n)); err != nil {
+		return err
+	}
+
+	// The first key is the deepest on the stack
+	publicKeys := make([]signature.PublicKey, n)
+	for i := int(n) - 1; i >= 0; i-- {
+		encodedKey, _ := vm.pop()
+		if publicKeys[i], err = DecodePublicKey(encodedKey); err != nil {
+			return err
+		}
+	}
+
+	m, err := vm.popNumber()
+	if err != nil {
+		return err
+	}
+	if m > n || int(m) > len(vm.stack) {
+		return ErrMultisigCount
+	}
+	signatures := make([][]byte, m)
+	for i := int(m) - 1; i >= 0; i-- {
+		signatures[i], _ = vm.pop()
+	}
+
+	// Every signature must match one of the keys after the key of the signature before it
+	valid := true
+	key := 0
+	for _, sig := range signatures {
+		for key < len(publicKeys) && (len(sig) == 0 || !publicKeys[key].Verify(vm.context.Digest, sig)) {
+			key++
+		}
+		if key == len(publicKeys) {
+			valid = false
+			break
+		}
+		key++
+	}
+
+	if !valid {
+		for _, sig := range signatures {
+			if len(sig) != 0 {
+				return ErrNullFail
+			}
+		}
+	}
+	vm.pushBool(valid)
+	return nil
+}
+
+func isTrue(item []byte) bool {
+	for _, b := range item {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeNumber(item []byte) (uint64, error) {
+	if len(item) > MaxNumberSize || (len(item) > 0 && item[0] == 0) {
+		return 0, ErrInvalidNumber
+	}
+	var number uint64
+	for _, b := range item {
+		number = number<<8 | uint64(b)
+	}
+	return number, nil
+}
+
+// The shortest encoding of the number
+func EncodeNumber(number uint64) []byte {
+	item := make([]byte, 0, MaxNumberSize)
+	for ; number > 0; number >>= 8 {
+		item = append([]byte{byte(number)}, item...)
+	}
+	return item
+}
+
+// A public key as scripts push it, the scheme byte followed by the key
+func EncodePublicKey(publicKey signature.PublicKey) []byte {
+	return append([]byte{byte(publicKey.Scheme)}, publicKey.Key...)
+}
+
+func DecodePublicKey(encoded []byte) (signature.PublicKey, error) {
+	if len(encoded) < 2 {
+		return signature.PublicKey{}, ErrPublicKey
+	}
+	return signature.PublicKey{Scheme: signature.Scheme(encoded[0]), Key: encoded[1:]}, nil
+}
+
+// What OP_HASH160 gives for the public key, the hash of a pay-to-pubkey-hash script
+func PublicKeyHash(publicKey signature.PublicKey) [20]byte {
+	var hash [20]byte
+	sum := sha256.Sum256(EncodePublicKey(publicKey))
+	copy(hash[:], sum[:])
+	return hash
+}
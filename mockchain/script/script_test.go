@@ -0,0 +1,23 @@
+package script
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The vectors are shared with the script test command and with other implementations of the language
+func TestVectors(t *testing.T) {
+	vectors, err := ReadVectors("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index, vector := range vectors {
+		vector := vector
+		t.Run(fmt.Sprintf("%d %v", index, vector.Comment), func(t *testing.T) {
+			if err := vector.Run(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package script
+
+import (
+	"bytes"
+
+	"noobcash/signature"
+)
+
+/*
+Locking scripts for the usual ways of spending coins. Each of them is unlocked by pushing the
+signatures, followed by the public key for a pay-to-pubkey-hash.
+*/
+
+// <signature> <publicKey> | OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG
+func PayToPublicKeyHash(hash [20]byte) []byte {
+	var builder Builder
+	builder.AddOp(OpDup, OpHash160).AddData(hash[:]).AddOp(OpEqualVerify, OpCheckSig)
+	return builder.Script()
+}
+
+// <signature> ... | m <publicKey> ... n OP_CHECKMULTISIG
+func PayToMultisig(required int, publicKeys []signature.PublicKey) []byte {
+	var builder Builder
+	builder.AddNumber(uint64(required))
+	for _, publicKey := range publicKeys {
+		builder.AddData(EncodePublicKey(publicKey))
+	}
+	builder.AddNumber(uint64(len(publicKeys))).AddOp(OpCheckMultisig)
+	return builder.Script()
+}
+
+/*
+A hash time-locked contract pays Receiver once they reveal a preimage of Hash, and goes back to
+Sender from the block with Index Timeout on. The receiver can only claim the coins before then,
+so that once the sender can take them back, nobody else can:
+
+	OP_IF
+		<Timeout> OP_CHECKDEADLINEVERIFY OP_DROP OP_SHA256 <Hash> OP_EQUALVERIFY <Receiver>
+	OP_ELSE
+		<Timeout> OP_CHECKLOCKTIMEVERIFY OP_DROP <Sender>
+	OP_ENDIF
+	OP_CHECKSIG
+
+The receiver unlocks it with <signature> <preimage> OP_1, and the sender with <signature> OP_0.
+*/
+type HashTimeLock struct {
+	Hash     [32]byte
+	Receiver signature.PublicKey
+	Sender   signature.PublicKey
+	Timeout  uint64
+}
+
+func (htlc HashTimeLock) Script() []byte {
+	var builder Builder
+	builder.AddOp(OpIf).AddNumber(htlc.Timeout).AddOp(OpCheckDeadlineVerify, OpDrop)
+	builder.AddOp(OpSHA256).AddData(htlc.Hash[:]).AddOp(OpEqualVerify).AddData(EncodePublicKey(htlc.Receiver))
+	builder.AddOp(OpElse).AddNumber(htlc.Timeout).AddOp(OpCheckLockTimeVerify, OpDrop).AddData(EncodePublicKey(htlc.Sender))
+	builder.AddOp(OpEndIf, OpCheckSig)
+	return builder.Script()
+}
+
+// The contract, if the locking script is one
+func ParseHashTimeLock(lockingScript []byte) (HashTimeLock, bool) {
+	var htlc HashTimeLock
+	var items [][]byte
+	for pc := 0; pc < len(lockingScript); {
+		_, data, next, err := parseOp(lockingScript, pc)
+		if err != nil {
+			return htlc, false
+		}
+		if data != nil {
+			items = append(items, data)
+		}
+		pc = next
+	}
+	if len(items) != 5 || len(items[1]) != len(htlc.Hash) {
+		return htlc, false
+	}
+
+	var err error
+	if htlc.Timeout, err = decodeNumber(items[0]); err != nil {
+		return htlc, false
+	}
+	copy(htlc.Hash[:], items[1])
+	if htlc.Receiver, err = DecodePublicKey(items[2]); err != nil {
+		return htlc, false
+	}
+	if htlc.Sender, err = DecodePublicKey(items[4]); err != nil {
+		return htlc, false
+	}
+
+	// Whatever the pushes are, the opcodes around them and the second timeout must be the ones of the contract
+	if !bytes.Equal(htlc.Script(), lockingScript) {
+		return htlc, false
+	}
+	return htlc, true
+}
+
+func ClaimHashTimeLock(receiverSignature []byte, preimage []byte) []byte {
+	var builder Builder
+	builder.AddData(receiverSignature).AddData(preimage).AddNumber(1)
+	return builder.Script()
+}
+
+func RefundHashTimeLock(senderSignature []byte) []byte {
+	var builder Builder
+	builder.AddData(senderSignature).AddNumber(0)
+	return builder.Script()
+}
+
+// The preimage revealed by the unlocking script, if it is the claim of a contract
+func HashTimeLockPreimage(unlockingScript []byte) ([]byte, bool) {
+	var items [][]byte
+	for pc := 0; pc < len(unlockingScript); {
+		_, data, next, err := parseOp(unlockingScript, pc)
+		if err != nil || data == nil {
+			return nil, false
+		}
+		items = append(items, data)
+		pc = next
+	}
+	if len(items) != 3 || !isTrue(items[2]) {
+		return nil, false
+	}
+	return items[1], true
+}
@@ -0,0 +1,112 @@
+package script
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+/*
+The test vectors of the language are kept in testdata/vectors.json, so that any other
+implementation can be checked against the same cases. Each vector gives both scripts in the words
+of Assemble, or in hex for scripts Assemble wouldn't write, the heights of the Context, and the
+name of the error the spend must fail with, or "OK" if it must succeed. Every signature in the
+vectors signs VectorDigest.
+*/
+type Vector struct {
+	Comment      string
+	Unlocking    string
+	Locking      string
+	UnlockingHex string `json:",omitempty"`
+	LockingHex   string `json:",omitempty"`
+	Height       uint64
+	Confirmed    bool
+	OutputHeight uint64
+	Expect       string
+}
+
+var VectorDigest = sha256.Sum256([]byte("noobcash script test vectors"))
+
+var errorsByName = map[string]error{
+	"ErrScriptSize":      ErrScriptSize,
+	"ErrPushSize":        ErrPushSize,
+	"ErrStackSize":       ErrStackSize,
+	"ErrTooManyOps":      ErrTooManyOps,
+	"ErrSignatureChecks": ErrSignatureChecks,
+	"ErrTruncated":       ErrTruncated,
+	"ErrUnknownOpcode":   ErrUnknownOpcode,
+	"ErrNotPushOnly":     ErrNotPushOnly,
+	"ErrStackUnderflow":  ErrStackUnderflow,
+	"ErrUnbalanced":      ErrUnbalanced,
+	"ErrVerify":          ErrVerify,
+	"ErrReturn":          ErrReturn,
+	"ErrInvalidNumber":   ErrInvalidNumber,
+	"ErrPublicKey":       ErrPublicKey,
+	"ErrMultisigCount":   ErrMultisigCount,
+	"ErrNullFail":        ErrNullFail,
+	"ErrLockTime":        ErrLockTime,
+	"ErrSequence":        ErrSequence,
+	"ErrDeadline":        ErrDeadline,
+	"ErrFalse":           ErrFalse,
+	"ErrCleanStack":      ErrCleanStack,
+	"ErrEmptyStack":      ErrEmptyStack,
+}
+
+func ReadVectors(path string) ([]Vector, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var vectors []Vector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return vectors, nil
+}
+
+// Run the vector, with an error if the outcome is not the expected one
+func (vector Vector) Run() error {
+	unlocking, err := vectorScript(vector.Unlocking, vector.UnlockingHex)
+	if err != nil {
+		return fmt.Errorf("unlocking script: %w", err)
+	}
+	locking, err := vectorScript(vector.Locking, vector.LockingHex)
+	if err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+
+	context := Context{
+		Digest:       VectorDigest[:],
+		Height:       vector.Height,
+		Confirmed:    vector.Confirmed,
+		OutputHeight: vector.OutputHeight,
+	}
+	err = Verify(unlocking, locking, context)
+
+	if vector.Expect == "OK" {
+		if err != nil {
+			return fmt.Errorf("expected OK, got %w", err)
+		}
+		return nil
+	}
+
+	expected, ok := errorsByName[vector.Expect]
+	if !ok {
+		return fmt.Errorf("unknown expected error %v", vector.Expect)
+	}
+	if !errors.Is(err, expected) {
+		return fmt.Errorf("expected %v, got %v", expected, err)
+	}
+	return nil
+}
+
+func vectorScript(words string, hexScript string) ([]byte, error) {
+	if hexScript != "" {
+		return hex.DecodeString(hexScript)
+	}
+	return Assemble(words)
+}
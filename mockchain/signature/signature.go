@@ -0,0 +1,269 @@
+/*
+Package signature signs and verifies transactions with one of several signature schemes:
+
+	rsa        RSA-2048 PKCS#1 v1.5 signatures of SHA-256 digests, what nodes used before there
+	           were schemes to choose from
+	ed25519    Ed25519 signatures
+	secp256k1  ECDSA over the secp256k1 curve, as in Bitcoin
+
+Public keys and signatures are tagged with the scheme they belong to, so that nodes with keys of
+different schemes can take part in the same network. A public key is its scheme and the encoding
+of the key for that scheme:
+
+	rsa        PKCS#1 DER of the public key
+	ed25519    the 32 byte key
+	secp256k1  the 33 byte compressed point
+
+and a signature is the scheme byte followed by the signature: the PKCS#1 v1.5 signature for rsa,
+64 bytes for ed25519 and the DER encoded ECDSA signature, with a low S, for secp256k1.
+
+Every scheme signs a 32 byte digest that the caller has already computed.
+*/
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+type Scheme uint8
+
+const (
+	RSA Scheme = iota + 1
+	Ed25519
+	Secp256k1
+)
+
+const rsaBits = 2048
+
+// PEM block type of secp256k1 keys, which crypto/x509 doesn't know about
+const secp256k1PEMType = "SECP256K1 PRIVATE KEY"
+
+var Schemes = []Scheme{RSA, Ed25519, Secp256k1}
+
+var ErrUnknownScheme = errors.New("signature: unknown scheme")
+
+func (scheme Scheme) String() string {
+	switch scheme {
+	case RSA:
+		return "rsa"
+	case Ed25519:
+		return "ed25519"
+	case Secp256k1:
+		return "secp256k1"
+	default:
+		return fmt.Sprintf("scheme %d", uint8(scheme))
+	}
+}
+
+func ParseScheme(name string) (Scheme, error) {
+	names := make([]string, 0, len(Schemes))
+	for _, scheme := range Schemes {
+		if scheme.String() == name {
+			return scheme, nil
+		}
+		names = append(names, scheme.String())
+	}
+	return 0, fmt.Errorf("unknown signature scheme %v, must be one of %v", name, strings.Join(names, ", "))
+}
+
+type PublicKey struct {
+	Scheme Scheme
+	Key    []byte
+}
+
+// The zero PublicKey stands for no key at all
+func (publicKey PublicKey) IsZero() bool {
+	return publicKey.Scheme == 0 && len(publicKey.Key) == 0
+}
+
+func (publicKey PublicKey) Equal(other PublicKey) bool {
+	return !publicKey.IsZero() && publicKey.Scheme == other.Scheme && bytes.Equal(publicKey.Key, other.Key)
+}
+
+// Whether signature is a valid signature of digest by the key
+func (publicKey PublicKey) Verify(digest []byte, signature []byte) bool {
+	if len(signature) < 1 || Scheme(signature[0]) != publicKey.Scheme {
+		return false
+	}
+	signature = signature[1:]
+
+	switch publicKey.Scheme {
+	case RSA:
+		rsaPublicKey, err := x509.ParsePKCS1PublicKey(publicKey.Key)
+		if err != nil {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(rsaPublicKey, crypto.SHA256, digest, signature) == nil
+
+	case Ed25519:
+		if len(publicKey.Key) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(ed25519.PublicKey(publicKey.Key), digest, signature)
+
+	case Secp256k1:
+		secpPublicKey, err := secp256k1.ParsePubKey(publicKey.Key)
+		if err != nil {
+			return false
+		}
+		ecdsaSignature, err := ecdsa.ParseDERSignature(signature)
+		if err != nil {
+			return false
+		}
+		return ecdsaSignature.Verify(digest, secpPublicKey)
+
+	default:
+		return false
+	}
+}
+
+// Only one of the keys is set, the one of the scheme
+type PrivateKey struct {
+	scheme    Scheme
+	rsa       *rsa.PrivateKey
+	ed25519   ed25519.PrivateKey
+	secp256k1 *secp256k1.PrivateKey
+}
+
+func GenerateKey(scheme Scheme) (*PrivateKey, error) {
+	privateKey := &PrivateKey{scheme: scheme}
+
+	var err error
+	switch scheme {
+	case RSA:
+		privateKey.rsa, err = rsa.GenerateKey(rand.Reader, rsaBits)
+	case Ed25519:
+		_, privateKey.ed25519, err = ed25519.GenerateKey(rand.Reader)
+	case Secp256k1:
+		privateKey.secp256k1, err = secp256k1.GeneratePrivateKey()
+	default:
+		err = ErrUnknownScheme
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return privateKey, nil
+}
+
+// Wrap an RSA key, like the ones nodes had before there were other schemes
+func FromRSA(rsaPrivateKey *rsa.PrivateKey) *PrivateKey {
+	return &PrivateKey{scheme: RSA, rsa: rsaPrivateKey}
+}
+
+func (privateKey *PrivateKey) Scheme() Scheme {
+	return privateKey.scheme
+}
+
+func (privateKey *PrivateKey) Public() PublicKey {
+	switch privateKey.scheme {
+	case RSA:
+		return PublicKey{RSA, x509.MarshalPKCS1PublicKey(&privateKey.rsa.PublicKey)}
+	case Ed25519:
+		return PublicKey{Ed25519, []byte(privateKey.ed25519.Public().(ed25519.PublicKey))}
+	case Secp256k1:
+		return PublicKey{Secp256k1, privateKey.secp256k1.PubKey().SerializeCompressed()}
+	default:
+		return PublicKey{}
+	}
+}
+
+// Sign a digest, returning the scheme byte followed by the signature
+func (privateKey *PrivateKey) Sign(digest []byte) ([]byte, error) {
+	var signature []byte
+	var err error
+
+	switch privateKey.scheme {
+	case RSA:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, privateKey.rsa, crypto.SHA256, digest)
+	case Ed25519:
+		signature = ed25519.Sign(privateKey.ed25519, digest)
+	case Secp256k1:
+		signature = ecdsa.Sign(privateKey.secp256k1, digest).Serialize()
+	default:
+		err = ErrUnknownScheme
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{byte(privateKey.scheme)}, signature...), nil
+}
+
+/*
+The private key in PEM: PKCS#1 for rsa, so that the key files written before there were schemes
+still load, PKCS#8 for ed25519 and the 32 byte scalar in a SECP256K1 PRIVATE KEY block for
+secp256k1.
+*/
+func (privateKey *PrivateKey) MarshalPEM() []byte {
+	var block pem.Block
+
+	switch privateKey.scheme {
+	case RSA:
+		block = pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey.rsa)}
+	case Ed25519:
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey.ed25519)
+		if err != nil {
+			return nil
+		}
+		block = pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	case Secp256k1:
+		block = pem.Block{Type: secp256k1PEMType, Bytes: privateKey.secp256k1.Serialize()}
+	default:
+		return nil
+	}
+
+	return pem.EncodeToMemory(&block)
+}
+
+// Read a key written by MarshalPEM, or an RSA or Ed25519 key in PKCS#8
+func ParsePEM(keyPEM []byte) (*PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("signature: no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		rsaPrivateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return FromRSA(rsaPrivateKey), nil
+
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch key := parsed.(type) {
+		case *rsa.PrivateKey:
+			return FromRSA(key), nil
+		case ed25519.PrivateKey:
+			return &PrivateKey{scheme: Ed25519, ed25519: key}, nil
+		default:
+			return nil, errors.New("signature: only RSA and Ed25519 PKCS#8 keys are supported")
+		}
+
+	case secp256k1PEMType:
+		if len(block.Bytes) != secp256k1.PrivKeyBytesLen {
+			return nil, errors.New("signature: invalid secp256k1 key")
+		}
+		return &PrivateKey{scheme: Secp256k1, secp256k1: secp256k1.PrivKeyFromBytes(block.Bytes)}, nil
+
+	default:
+		return nil, errors.New("signature: unsupported PEM block " + block.Type)
+	}
+}
@@ -0,0 +1,180 @@
+package signature
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"testing"
+)
+
+/*
+The benchmarks compare the schemes on what a node does with every block: signing its transactions
+when they are made and verifying them when the block arrives. One op is a block of transactions,
+and the sizes of the key and the signature, which end up in every message, are reported with it.
+*/
+var blockSizes = []int{1, 10, 100}
+
+// The TransactionIDs of a block, each a different digest
+func blockDigests(transactions int) [][]byte {
+	digests := make([][]byte, transactions)
+	for i := range digests {
+		var body [32]byte
+		_, _ = rand.Read(body[:])
+		digest := sha256.Sum256(body[:])
+		digests[i] = digest[:]
+	}
+	return digests
+}
+
+func testKey(tb testing.TB, scheme Scheme) *PrivateKey {
+	privateKey, err := GenerateKey(scheme)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return privateKey
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, scheme := range Schemes {
+		privateKey := testKey(t, scheme)
+		for _, digest := range blockDigests(3) {
+			signature, err := privateKey.Sign(digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Scheme(signature[0]) != scheme {
+				t.Errorf("%v signature tagged with scheme %v", scheme, signature[0])
+			}
+			if !privateKey.Public().Verify(digest, signature) {
+				t.Errorf("%v signature doesn't verify", scheme)
+			}
+		}
+	}
+}
+
+// A signature only verifies with the key that made it, for the digest it was made for
+func TestVerifyRejects(t *testing.T) {
+	keys := make(map[Scheme]*PrivateKey)
+	for _, scheme := range Schemes {
+		keys[scheme] = testKey(t, scheme)
+	}
+	digest := blockDigests(1)[0]
+
+	for _, scheme := range Schemes {
+		privateKey := keys[scheme]
+		publicKey := privateKey.Public()
+		signature, err := privateKey.Sign(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run(scheme.String(), func(t *testing.T) {
+			if testKey(t, scheme).Public().Verify(digest, signature) {
+				t.Error("verifies with another key of the scheme")
+			}
+
+			tampered := append([]byte{}, digest...)
+			tampered[0] ^= 1
+			if publicKey.Verify(tampered, signature) {
+				t.Error("verifies for a tampered digest")
+			}
+
+			changed := append([]byte{}, signature...)
+			changed[len(changed)-1] ^= 1
+			if publicKey.Verify(digest, changed) {
+				t.Error("verifies a tampered signature")
+			}
+			if publicKey.Verify(digest, nil) || publicKey.Verify(digest, signature[:1]) || publicKey.Verify(digest, signature[:len(signature)-1]) {
+				t.Error("verifies an empty or truncated signature")
+			}
+
+			// The signature of another scheme fails with its own tag, and with ours in its place
+			for _, other := range Schemes {
+				if other == scheme {
+					continue
+				}
+				otherSignature, err := keys[other].Sign(digest)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if publicKey.Verify(digest, otherSignature) {
+					t.Errorf("verifies a %v signature", other)
+				}
+				retagged := append([]byte{byte(scheme)}, otherSignature[1:]...)
+				if publicKey.Verify(digest, retagged) {
+					t.Errorf("verifies a %v signature tagged as %v", other, scheme)
+				}
+			}
+
+			malformed := []PublicKey{
+				{Scheme: scheme, Key: publicKey.Key[:len(publicKey.Key)-1]},
+				{Scheme: scheme, Key: append(append([]byte{}, publicKey.Key...), 0)},
+				{Scheme: scheme, Key: blockDigests(1)[0]},
+				{Scheme: scheme},
+				{},
+			}
+			for _, key := range malformed {
+				if key.Verify(digest, signature) {
+					t.Errorf("verifies with the malformed key %x", key.Key)
+				}
+			}
+		})
+	}
+}
+
+func reportSizes(b *testing.B, privateKey *PrivateKey, signature []byte) {
+	b.ReportMetric(float64(len(privateKey.Public().Key)), "key-bytes")
+	b.ReportMetric(float64(len(signature)), "sig-bytes")
+}
+
+func BenchmarkSign(b *testing.B) {
+	for _, scheme := range Schemes {
+		privateKey := testKey(b, scheme)
+
+		for _, transactions := range blockSizes {
+			digests := blockDigests(transactions)
+
+			b.Run(fmt.Sprintf("%v/%d", scheme, transactions), func(b *testing.B) {
+				var signature []byte
+				var err error
+				for n := 0; n < b.N; n++ {
+					for _, digest := range digests {
+						if signature, err = privateKey.Sign(digest); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+				reportSizes(b, privateKey, signature)
+			})
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	for _, scheme := range Schemes {
+		privateKey := testKey(b, scheme)
+		publicKey := privateKey.Public()
+
+		for _, transactions := range blockSizes {
+			digests := blockDigests(transactions)
+			signatures := make([][]byte, transactions)
+			for i, digest := range digests {
+				var err error
+				if signatures[i], err = privateKey.Sign(digest); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.Run(fmt.Sprintf("%v/%d", scheme, transactions), func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					for i, digest := range digests {
+						if !publicKey.Verify(digest, signatures[i]) {
+							b.Fatalf("%v signature doesn't verify", scheme)
+						}
+					}
+				}
+				reportSizes(b, privateKey, signatures[0])
+			})
+		}
+	}
+}
@@ -1,6 +1,6 @@
 package main
 
-import "crypto/rsa"
+import "noobcash/signature"
 
 /*
 Μην αλλάξετε το capitalization των λέξεων, πρέπει να ξεκινάνε με
@@ -9,31 +9,63 @@ extracted τα variables
 */
 
 type Transaction struct {
-	SenderAddress      rsa.PublicKey
-	ReceiverAddress    rsa.PublicKey
-	Amount             uint
+	SenderAddress      signature.PublicKey
+	Multisig           *MultisigPolicy // set when spending from a multisig address, instead of SenderAddress
+	Fee                uint            // inputs minus outputs, collected by the miner of the block
 	TransactionID      [32]byte
 	TransactionInputs  []TransactionInput
-	TransactionOutputs [2]TransactionOutput
+	TransactionOutputs []TransactionOutput // the payments, and the change back to the sender
 }
 
 type SignedTransaction struct {
-	SenderAddress      rsa.PublicKey
-	ReceiverAddress    rsa.PublicKey
-	Amount             uint
+	SenderAddress      signature.PublicKey
+	Multisig           *MultisigPolicy // set when spending from a multisig address, instead of SenderAddress
+	Fee                uint            // inputs minus outputs, collected by the miner of the block
 	TransactionID      [32]byte
 	TransactionInputs  []TransactionInput
-	TransactionOutputs [2]TransactionOutput
-	Signature          []byte
+	TransactionOutputs []TransactionOutput // the payments, and the change back to the sender
+	Signature          []byte              // the scheme of the sender's key followed by the signature
+	Cosignatures       []Cosignature       // the signatures of a multisig spend, in place of Signature
 }
 
 type TransactionInput struct {
 	PreviousOutputID [32]byte // reference to TransactionOutputs -> transactionId
+	UnlockingScript  []byte   // satisfies the locking script of the output, if it has one
 }
 
 type TransactionOutput struct {
 	ID               [32]byte
-	TransactionID    [32]byte      // the id of the transaction this output was created in
-	RecipientAddress rsa.PublicKey // also known as the new owner of these coins.
-	Amount           uint          // the amount of coins they own
+	TransactionID    [32]byte // the id of the transaction this output was created in
+	RecipientAddress Address  // also known as the new owner of these coins.
+	Amount           uint     // the amount of coins they own
+	LockingScript    []byte   // what spending the coins takes, if not the key or multisig of RecipientAddress
+}
+
+// The address the transaction spends from: the sender's key, or the multisig
+func (signedTransaction *SignedTransaction) senderAddress() Address {
+	if signedTransaction.Multisig != nil {
+		return signedTransaction.Multisig.address()
+	}
+	return addressOf(signedTransaction.SenderAddress)
+}
+
+// The outputs that go to somebody other than the sender, that is everything but the change
+func (signedTransaction *SignedTransaction) payments() []TransactionOutput {
+	payments := make([]TransactionOutput, 0, len(signedTransaction.TransactionOutputs))
+	senderAddress := signedTransaction.senderAddress()
+	for _, transactionOutput := range signedTransaction.TransactionOutputs {
+		if transactionOutput.RecipientAddress != senderAddress {
+			payments = append(payments, transactionOutput)
+		}
+	}
+	return payments
+}
+
+// The coins the transaction sends to others
+func (signedTransaction *SignedTransaction) amountPaid() uint {
+	var amount uint = 0
+	for _, transactionOutput := range signedTransaction.payments() {
+		amount += transactionOutput.Amount
+	}
+	return amount
 }
@@ -0,0 +1,94 @@
+package main
+
+/*
+The unspent outputs, by ID and by the address they pay to, so that the balance and the coins of
+an address are found without going over the whole set.
+*/
+type UTXOSet struct {
+	outputs   map[[32]byte]TransactionOutput
+	byAddress map[Address]map[[32]byte]bool
+}
+
+func NewUTXOSet() *UTXOSet {
+	return &UTXOSet{
+		outputs:   make(map[[32]byte]TransactionOutput),
+		byAddress: make(map[Address]map[[32]byte]bool),
+	}
+}
+
+func (set *UTXOSet) Get(id [32]byte) (TransactionOutput, bool) {
+	transactionOutput, ok := set.outputs[id]
+	return transactionOutput, ok
+}
+
+func (set *UTXOSet) Add(transactionOutput TransactionOutput) {
+	set.outputs[transactionOutput.ID] = transactionOutput
+
+	ids, ok := set.byAddress[transactionOutput.RecipientAddress]
+	if !ok {
+		ids = make(map[[32]byte]bool)
+		set.byAddress[transactionOutput.RecipientAddress] = ids
+	}
+	ids[transactionOutput.ID] = true
+}
+
+func (set *UTXOSet) Remove(id [32]byte) {
+	transactionOutput, ok := set.outputs[id]
+	if !ok {
+		return
+	}
+	delete(set.outputs, id)
+
+	ids := set.byAddress[transactionOutput.RecipientAddress]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(set.byAddress, transactionOutput.RecipientAddress)
+	}
+}
+
+// The unspent outputs that pay to address
+func (set *UTXOSet) OutputsOf(address Address) []TransactionOutput {
+	ids := set.byAddress[address]
+	transactionOutputs := make([]TransactionOutput, 0, len(ids))
+	for id := range ids {
+		transactionOutputs = append(transactionOutputs, set.outputs[id])
+	}
+	return transactionOutputs
+}
+
+// The unspent outputs that are locked with a script
+func (set *UTXOSet) ScriptOutputs() []TransactionOutput {
+	transactionOutputs := make([]TransactionOutput, 0)
+	for address := range set.byAddress {
+		if address.Type == ScriptAddress {
+			transactionOutputs = append(transactionOutputs, set.OutputsOf(address)...)
+		}
+	}
+	return transactionOutputs
+}
+
+func (set *UTXOSet) Balance(address Address) uint {
+	var amount uint = 0
+	for id := range set.byAddress[address] {
+		amount += set.outputs[id].Amount
+	}
+	return amount
+}
+
+func (set *UTXOSet) Copy() *UTXOSet {
+	copied := &UTXOSet{
+		outputs:   make(map[[32]byte]TransactionOutput, len(set.outputs)),
+		byAddress: make(map[Address]map[[32]byte]bool, len(set.byAddress)),
+	}
+	for id, transactionOutput := range set.outputs {
+		copied.outputs[id] = transactionOutput
+	}
+	for address, ids := range set.byAddress {
+		copiedIDs := make(map[[32]byte]bool, len(ids))
+		for id := range ids {
+			copiedIDs[id] = true
+		}
+		copied.byAddress[address] = copiedIDs
+	}
+	return copied
+}
@@ -49,3 +49,36 @@ func (s *UTXOStack) Pop() (TransactionOutput, error) {
 	s.UTXOs = s.UTXOs[1:]
 	return res, nil
 }
+
+func (s *UTXOStack) Remove(id [32]byte) {
+	s.stackLock.Lock()
+	defer s.stackLock.Unlock()
+
+	for i, utxo := range s.UTXOs {
+		if utxo.ID == id {
+			s.UTXOs = append(s.UTXOs[:i], s.UTXOs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *UTXOStack) Contains(id [32]byte) bool {
+	s.stackLock.Lock()
+	defer s.stackLock.Unlock()
+
+	for _, utxo := range s.UTXOs {
+		if utxo.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *UTXOStack) All() []TransactionOutput {
+	s.stackLock.Lock()
+	defer s.stackLock.Unlock()
+
+	UTXOs := make([]TransactionOutput, len(s.UTXOs))
+	copy(UTXOs, s.UTXOs)
+	return UTXOs
+}
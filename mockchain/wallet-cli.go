@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"noobcash/keystore"
+	"noobcash/signature"
+
+	"golang.org/x/term"
+)
+
+/*
+Everything that reads standard input shares one reader, so that the lines it buffers past a
+passphrase still reach the command line.
+*/
+var stdin = bufio.NewReader(os.Stdin)
+
+/*
+The passphrase of a key file is taken from the MOCKCHAIN_PASSPHRASE environment variable, so
+that nodes can be started from scripts. If it isn't set, we ask for it on the terminal without
+echoing it, or read a line of standard input if it isn't a terminal.
+*/
+func readPassphrase(prompt string) []byte {
+	if passphrase, ok := os.LookupEnv("MOCKCHAIN_PASSPHRASE"); ok {
+		return []byte(passphrase)
+	}
+
+	fmt.Print(prompt)
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			log.Fatal("readPassphrase: ", err)
+		}
+		return passphrase
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		log.Fatal("readPassphrase: ", err)
+	}
+	return []byte(strings.TrimRight(line, "\r\n"))
+}
+
+// A key is only as safe as its passphrase, so a new key file can't be left without one
+func readNewPassphrase(prompt string) []byte {
+	passphrase := readPassphrase(prompt)
+	if len(passphrase) == 0 {
+		log.Fatal("readNewPassphrase: the passphrase can't be empty")
+	}
+	return passphrase
+}
+
+/*
+wallet new|list|export|import manage the encrypted keys of a keystore directory. They run
+instead of a node and exit when they are done.
+*/
+func walletCommand(args []string) {
+	flags := flag.NewFlagSet("wallet", flag.ExitOnError)
+	keystoreDir := flags.String("keystore", "keystore", "Directory containing the encrypted key files")
+	schemeName := flags.String("scheme", "ed25519", "Signature scheme of a new key: rsa, ed25519 or secp256k1")
+	flags.Usage = walletHelp
+
+	if len(args) < 1 {
+		walletHelp()
+		os.Exit(2)
+	}
+	command := args[0]
+	flags.Parse(args[1:])
+
+	store, err := keystore.Open(*keystoreDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch {
+	case command == "new" && flags.NArg() == 1:
+		scheme, err := signature.ParseScheme(*schemeName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		privateKey, err := signature.GenerateKey(scheme)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		passphrase := readNewPassphrase("Passphrase for the new key: ")
+		if err := store.Save(flags.Arg(0), privateKey, passphrase); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Created", scheme, "key", flags.Arg(0), keystore.Fingerprint(privateKey.Public()), "in", store.Path(flags.Arg(0)))
+		fmt.Println("Address:", addressOf(privateKey.Public()))
+
+	case command == "list" && flags.NArg() == 0:
+		keys, err := store.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, key := range keys {
+			fmt.Println(key.Name, key.Scheme, key.Fingerprint, key.Path)
+		}
+
+	case command == "export" && flags.NArg() == 1:
+		privateKey, err := store.Load(flags.Arg(0), readPassphrase("Passphrase: "))
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(keystore.ExportPEM(privateKey))
+
+	case command == "import" && flags.NArg() == 2:
+		keyPEM, err := os.ReadFile(flags.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		privateKey, err := keystore.ImportPEM(keyPEM)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		passphrase := readNewPassphrase("Passphrase for the imported key: ")
+		if err := store.Save(flags.Arg(0), privateKey, passphrase); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Imported key", flags.Arg(0), keystore.Fingerprint(privateKey.Public()), "in", store.Path(flags.Arg(0)))
+
+	default:
+		walletHelp()
+		os.Exit(2)
+	}
+}
+
+func walletHelp() {
+	fmt.Println("usage: noobcash.elf wallet <command> [-keystore <dir>] [-scheme <scheme>] [arguments]")
+	fmt.Println("")
+
+	fmt.Println("wallet new <name>")
+	fmt.Println("\tcreates a new key of the -scheme signature scheme (rsa, ed25519 or secp256k1, default ed25519), encrypted with a passphrase")
+	fmt.Println("")
+
+	fmt.Println("wallet list")
+	fmt.Println("\tshows the name, signature scheme and fingerprint of every key in the keystore")
+	fmt.Println("")
+
+	fmt.Println("wallet export <name>")
+	fmt.Println("\twrites the key to stdout as an unencrypted PEM file")
+	fmt.Println("")
+
+	fmt.Println("wallet import <name> <file.pem>")
+	fmt.Println("\tencrypts the key of a PEM file and adds it to the keystore")
+	fmt.Println("")
+
+	fmt.Println("The passphrase is read from MOCKCHAIN_PASSPHRASE if it is set, and can't be empty for a new or imported key.")
+}